@@ -0,0 +1,220 @@
+package mstreamer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// BatchOptions bounds the batches of a batched output. A batch is flushed as soon as one of the
+// positive bounds is reached and at least one of them must be set
+type BatchOptions struct {
+	// MaxCount is the number of measures of a batch
+	MaxCount int
+	// MaxBytes is the size of a batch once decoded. The decoder runs ahead of the count, so a batch may
+	// exceed it by the few measures being decoded when the bound is reached
+	MaxBytes int
+	// MaxAge is the time a batch waits for more measures after its first one
+	MaxAge time.Duration
+}
+
+// NewBatchedOutput returns an Output that groups measures into batches, decodes every batch with dec
+// and hands it to snk as an independent stream. See NewBatchedOutputContext
+func NewBatchedOutput(dec Decoder, snk Sinker, opts BatchOptions) (Output, error) {
+	if dec == nil {
+		return nil, errors.New("decoder is nil")
+	}
+	if snk == nil {
+		return nil, errors.New("sinker is nil")
+	}
+	out, err := NewBatchedOutputContext(ContextDecoder(dec), ContextSinker(snk), opts)
+	if err != nil {
+		return nil, err
+	}
+	return func(f Feedback, r MeasureReader) error {
+		return out(context.Background(), f, r)
+	}, nil
+}
+
+// NewBatchedOutputContext returns an OutputContext that groups measures into batches bounded by opts.
+// Every batch is decoded by its own dec call and the decoded bytes are handed to a snk call once the batch
+// is flushed, so sinkers such as the HTTP one send a bounded request per batch.
+// Batches are sunk one at a time and the outcome of every batch is sent to the Feedback. Behind a disk
+// buffer, the measures of a batch are committed once the sinker accepted it
+func NewBatchedOutputContext(dec DecoderContext, snk SinkerContext, opts BatchOptions) (OutputContext, error) {
+	return newBatchedOutputContext(dec, snk, opts, ChanMeasurePipe)
+}
+
+func newBatchedOutputContext(dec DecoderContext, snk SinkerContext, opts BatchOptions, pipe MeasurePipe) (OutputContext, error) {
+	if dec == nil {
+		return nil, errors.New("decoder is nil")
+	}
+	if snk == nil {
+		return nil, errors.New("sinker is nil")
+	}
+	if pipe == nil {
+		return nil, errors.New("pipe is nil")
+	}
+	if opts.MaxCount < 0 || opts.MaxBytes < 0 || opts.MaxAge < 0 {
+		return nil, errors.New("batch bounds must not be negative")
+	}
+	if opts.MaxCount == 0 && opts.MaxBytes == 0 && opts.MaxAge == 0 {
+		return nil, errors.New("batch needs at least one of max count, max bytes or max age")
+	}
+	return func(ctx context.Context, f Feedback, r MeasureReader) error {
+		if ctx == nil {
+			return errors.New("context is nil")
+		}
+		if f == nil {
+			return errors.New("feedback funcion is nil")
+		}
+		dctx, cancel := drainContext(ctx)
+		defer cancel()
+		stop := onDone(dctx, func() { closeMeasureReader(r) })
+		defer stop()
+		d := deliveryOf(ctx, r)
+		d.track()
+
+		measures := make(chan Measure)
+		go func() {
+			defer close(measures)
+			for {
+				var m Measure
+				err := r.Read(&m)
+				if err != nil {
+					if err == io.EOF || aborted(dctx, err) {
+						return
+					}
+					f("batch output read error- %v", err)
+					continue
+				}
+				measures <- m
+			}
+		}()
+
+		var (
+			b     *batch
+			seq   int
+			timer = time.NewTimer(time.Hour)
+			age   <-chan time.Time
+		)
+		timer.Stop()
+		defer timer.Stop()
+		flush := func() {
+			if b == nil {
+				return
+			}
+			seq++
+			d.report(b.read, b.flush(ctx, f, snk, seq))
+			b = nil
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			age = nil
+		}
+		for {
+			select {
+			case m, ok := <-measures:
+				if !ok {
+					flush()
+					return nil
+				}
+				if b == nil {
+					b = newBatch(ctx, f, dec, pipe, opts.MaxBytes > 0)
+					if opts.MaxAge > 0 {
+						timer.Reset(opts.MaxAge)
+						age = timer.C
+					}
+				}
+				b.write(m)
+				if opts.MaxCount > 0 && b.count >= opts.MaxCount || opts.MaxBytes > 0 && b.size() >= opts.MaxBytes {
+					flush()
+				}
+			case <-age:
+				age = nil
+				flush()
+			}
+		}
+	}, nil
+}
+
+// batch decodes the measures written to it into memory
+type batch struct {
+	mw    MeasurePipeWriter
+	count int
+	// read counts the measures taken, written to the decoder or not
+	read int
+	err  error
+	done chan struct{}
+	mu   sync.Mutex
+	buf  bytes.Buffer
+}
+
+func newBatch(ctx context.Context, f Feedback, dec DecoderContext, pipe MeasurePipe, unbuffered bool) *batch {
+	pctx := ctx
+	if unbuffered {
+		// keeps the decoder close to the measures written, so the size bound is not overrun by a whole buffer
+		pctx = WithPipeBufferSize(ctx, 0)
+	}
+	mr, mw := pipe(pctx)
+	b := &batch{mw: mw, done: make(chan struct{})}
+	rc, err := dec(ctx, f, mr)
+	if err != nil {
+		b.err = err
+		mw.CloseWithError(err)
+		close(b.done)
+		return b
+	}
+	go func() {
+		defer close(b.done)
+		defer rc.Close()
+		if _, err := io.Copy(b, rc); err != nil {
+			b.err = err
+		}
+	}()
+	return b
+}
+
+func (b *batch) write(m Measure) {
+	b.read++
+	if err := b.mw.Write(m); err == nil {
+		b.count++
+	}
+}
+
+// Write receives the decoded bytes
+func (b *batch) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *batch) size() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+// flush waits for the decoder to finish and sinks the decoded batch, returning why it failed if it did
+func (b *batch) flush(ctx context.Context, f Feedback, snk SinkerContext, seq int) error {
+	b.mw.Close()
+	<-b.done
+	if b.err != nil {
+		f("batch %v of %v measures failed to decode: %v", seq, b.count, b.err)
+		return b.err
+	}
+	size := b.buf.Len()
+	if err := snk(ctx, f, ioutil.NopCloser(&b.buf)); err != nil {
+		f("batch %v of %v measures (%v bytes) failed: %v", seq, b.count, size, err)
+		return err
+	}
+	f("batch %v of %v measures (%v bytes) sent", seq, b.count, size)
+	return nil
+}
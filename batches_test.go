@@ -0,0 +1,131 @@
+package mstreamer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewBatchedOutputContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   BatchOptions
+		count  int
+		pause  time.Duration
+		want   []int
+		reject bool
+	}{
+		{name: `when max count is reached then batch is flushed`, opts: BatchOptions{MaxCount: 2}, count: 5, want: []int{2, 2, 1}},
+		{name: `when max age is reached then batch is flushed`, opts: BatchOptions{MaxAge: 20 * time.Millisecond}, count: 4, pause: 60 * time.Millisecond, want: []int{2, 2}},
+		{name: `when sinker fails then failure is reported and next batches are sent`, opts: BatchOptions{MaxCount: 1}, count: 2, want: []int{1, 1}, reject: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu      sync.Mutex
+				batches []int
+				msgs    []string
+			)
+			dec, err := NewGenericDecoderContext(func(m Measure, w io.Writer) error {
+				_, err := io.WriteString(w, m.Name+"\n")
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			snk, err := NewSinkerContext(func(ctx context.Context, f Feedback, r io.ReadCloser) error {
+				b, _ := ioutil.ReadAll(r)
+				mu.Lock()
+				defer mu.Unlock()
+				batches = append(batches, strings.Count(string(b), "\n"))
+				if tt.reject {
+					return errors.New("rejected")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := NewBatchedOutputContext(dec, snk, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, w := NewChanMeasurePipe(0)
+			go func() {
+				defer w.Close()
+				for i := 0; i < tt.count; i++ {
+					if tt.pause > 0 && i == tt.count/2 {
+						time.Sleep(tt.pause)
+					}
+					w.Write(Measure{Name: "m", Flds: []Field{{"v", TInt, int64(i)}}})
+				}
+			}()
+			f := func(format string, a ...interface{}) {
+				mu.Lock()
+				defer mu.Unlock()
+				msgs = append(msgs, format)
+			}
+			if err := out(context.Background(), f, r); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(batches, tt.want) {
+				t.Errorf("got batches %v want %v", batches, tt.want)
+			}
+			failed := 0
+			for _, msg := range msgs {
+				if strings.Contains(msg, "failed") {
+					failed++
+				}
+			}
+			if tt.reject && failed != len(tt.want) || !tt.reject && failed != 0 || len(msgs) != len(tt.want) {
+				t.Errorf("got feedback %v", msgs)
+			}
+		})
+	}
+
+	t.Run(`when max bytes is reached then batch is flushed`, func(t *testing.T) {
+		var batches []int
+		dec, _ := NewGenericDecoderContext(func(m Measure, w io.Writer) error {
+			_, err := io.WriteString(w, m.Name+"\n")
+			return err
+		})
+		snk, _ := NewSinkerContext(func(ctx context.Context, f Feedback, r io.ReadCloser) error {
+			b, _ := ioutil.ReadAll(r)
+			batches = append(batches, len(b))
+			return nil
+		})
+		out, err := NewBatchedOutputContext(dec, snk, BatchOptions{MaxBytes: 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, w := NewChanMeasurePipe(0)
+		go func() {
+			defer w.Close()
+			for i := 0; i < 50; i++ {
+				w.Write(Measure{Name: "m"})
+			}
+		}()
+		out(context.Background(), t.Logf, r)
+		total := 0
+		for _, n := range batches {
+			total += n
+		}
+		if total != 100 || len(batches) < 5 {
+			t.Errorf("got batches of %v bytes want 100 bytes in batches close to 8 bytes", batches)
+		}
+	})
+
+	t.Run(`when no bound is set then should fail`, func(t *testing.T) {
+		dec, _ := NewGenericDecoderContext(measureJSONDecoderToWriter)
+		snk, _ := NewSinkerContext(func(ctx context.Context, f Feedback, r io.ReadCloser) error { return nil })
+		if _, err := NewBatchedOutputContext(dec, snk, BatchOptions{}); err == nil {
+			t.Errorf("want error")
+		}
+	})
+}
@@ -0,0 +1,897 @@
+package mstreamer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DropPolicy tells a disk buffer which measures to lose once it is full
+type DropPolicy int
+
+const (
+	//DropOldest deletes the oldest segment to make room for new measures
+	DropOldest DropPolicy = iota
+	//DropNewest rejects new measures until the output catches up
+	DropNewest
+)
+
+// ParseDropPolicy parses oldest or newest
+func ParseDropPolicy(s string) (DropPolicy, error) {
+	switch s {
+	case "", "oldest":
+		return DropOldest, nil
+	case "newest":
+		return DropNewest, nil
+	default:
+		return 0, fmt.Errorf("unknown drop policy %q", s)
+	}
+}
+
+// DefaultSegmentSize is the size of the segment files of a disk buffer that does not set one
+const DefaultSegmentSize = 16 << 20
+
+// diskCommitInterval is how often the replay offset is persisted while measures flow
+const diskCommitInterval = time.Second
+
+// DiskBufferOptions configures a disk buffered output
+type DiskBufferOptions struct {
+	// Dir holds the segment files and the committed offset. It must not be shared by two outputs
+	Dir string
+	// SegmentSize is the size a segment file grows to before a new one is started
+	SegmentSize int64
+	// MaxSize bounds the disk usage of the buffer, zero means unbounded
+	MaxSize int64
+	// Drop is the policy applied when MaxSize is reached
+	Drop DropPolicy
+	// RetryBackoff is the wait before replaying the measures a sinker failed to take, doubled on every
+	// failure in a row up to DefaultRetryMaxBackoff. DefaultRetryBaseBackoff when zero
+	RetryBackoff time.Duration
+}
+
+// NewDiskBufferedOutput returns an Output that appends every measure to a write-ahead log on disk
+// and replays the log to out. See NewDiskBufferedOutputContext
+func NewDiskBufferedOutput(out Output, opts DiskBufferOptions) (Output, error) {
+	if out == nil {
+		return nil, errors.New("output function is nil")
+	}
+	dout, err := NewDiskBufferedOutputContext(ContextOutput(out), opts)
+	if err != nil {
+		return nil, err
+	}
+	return func(f Feedback, r MeasureReader) error {
+		return dout(context.Background(), f, r)
+	}, nil
+}
+
+// NewDiskBufferedOutputContext returns an OutputContext that appends every measure to segment files
+// in opts.Dir as soon as it is read, so upstream never waits for out, and replays them to out in order.
+//
+// Composed and batched outputs report the streams and batches their sinker accepted: the replay offset
+// is committed past the measures of every accepted one, and a failed one makes the replay start again
+// from the committed offset after the retry backoff. Other outputs are done with a measure once they ask
+// for the following one. The offset is written to disk at most every second, so after a crash or
+// a restart the measures not yet committed are replayed again.
+// When the run is cancelled, the measures out could not take before the drain timeout stay on disk
+// for the next run
+func NewDiskBufferedOutputContext(out OutputContext, opts DiskBufferOptions) (OutputContext, error) {
+	return newDiskBufferedOutputContext(out, opts, ChanMeasurePipe)
+}
+
+func newDiskBufferedOutputContext(out OutputContext, opts DiskBufferOptions, pipe MeasurePipe) (OutputContext, error) {
+	if out == nil {
+		return nil, errors.New("output function is nil")
+	}
+	if pipe == nil {
+		return nil, errors.New("pipe is nil")
+	}
+	if opts.Dir == "" {
+		return nil, errors.New("disk buffer directory is empty")
+	}
+	if opts.SegmentSize < 0 || opts.MaxSize < 0 {
+		return nil, errors.New("disk buffer sizes must not be negative")
+	}
+	if opts.RetryBackoff < 0 {
+		return nil, errors.New("disk buffer retry backoff must not be negative")
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = DefaultRetryBaseBackoff
+	}
+	if opts.SegmentSize == 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.MaxSize > 0 && opts.MaxSize < opts.SegmentSize {
+		opts.SegmentSize = opts.MaxSize
+	}
+	// a single run at a time owns the directory
+	var run sync.Mutex
+	return func(ctx context.Context, f Feedback, r MeasureReader) error {
+		if ctx == nil {
+			return errors.New("context is nil")
+		}
+		if f == nil {
+			return errors.New("feedback funcion is nil")
+		}
+		run.Lock()
+		defer run.Unlock()
+		q, err := openDiskQueue(opts)
+		if err != nil {
+			return err
+		}
+		defer q.close()
+
+		dctx, cancel := drainContext(ctx)
+		defer cancel()
+		// an unbuffered pipe tells when out is done with a measure: it asks for the next one
+		pr, pw := pipe(WithPipeBufferSize(ctx, 0))
+		d := &delivery{r: pr, q: q, f: f}
+		outErr := make(chan error, 1)
+		go func() {
+			err := out(withDelivery(ctx, d), f, pr)
+			closeMeasureReader(pr)
+			outErr <- err
+		}()
+		replayed := make(chan diskPosition, 1)
+		go func() {
+			replayed <- q.replay(dctx, f, pw, d)
+		}()
+
+		stop := onDone(dctx, func() {
+			closeMeasureReader(r)
+			pw.CloseWithError(context.Cause(dctx))
+		})
+		defer stop()
+		for {
+			var m Measure
+			err := r.Read(&m)
+			if err != nil {
+				if err == io.EOF || aborted(dctx, err) {
+					break
+				}
+				f("disk buffer read error- %v", err)
+				continue
+			}
+			if err := q.append(m); err != nil {
+				f("disk buffer append error- %v", err)
+			}
+		}
+		q.closeWriter()
+		last := <-replayed
+		err = <-outErr
+		if err == nil && last.done && !d.isTracked() {
+			q.commit(last)
+		}
+		if cerr := q.persist(); cerr != nil {
+			f("disk buffer commit error- %v", cerr)
+		}
+		return err
+	}, nil
+}
+
+// diskPosition locates a record in the segment files
+type diskPosition struct {
+	segment int64
+	offset  int64
+	// done tells the replay reached the end of the log
+	done bool
+}
+
+// diskQueue is a log of measures split into numbered segment files
+type diskQueue struct {
+	opts       DiskBufferOptions
+	mu         sync.Mutex
+	segments   []int64
+	sizes      map[int64]int64
+	total      int64
+	active     *os.File
+	closed     bool
+	notify     chan struct{}
+	committed  diskPosition
+	lastCommit time.Time
+	dropped    int
+}
+
+const diskRecordHeader = 8
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func segmentPath(dir string, id int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%016d.wal", id))
+}
+
+func openDiskQueue(opts DiskBufferOptions) (*diskQueue, error) {
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	q := &diskQueue{opts: opts, sizes: make(map[int64]int64), notify: make(chan struct{}, 1)}
+	entries, err := ioutil.ReadDir(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		id, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), ".wal"), 10, 64)
+		if err != nil || !strings.HasSuffix(e.Name(), ".wal") {
+			continue
+		}
+		q.segments = append(q.segments, id)
+		q.sizes[id] = e.Size()
+		q.total += e.Size()
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i] < q.segments[j] })
+	q.committed = q.readCommit()
+	if len(q.segments) == 0 {
+		id := q.committed.segment
+		if id == 0 {
+			id = 1
+		}
+		q.committed = diskPosition{segment: id}
+		return q, q.create(id)
+	}
+	if q.committed.segment < q.segments[0] {
+		q.committed = diskPosition{segment: q.segments[0]}
+	}
+	last := q.segments[len(q.segments)-1]
+	valid, err := validSegmentSize(segmentPath(opts.Dir, last))
+	if err != nil {
+		return nil, err
+	}
+	if valid < q.sizes[last] {
+		// a crash tore the last record
+		if err := os.Truncate(segmentPath(opts.Dir, last), valid); err != nil {
+			return nil, err
+		}
+		q.total -= q.sizes[last] - valid
+		q.sizes[last] = valid
+	}
+	q.active, err = os.OpenFile(segmentPath(opts.Dir, last), os.O_WRONLY|os.O_APPEND, 0o644)
+	return q, err
+}
+
+// validSegmentSize returns the size of the records of a segment that are complete and intact
+func validSegmentSize(path string) (int64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var off int64
+	for {
+		payload, ok := decodeRecord(b[off:])
+		if !ok {
+			return off, nil
+		}
+		off += diskRecordHeader + int64(len(payload))
+	}
+}
+
+func decodeRecord(b []byte) ([]byte, bool) {
+	if len(b) < diskRecordHeader {
+		return nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-diskRecordHeader) < uint64(n) {
+		return nil, false
+	}
+	payload := b[diskRecordHeader : diskRecordHeader+int(n)]
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(b[4:]) {
+		return nil, false
+	}
+	return payload, true
+}
+
+// create starts a new active segment. It is called with the lock held
+func (q *diskQueue) create(id int64) error {
+	if q.active != nil {
+		q.active.Sync()
+		q.active.Close()
+	}
+	file, err := os.OpenFile(segmentPath(q.opts.Dir, id), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		q.active = nil
+		return err
+	}
+	q.active = file
+	q.segments = append(q.segments, id)
+	q.sizes[id] = 0
+	return nil
+}
+
+func (q *diskQueue) activeID() int64 {
+	return q.segments[len(q.segments)-1]
+}
+
+// append writes m at the end of the log, making room as told by the drop policy
+func (q *diskQueue) append(m Measure) error {
+	payload, err := appendMeasureRecord(nil, m)
+	if err != nil {
+		return err
+	}
+	rec := make([]byte, diskRecordHeader+len(payload))
+	binary.BigEndian.PutUint32(rec, uint32(len(payload)))
+	binary.BigEndian.PutUint32(rec[4:], crc32.Checksum(payload, crcTable))
+	copy(rec[diskRecordHeader:], payload)
+	size := int64(len(rec))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.active == nil {
+		return errors.New("disk buffer has no active segment")
+	}
+	if q.opts.MaxSize > 0 && size > q.opts.MaxSize {
+		return fmt.Errorf("measure of %v bytes does not fit in the disk buffer", size)
+	}
+	for q.opts.MaxSize > 0 && q.total+size > q.opts.MaxSize {
+		if q.opts.Drop == DropNewest {
+			q.dropped++
+			return fmt.Errorf("disk buffer is full, %v measures dropped", q.dropped)
+		}
+		oldest := q.segments[0]
+		if oldest == q.activeID() {
+			if err := q.create(oldest + 1); err != nil {
+				return err
+			}
+		}
+		if err := q.remove(oldest); err != nil {
+			return err
+		}
+	}
+	if q.sizes[q.activeID()] > 0 && q.sizes[q.activeID()]+size > q.opts.SegmentSize {
+		if err := q.create(q.activeID() + 1); err != nil {
+			return err
+		}
+	}
+	if _, err := q.active.Write(rec); err != nil {
+		return err
+	}
+	q.sizes[q.activeID()] += size
+	q.total += size
+	q.wake()
+	return nil
+}
+
+// wake tells the replay waiting for measures to look again
+func (q *diskQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// remove deletes the first segment. It is called with the lock held
+func (q *diskQueue) remove(id int64) error {
+	if err := os.Remove(segmentPath(q.opts.Dir, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	q.total -= q.sizes[id]
+	delete(q.sizes, id)
+	q.segments = q.segments[1:]
+	return nil
+}
+
+// closeWriter tells the replay no measure will be appended anymore
+func (q *diskQueue) closeWriter() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.wake()
+}
+
+func (q *diskQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.active != nil {
+		q.active.Sync()
+		q.active.Close()
+		q.active = nil
+	}
+}
+
+func (q *diskQueue) readCommit() diskPosition {
+	b, err := ioutil.ReadFile(filepath.Join(q.opts.Dir, "commit"))
+	if err != nil {
+		return diskPosition{}
+	}
+	var p diskPosition
+	if _, err := fmt.Sscan(string(b), &p.segment, &p.offset); err != nil {
+		return diskPosition{}
+	}
+	return p
+}
+
+// commit records that every measure before p was handed to the output and deletes the segments
+// the output is done with. The offset is written to disk at most every diskCommitInterval
+func (q *diskQueue) commit(p diskPosition) error {
+	q.mu.Lock()
+	q.committed = p
+	for len(q.segments) > 1 && q.segments[0] < p.segment {
+		if err := q.remove(q.segments[0]); err != nil {
+			q.mu.Unlock()
+			return err
+		}
+	}
+	due := time.Since(q.lastCommit) >= diskCommitInterval
+	q.mu.Unlock()
+	if !due {
+		return nil
+	}
+	return q.persist()
+}
+
+// persist writes the committed offset to disk
+func (q *diskQueue) persist() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lastCommit = time.Now()
+	path := filepath.Join(q.opts.Dir, "commit")
+	b := []byte(fmt.Sprintf("%d %d\n", q.committed.segment, q.committed.offset))
+	if err := ioutil.WriteFile(path+".tmp", b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// replay writes the logged measures to w from the committed offset until the writer is closed and
+// every measure was written, or ctx is done. It returns the position after the last measure written
+func (q *diskQueue) replay(ctx context.Context, f Feedback, w MeasurePipeWriter, d *delivery) diskPosition {
+	q.mu.Lock()
+	pos := q.committed
+	q.mu.Unlock()
+	var (
+		file    *os.File
+		pending bool
+		header  [diskRecordHeader]byte
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+	for {
+		if wait, ok := d.rewind(q.opts.RetryBackoff); ok {
+			f("disk buffer replays the measures its output failed to deliver in %v", wait)
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				w.CloseWithError(context.Cause(ctx))
+				return pos
+			}
+			q.mu.Lock()
+			pos = q.committed
+			q.mu.Unlock()
+			if file != nil {
+				file.Close()
+				file = nil
+			}
+		}
+		q.mu.Lock()
+		if len(q.segments) > 0 && pos.segment < q.segments[0] {
+			f("disk buffer dropped measures of segment %v before they were replayed", pos.segment)
+			pos = diskPosition{segment: q.segments[0]}
+			if file != nil {
+				file.Close()
+				file = nil
+			}
+		}
+		active := len(q.segments) == 0 || pos.segment >= q.activeID()
+		closed := q.closed
+		q.mu.Unlock()
+
+		if file == nil {
+			var err error
+			if file, err = os.Open(segmentPath(q.opts.Dir, pos.segment)); err != nil {
+				if os.IsNotExist(err) && !active {
+					pos = diskPosition{segment: pos.segment + 1}
+					continue
+				}
+				w.CloseWithError(err)
+				return pos
+			}
+		}
+		n, err := file.ReadAt(header[:], pos.offset)
+		var payload []byte
+		if n == diskRecordHeader {
+			payload = make([]byte, binary.BigEndian.Uint32(header[:]))
+			n, err = file.ReadAt(payload, pos.offset+diskRecordHeader)
+			if n == len(payload) {
+				err = nil
+			}
+		}
+		if err == nil && crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+			err = errors.New("corrupted record")
+		}
+		if err != nil {
+			switch {
+			case !active:
+				if err != io.EOF {
+					f("disk buffer skips the end of segment %v: %v", pos.segment, err)
+				}
+				file.Close()
+				file = nil
+				pos = diskPosition{segment: pos.segment + 1}
+			case closed:
+				w.Close()
+				pos.done = true
+				return pos
+			default:
+				select {
+				case <-q.notify:
+				case <-ctx.Done():
+					w.CloseWithError(context.Cause(ctx))
+					return pos
+				}
+			}
+			continue
+		}
+		next := diskPosition{segment: pos.segment, offset: pos.offset + diskRecordHeader + int64(len(payload))}
+		m, err := parseMeasureRecord(payload)
+		if err != nil {
+			f("disk buffer skips an undecodable measure: %v", err)
+			pos = next
+			continue
+		}
+		// m is followed before it is written, the output may report it as soon as it reads it
+		d.follow(next)
+		if err := w.Write(m); err != nil {
+			return pos
+		}
+		// an output not reporting deliveries asked for m, so it is done with the measure before it
+		if !d.isTracked() {
+			d.forget()
+			if pending {
+				if err := q.commit(pos); err != nil {
+					f("disk buffer commit error- %v", err)
+				}
+			}
+			pending = true
+		}
+		pos = next
+	}
+}
+
+type deliveryKey struct{}
+
+// delivery follows the measures a disk buffer replays to an output. Outputs sinking them report which
+// ones their sinker accepted, see deliveryOf
+type delivery struct {
+	r        MeasureReader
+	q        *diskQueue
+	f        Feedback
+	mu       sync.Mutex
+	tracked  bool
+	sent     []diskPosition
+	stale    int
+	rewound  bool
+	failures int
+}
+
+func withDelivery(ctx context.Context, d *delivery) context.Context {
+	return context.WithValue(ctx, deliveryKey{}, d)
+}
+
+// deliveryOf returns the delivery of the measures read from r when a disk buffer replays them, nil
+// otherwise. Its methods do nothing on nil
+func deliveryOf(ctx context.Context, r MeasureReader) *delivery {
+	d, _ := ctx.Value(deliveryKey{}).(*delivery)
+	if d == nil || d.r != r {
+		return nil
+	}
+	return d
+}
+
+// track tells the disk buffer the output reports its deliveries. It is called before the first read
+func (d *delivery) track() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tracked = true
+}
+
+func (d *delivery) isTracked() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tracked
+}
+
+// forget drops the positions followed for an output not reporting its deliveries
+func (d *delivery) forget() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sent = d.sent[:0]
+}
+
+// follow records the position after a measure about to be replayed
+func (d *delivery) follow(next diskPosition) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sent = append(d.sent, next)
+}
+
+// report tells whether the sinker accepted the next n measures read. Accepted measures are committed,
+// failed ones are replayed again from the committed offset, along with the ones read after them
+func (d *delivery) report(n int, err error) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	// measures replayed before a rewind are replayed again, whatever their outcome
+	stale := n
+	if stale > d.stale {
+		stale = d.stale
+	}
+	d.stale -= stale
+	n -= stale
+	if n > len(d.sent) {
+		n = len(d.sent)
+	}
+	if n == 0 {
+		d.mu.Unlock()
+		return
+	}
+	done := d.sent[n-1]
+	d.sent = d.sent[n:]
+	if err != nil {
+		d.stale += len(d.sent)
+		d.sent = nil
+		d.rewound = true
+		d.failures++
+		d.mu.Unlock()
+		d.q.wake()
+		return
+	}
+	d.failures = 0
+	d.mu.Unlock()
+	if err := d.q.commit(done); err != nil {
+		d.f("disk buffer commit error- %v", err)
+	}
+}
+
+// rewind tells whether the replay must start again from the committed offset, and after which wait
+func (d *delivery) rewind(backoff time.Duration) (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.rewound {
+		return 0, false
+	}
+	d.rewound = false
+	for i := 1; i < d.failures && backoff < DefaultRetryMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > DefaultRetryMaxBackoff {
+		backoff = DefaultRetryMaxBackoff
+	}
+	return backoff, true
+}
+
+// countingReader counts the measures read through it
+type countingReader struct {
+	r MeasureReader
+	n int64
+}
+
+func (c *countingReader) Read(m *Measure) error {
+	err := c.r.Read(m)
+	if err == nil {
+		atomic.AddInt64(&c.n, 1)
+	}
+	return err
+}
+
+func (c *countingReader) Close() error {
+	closeMeasureReader(c.r)
+	return nil
+}
+
+func (c *countingReader) count() int {
+	return int(atomic.LoadInt64(&c.n))
+}
+
+// measure record value kinds
+const (
+	recordNil byte = iota
+	recordBool
+	recordInt
+	recordUint
+	recordFloat
+	recordString
+	recordBytes
+)
+
+// appendMeasureRecord appends the binary encoding of m to b. Unlike json it keeps NaN and infinities.
+// Integers and floats of every size are widened to 64 bits
+func appendMeasureRecord(b []byte, m Measure) ([]byte, error) {
+	str := func(b []byte, s string) []byte {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		return append(b, s...)
+	}
+	b = str(b, m.Name)
+	b = binary.AppendVarint(b, m.Time)
+	b = binary.AppendUvarint(b, uint64(len(m.Tags)))
+	for _, t := range m.Tags {
+		b = str(str(b, t.Name), t.Data)
+	}
+	b = binary.AppendUvarint(b, uint64(len(m.Flds)))
+	for _, fld := range m.Flds {
+		b = append(str(b, fld.Name), byte(fld.Type))
+		switch v := fld.Data.(type) {
+		case nil:
+			b = append(b, recordNil)
+		case bool:
+			b = append(b, recordBool, 0)
+			if v {
+				b[len(b)-1] = 1
+			}
+		case int, int8, int16, int32, int64:
+			b = binary.AppendVarint(append(b, recordInt), toInt64(v))
+		case uint, uint8, uint16, uint32, uint64:
+			b = binary.AppendUvarint(append(b, recordUint), toUint64(v))
+		case float32:
+			b = binary.BigEndian.AppendUint64(append(b, recordFloat), math.Float64bits(float64(v)))
+		case float64:
+			b = binary.BigEndian.AppendUint64(append(b, recordFloat), math.Float64bits(v))
+		case string:
+			b = str(append(b, recordString), v)
+		case []byte:
+			b = str(append(b, recordBytes), string(v))
+		default:
+			return nil, fmt.Errorf("field %v holds an unsupported %T", fld.Name, fld.Data)
+		}
+	}
+	return b, nil
+}
+
+func toInt64(v interface{}) int64 {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	}
+	return v.(int64)
+}
+
+func toUint64(v interface{}) uint64 {
+	switch v := v.(type) {
+	case uint:
+		return uint64(v)
+	case uint8:
+		return uint64(v)
+	case uint16:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	}
+	return v.(uint64)
+}
+
+var errShortRecord = errors.New("truncated measure record")
+
+// recordReader reads the values of a measure record, keeping the first error
+type recordReader struct {
+	b   []byte
+	err error
+}
+
+func (r *recordReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *recordReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *recordReader) byte() byte {
+	if len(r.b) < 1 {
+		r.fail()
+		return 0
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c
+}
+
+func (r *recordReader) bytes(n uint64) []byte {
+	if uint64(len(r.b)) < n {
+		r.fail()
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *recordReader) string() string {
+	return string(r.bytes(r.uvarint()))
+}
+
+// count reads a number of items each taking at least min bytes
+func (r *recordReader) count(min int) int {
+	n := r.uvarint()
+	if n > uint64(len(r.b)/min) {
+		r.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (r *recordReader) fail() {
+	if r.err == nil {
+		r.err = errShortRecord
+	}
+	r.b = nil
+}
+
+// parseMeasureRecord decodes a measure encoded by appendMeasureRecord
+func parseMeasureRecord(b []byte) (Measure, error) {
+	r := &recordReader{b: b}
+	m := Measure{Name: r.string(), Time: r.varint()}
+	if n := r.count(2); n > 0 {
+		m.Tags = make([]Tag, n)
+		for i := range m.Tags {
+			m.Tags[i] = Tag{Name: r.string(), Data: r.string()}
+		}
+	}
+	if n := r.count(3); n > 0 {
+		m.Flds = make([]Field, n)
+		for i := range m.Flds {
+			fld := Field{Name: r.string(), Type: FieldType(r.byte())}
+			switch kind := r.byte(); kind {
+			case recordNil:
+			case recordBool:
+				fld.Data = r.byte() == 1
+			case recordInt:
+				fld.Data = r.varint()
+			case recordUint:
+				fld.Data = r.uvarint()
+			case recordFloat:
+				if v := r.bytes(8); v != nil {
+					fld.Data = math.Float64frombits(binary.BigEndian.Uint64(v))
+				}
+			case recordString:
+				fld.Data = r.string()
+			case recordBytes:
+				fld.Data = append([]byte(nil), r.bytes(r.uvarint())...)
+			default:
+				if r.err == nil {
+					r.err = fmt.Errorf("unknown measure record value kind %v", kind)
+				}
+			}
+			m.Flds[i] = fld
+		}
+	}
+	if r.err == nil && len(r.b) > 0 {
+		r.err = errors.New("measure record has trailing bytes")
+	}
+	return m, r.err
+}
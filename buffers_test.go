@@ -0,0 +1,272 @@
+package mstreamer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewDiskBufferedOutputContext(t *testing.T) {
+	measure := func(i int) Measure {
+		return Measure{Name: "m", Tags: []Tag{{"host", "a"}}, Flds: []Field{{"v", TInt, int64(i)}, {"f", TFloat, 0.5}}, Time: int64(i)}
+	}
+	values := func(ms []Measure) []int64 {
+		var vs []int64
+		for _, m := range ms {
+			vs = append(vs, m.Flds[0].Data.(int64))
+		}
+		return vs
+	}
+	// run writes n measures through a disk buffered output whose inner output takes up to take measures,
+	// after release is closed if any. release is closed once the buffer appended every measure
+	run := func(t *testing.T, opts DiskBufferOptions, n, take int, release chan struct{}) []Measure {
+		var got []Measure
+		out, err := NewDiskBufferedOutputContext(func(ctx context.Context, f Feedback, r MeasureReader) error {
+			if release != nil {
+				<-release
+			}
+			for take < 0 || len(got) < take {
+				var m Measure
+				if err := r.Read(&m); err != nil {
+					if err == io.EOF {
+						return nil
+					}
+					return err
+				}
+				got = append(got, m)
+			}
+			return nil
+		}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, w := NewChanMeasurePipe(0)
+		go func() {
+			defer w.Close()
+			for i := 0; i < n; i++ {
+				w.Write(measure(i))
+			}
+		}()
+		// the buffer reads the end of the stream once it appended the measure before it
+		eof := func() {}
+		if release != nil {
+			eof = func() { close(release) }
+		}
+		if err := out(context.Background(), t.Logf, &eofReader{r: r, eof: eof}); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	t.Run(`when output takes every measure then they are replayed in order once`, func(t *testing.T) {
+		opts := DiskBufferOptions{Dir: t.TempDir(), SegmentSize: 200}
+		got := run(t, opts, 20, -1, nil)
+		if !reflect.DeepEqual(got[3], measure(3)) || len(got) != 20 || !reflect.DeepEqual(values(got), values(func() []Measure {
+			var ms []Measure
+			for i := 0; i < 20; i++ {
+				ms = append(ms, measure(i))
+			}
+			return ms
+		}())) {
+			t.Errorf("got %v", values(got))
+		}
+		if got := run(t, opts, 0, -1, nil); len(got) != 0 {
+			t.Errorf("got %v replayed again", values(got))
+		}
+	})
+
+	t.Run(`when output stops early then the rest is replayed by the next run`, func(t *testing.T) {
+		opts := DiskBufferOptions{Dir: t.TempDir(), SegmentSize: 200}
+		first := run(t, opts, 10, 4, nil)
+		second := run(t, opts, 0, -1, nil)
+		// the measure out was holding when it stopped is replayed again
+		if want := []int64{0, 1, 2, 3}; !reflect.DeepEqual(values(first), want) {
+			t.Errorf("got first run %v want %v", values(first), want)
+		}
+		if want := []int64{3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(values(second), want) {
+			t.Errorf("got second run %v want %v", values(second), want)
+		}
+	})
+
+	t.Run(`when buffer is full and drops oldest then newest measures are kept`, func(t *testing.T) {
+		opts := DiskBufferOptions{Dir: t.TempDir(), SegmentSize: 200, MaxSize: 600}
+		got := values(run(t, opts, 50, -1, make(chan struct{})))
+		if len(got) >= 50 || got[len(got)-1] != 49 {
+			t.Errorf("got %v want a tail ending with 49", got)
+		}
+	})
+
+	t.Run(`when buffer is full and drops newest then oldest measures are kept`, func(t *testing.T) {
+		opts := DiskBufferOptions{Dir: t.TempDir(), SegmentSize: 200, MaxSize: 600, Drop: DropNewest}
+		got := values(run(t, opts, 50, -1, make(chan struct{})))
+		rec, _ := appendMeasureRecord(nil, measure(0))
+		var want []int64
+		for i := 0; i < 600/(len(rec)+diskRecordHeader); i++ {
+			want = append(want, int64(i))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run(`when fields are not finite then they are replayed as they were`, func(t *testing.T) {
+		m := Measure{Name: "m", Flds: []Field{
+			{"nan", TFloat, math.NaN()}, {"inf", TFloat, math.Inf(1)}, {"ninf", TFloat, math.Inf(-1)},
+			{"u", TUint, uint64(math.MaxUint64)}, {"b", TBool, true}, {"s", TString, "x"}, {"n", TNil, nil},
+		}, Time: -1}
+		var got []Measure
+		out, _ := NewDiskBufferedOutputContext(func(ctx context.Context, f Feedback, r MeasureReader) error {
+			var m Measure
+			for r.Read(&m) == nil {
+				got = append(got, m)
+			}
+			return nil
+		}, DiskBufferOptions{Dir: t.TempDir()})
+		r, w := NewChanMeasurePipe(1)
+		w.Write(m)
+		w.Close()
+		if err := out(context.Background(), t.Logf, r); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 {
+			t.Fatalf("got %v measures want 1", len(got))
+		}
+		flds := got[0].Flds
+		if v, _ := flds[0].Data.(float64); !math.IsNaN(v) || flds[1].Data != math.Inf(1) || flds[2].Data != math.Inf(-1) {
+			t.Errorf("got %v want NaN, +Inf and -Inf", flds[:3])
+		}
+		if !reflect.DeepEqual(flds[3:], m.Flds[3:]) || got[0].Time != -1 {
+			t.Errorf("got %v want %v", got[0], m)
+		}
+	})
+
+	t.Run(`when output fails then the error is returned`, func(t *testing.T) {
+		errOut := errors.New("out")
+		out, _ := NewDiskBufferedOutputContext(func(ctx context.Context, f Feedback, r MeasureReader) error {
+			return errOut
+		}, DiskBufferOptions{Dir: t.TempDir()})
+		r, w := NewChanMeasurePipe(1)
+		w.Write(measure(1))
+		w.Close()
+		if err := out(context.Background(), t.Logf, r); err != errOut {
+			t.Errorf("got %v want %v", err, errOut)
+		}
+	})
+}
+
+func TestDiskBufferedOutputSinkerFailures(t *testing.T) {
+	dec, _ := NewLineProtocolDecoder("")
+	tests := []struct {
+		name   string
+		output func(SinkerContext) (OutputContext, error)
+	}{
+		{
+			name: `when batches fail then they are replayed until delivered`,
+			output: func(snk SinkerContext) (OutputContext, error) {
+				return NewBatchedOutputContext(ContextDecoder(dec), snk, BatchOptions{MaxCount: 3})
+			},
+		},
+		{
+			name: `when the stream fails then it is replayed by the next run`,
+			output: func(snk SinkerContext) (OutputContext, error) {
+				return NewComposedOutputContext(ContextDecoder(dec), snk)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu        sync.Mutex
+				failures  = 3
+				delivered = make(map[int64]int)
+			)
+			out, err := tt.output(func(ctx context.Context, f Feedback, rc io.ReadCloser) error {
+				sc := bufio.NewScanner(rc)
+				var lines []Measure
+				for sc.Scan() {
+					m, err := ParseLineProtocol(sc.Bytes(), time.Nanosecond)
+					if err != nil {
+						return err
+					}
+					lines = append(lines, m)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if failures > 0 {
+					failures--
+					return errors.New("sinker is down")
+				}
+				for _, m := range lines {
+					delivered[m.Time]++
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dout, err := NewDiskBufferedOutputContext(out, DiskBufferOptions{Dir: t.TempDir(), SegmentSize: 200, RetryBackoff: time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			run := func(n int) {
+				r, w := NewChanMeasurePipe(n)
+				for i := 1; i <= n; i++ {
+					w.Write(Measure{Name: "m", Flds: []Field{{"v", TInt, int64(i)}}, Time: int64(i)})
+				}
+				w.Close()
+				dout(context.Background(), t.Logf, r)
+			}
+			total := func() int {
+				mu.Lock()
+				defer mu.Unlock()
+				n := 0
+				for _, c := range delivered {
+					n += c
+				}
+				return n
+			}
+			// the composed output fails a whole run per failure, the next runs replay what it read
+			run(10)
+			for i := 0; i < 3; i++ {
+				run(0)
+			}
+			mu.Lock()
+			for i := int64(1); i <= 10; i++ {
+				if delivered[i] == 0 {
+					t.Errorf("measure %v was lost, got %v", i, delivered)
+				}
+			}
+			if failures != 0 {
+				t.Errorf("got %v failures left want 0", failures)
+			}
+			mu.Unlock()
+			// every delivered measure was committed
+			before := total()
+			run(0)
+			if after := total(); after != before {
+				t.Errorf("got %v measures delivered again", after-before)
+			}
+		})
+	}
+}
+
+// eofReader calls eof once r reaches the end of its stream
+type eofReader struct {
+	r    MeasureReader
+	eof  func()
+	once sync.Once
+}
+
+func (e *eofReader) Read(m *Measure) error {
+	err := e.r.Read(m)
+	if err == io.EOF {
+		e.once.Do(e.eof)
+	}
+	return err
+}
@@ -0,0 +1,638 @@
+package mstreamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// The components shipped with mstreamer are registered in the DefaultRegistry under these names
+func init() {
+	for _, err := range []error{
+		DefaultRegistry.RegisterSource("http", "fetches the body of an HTTP GET request once per run",
+			&httpOptions{}, func(o interface{}) (SourceContext, error) {
+				opts := o.(*httpOptions)
+				return NewRetryHTTPSourceContext(opts.URL, opts.User, opts.Password, opts.policy())
+			}),
+		DefaultRegistry.RegisterSource("tail", "follows the lines appended to a file or a glob of files, across rotations",
+			&tailOptions{}, func(o interface{}) (SourceContext, error) {
+				return NewTailSourceContext(o.(*tailOptions).options())
+			}),
+		DefaultRegistry.RegisterSource("exec", "runs a command once per run and streams its stdout",
+			&execOptions{}, func(o interface{}) (SourceContext, error) {
+				return NewExecSourceContext(o.(*execOptions).options())
+			}),
+		DefaultRegistry.RegisterSource("listener", "accepts newline framed TCP or Unix streams, or UDP or Unix datagrams",
+			&listenerOptions{}, func(o interface{}) (SourceContext, error) {
+				return NewListenerSourceContext(o.(*listenerOptions).options())
+			}),
+
+		DefaultRegistry.RegisterInput("http_receiver", "accepts measures POSTed or PUT by clients, routed to encoders by path and content type",
+			&httpReceiverOptions{}, func(o interface{}) (InputContext, error) {
+				opts, err := o.(*httpReceiverOptions).options()
+				if err != nil {
+					return nil, err
+				}
+				return NewHTTPReceiverInputContext(opts)
+			}),
+
+		DefaultRegistry.RegisterEncoder("json", "reads a stream of json encoded measures",
+			nil, func(interface{}) (EncoderContext, error) {
+				return NewEncoderContext(ignoreContextEncoderAdapter(measureJSONEncoderAdapter))
+			}),
+		DefaultRegistry.RegisterEncoder("gob", "reads a stream of gob encoded measures",
+			nil, func(interface{}) (EncoderContext, error) {
+				return NewEncoderContext(ignoreContextEncoderAdapter(measureGobEncoderAdapter))
+			}),
+
+		DefaultRegistry.RegisterEncoder("influx", "parses InfluxDB line protocol",
+			&precisionOptions{}, func(o interface{}) (EncoderContext, error) {
+				adapter, err := lineProtocolEncoderAdapter(o.(*precisionOptions).Precision)
+				if err != nil {
+					return nil, err
+				}
+				return NewEncoderContext(ignoreContextEncoderAdapter(adapter))
+			}),
+
+		DefaultRegistry.RegisterEncoder("prometheus", "parses the Prometheus text exposition format",
+			nil, func(interface{}) (EncoderContext, error) {
+				return NewEncoderContext(ignoreContextEncoderAdapter(promEncoderAdapter(false)))
+			}),
+
+		DefaultRegistry.RegisterEncoder("openmetrics", "parses the OpenMetrics text format",
+			nil, func(interface{}) (EncoderContext, error) {
+				return NewEncoderContext(ignoreContextEncoderAdapter(promEncoderAdapter(true)))
+			}),
+		DefaultRegistry.RegisterEncoder("remote_write", "parses a Prometheus remote write request",
+			&remoteWriteOptions{}, func(o interface{}) (EncoderContext, error) {
+				adapter, err := remoteWriteEncoderAdapter(o.(*remoteWriteOptions).MaxSize)
+				if err != nil {
+					return nil, err
+				}
+				return NewEncoderContext(ignoreContextEncoderAdapter(adapter))
+			}),
+		DefaultRegistry.RegisterEncoder("statsd", "parses StatsD lines with the DogStatsD extensions, to aggregate with the statsd_aggregate filter",
+			nil, func(interface{}) (EncoderContext, error) {
+				return NewEncoderContext(ignoreContextEncoderAdapter(statsdEncoderAdapter))
+			}),
+
+		DefaultRegistry.RegisterFilter("bypass", "passes measures through unchanged",
+			nil, func(interface{}) (FilterContext, error) {
+				return contextFilter(byPassAdapter())
+			}),
+		DefaultRegistry.RegisterFilter("name_injector", "sets the name of every measure",
+			&nameOptions{}, func(o interface{}) (FilterContext, error) {
+				return contextFilter(nameInjectorAdapter(o.(*nameOptions).Name))
+			}),
+		DefaultRegistry.RegisterFilter("name_sanity", "lower cases measure names and removes their spaces",
+			nil, func(interface{}) (FilterContext, error) {
+				return contextFilter(nameSanityAdapter())
+			}),
+		DefaultRegistry.RegisterFilter("measure_count", "reports the number of measures per name at end of stream",
+			nil, func(interface{}) (FilterContext, error) {
+				return contextFilter(measureCountAdapter())
+			}),
+		DefaultRegistry.RegisterFilter("time_injector", "sets the time of every measure",
+			&timeOptions{}, func(o interface{}) (FilterContext, error) {
+				return contextFilter(timeInjectorAdapter(o.(*timeOptions).Time))
+			}),
+		DefaultRegistry.RegisterFilter("tick_time_injector", "sets the time of every measure to the scheduled run time",
+			nil, func(interface{}) (FilterContext, error) {
+				return NewTickTimeInjectorFilterContext()
+			}),
+		DefaultRegistry.RegisterFilter("tag_injector", "adds tags to every measure",
+			&tagsOptions{}, func(o interface{}) (FilterContext, error) {
+				var tags []Tag
+				for _, name := range sortedKeys(o.(*tagsOptions).Tags) {
+					tags = append(tags, MakeTag(name, o.(*tagsOptions).Tags[name]))
+				}
+				return contextFilter(tagInjectorAdapter(tags...))
+			}),
+		DefaultRegistry.RegisterFilter("field_injector", "adds fields to every measure",
+			&fieldsOptions{}, func(o interface{}) (FilterContext, error) {
+				values := o.(*fieldsOptions).Fields
+				var fields []Field
+				for _, name := range sortedKeys(values) {
+					data := values[name]
+					fields = append(fields, Field{Name: name, Type: FieldValueType(data), Data: data})
+				}
+				return contextFilter(fieldInjectorAdapter(fields...))
+			}),
+		DefaultRegistry.RegisterFilter("keep", "passes on the measures matching an expression and drops the others",
+			&exprOptions{}, func(o interface{}) (FilterContext, error) {
+				return contextFilter(keepAdapter(o.(*exprOptions).Expr))
+			}),
+		DefaultRegistry.RegisterFilter("drop", "drops the measures matching an expression",
+			&exprOptions{}, func(o interface{}) (FilterContext, error) {
+				return contextFilter(dropAdapter(o.(*exprOptions).Expr))
+			}),
+		DefaultRegistry.RegisterFilter("compute", "sets fields computed by expressions",
+			&computeOptions{}, func(o interface{}) (FilterContext, error) {
+				return contextFilter(computeAdapter(o.(*computeOptions).Set...))
+			}),
+		DefaultRegistry.RegisterFilter("rate", "adds the rate, delta or derivative of fields between consecutive samples of a series",
+			&rateOptions{}, func(o interface{}) (FilterContext, error) {
+				opts, err := o.(*rateOptions).options()
+				if err != nil {
+					return nil, err
+				}
+				return contextFilter(rateAdapter(opts, time.Now))
+			}),
+		DefaultRegistry.RegisterFilter("window", "aggregates the fields of every group of measures over tumbling or sliding time windows",
+			&windowOptions{}, func(o interface{}) (FilterContext, error) {
+				return contextFilter(windowAdapter(o.(*windowOptions).options()))
+			}),
+		DefaultRegistry.RegisterFilter("sketch", "estimates quantiles of the fields of every group of measures over time windows with mergeable sketches",
+			&sketchOptions{}, func(o interface{}) (FilterContext, error) {
+				return contextFilter(sketchAdapter(o.(*sketchOptions).options()))
+			}),
+		DefaultRegistry.RegisterFilter("reorder", "passes measures on in time order, dropping or routing those later than the allowed lateness",
+			&reorderOptions{}, func(o interface{}) (FilterContext, error) {
+				opts, err := o.(*reorderOptions).options()
+				if err != nil {
+					return nil, err
+				}
+				return NewReorderFilterContext(opts)
+			}),
+		DefaultRegistry.RegisterFilter("statsd_aggregate", "aggregates the measures of the statsd encoder into counters, gauges, timer percentiles and set cardinalities every flush interval",
+			&statsdAggregateOptions{}, func(o interface{}) (FilterContext, error) {
+				return NewStatsDAggregateFilterContext(o.(*statsdAggregateOptions).options())
+			}),
+		DefaultRegistry.RegisterFilter("cardinality", "limits the number of series per measure name and overall",
+			&cardinalityOptions{}, func(o interface{}) (FilterContext, error) {
+				opts, err := o.(*cardinalityOptions).options()
+				if err != nil {
+					return nil, err
+				}
+				return contextFilter(cardinalityAdapter(opts, time.Now))
+			}),
+		DefaultRegistry.RegisterFilter("log", "logs every measure as indented json",
+			&labelOptions{}, func(o interface{}) (FilterContext, error) {
+				return contextFilter(logAdapter(o.(*labelOptions).Label))
+			}),
+
+		DefaultRegistry.RegisterDecoder("json", "writes every measure as a json document per line",
+			nil, func(interface{}) (DecoderContext, error) {
+				return NewGenericDecoderContext(measureJSONDecoderToWriter)
+			}),
+		DefaultRegistry.RegisterDecoder("gob", "writes measures as a gob stream",
+			nil, func(interface{}) (DecoderContext, error) {
+				return NewDecoderContext(func(ctx context.Context, f Feedback, r MeasureReader, w io.Writer) {
+					measureGobDecoderAdapter(f, r, w)
+				})
+			}),
+
+		DefaultRegistry.RegisterDecoder("influx", "writes InfluxDB line protocol",
+			&precisionOptions{}, func(o interface{}) (DecoderContext, error) {
+				decw, err := lineProtocolDecoderToWriter(o.(*precisionOptions).Precision)
+				if err != nil {
+					return nil, err
+				}
+				return NewGenericDecoderContext(decw)
+			}),
+
+		DefaultRegistry.RegisterSinker("stdout", "writes the decoded stream to the standard output",
+			nil, func(interface{}) (SinkerContext, error) {
+				return contextSinker(NewStdoutSinker())
+			}),
+		DefaultRegistry.RegisterSinker("http", "posts the decoded stream in a single HTTP request",
+			&httpOptions{}, func(o interface{}) (SinkerContext, error) {
+				opts := o.(*httpOptions)
+				return NewRetryHTTPSinkerContext(opts.URL, opts.User, opts.Password, opts.policy())
+			}),
+		DefaultRegistry.RegisterSinker("tcp", "writes the decoded stream to a TCP connection",
+			&addressOptions{}, func(o interface{}) (SinkerContext, error) {
+				return NewTCPSinkerContext(o.(*addressOptions).Address)
+			}),
+
+		DefaultRegistry.RegisterOutput("prometheus", "serves the latest value of every series to Prometheus scrapes",
+			&prometheusOptions{}, func(o interface{}) (OutputContext, error) {
+				opts := o.(*prometheusOptions)
+				return NewPrometheusOutputContext(opts.Address, opts.Path, time.Duration(opts.TTL))
+			}),
+	} {
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+type httpOptions struct {
+	URL             string   `json:"url" mstreamer:"required" doc:"endpoint url"`
+	User            string   `json:"user" doc:"basic auth user"`
+	Password        string   `json:"password" doc:"basic auth password"`
+	MaxAttempts     int      `json:"max_attempts" doc:"requests made before giving up, no retry by default"`
+	BaseBackoff     Duration `json:"base_backoff" doc:"wait before the first retry, doubled on every retry, 500ms by default"`
+	MaxBackoff      Duration `json:"max_backoff" doc:"longest wait between retries, 30s by default"`
+	Jitter          float64  `json:"jitter" doc:"randomized fraction of every wait, between 0 and 1"`
+	RetryableStatus []int    `json:"retryable_status" doc:"status codes retried, 429, 500, 502, 503 and 504 by default"`
+}
+
+func (o *httpOptions) policy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     o.MaxAttempts,
+		BaseBackoff:     time.Duration(o.BaseBackoff),
+		MaxBackoff:      time.Duration(o.MaxBackoff),
+		Jitter:          o.Jitter,
+		RetryableStatus: o.RetryableStatus,
+	}
+}
+
+func (o *httpOptions) Validate() error {
+	return o.policy().Validate()
+}
+
+type tailOptions struct {
+	Path         string   `json:"path" mstreamer:"required" doc:"file followed, or a glob of files"`
+	FromStart    bool     `json:"from_start" doc:"reads the files found on start from their beginning instead of their end"`
+	StateFile    string   `json:"state_file" doc:"file keeping the read offsets across restarts"`
+	PollInterval Duration `json:"poll_interval" doc:"how often files are checked, 1s by default"`
+}
+
+func (o *tailOptions) options() TailOptions {
+	return TailOptions{Path: o.Path, FromStart: o.FromStart, StateFile: o.StateFile, PollInterval: time.Duration(o.PollInterval)}
+}
+
+func (o *tailOptions) Validate() error {
+	return o.options().Validate()
+}
+
+type execOptions struct {
+	Command string   `json:"command" mstreamer:"required" doc:"program run, looked up in PATH"`
+	Args    []string `json:"args" doc:"arguments of the command"`
+	Env     []string `json:"env" doc:"KEY=VALUE variables added to the environment"`
+	Dir     string   `json:"dir" doc:"working directory of the command"`
+	Timeout Duration `json:"timeout" doc:"kills the command when it runs for longer, no timeout by default"`
+}
+
+func (o *execOptions) options() ExecOptions {
+	return ExecOptions{Command: o.Command, Args: o.Args, Env: o.Env, Dir: o.Dir, Timeout: time.Duration(o.Timeout)}
+}
+
+func (o *execOptions) Validate() error {
+	return o.options().Validate()
+}
+
+type remoteWriteOptions struct {
+	MaxSize int64 `json:"max_size" doc:"largest request, compressed and decompressed, 32MiB by default"`
+}
+
+func (o *remoteWriteOptions) Validate() error {
+	if o.MaxSize < 0 {
+		return errors.New("max size must not be negative")
+	}
+	return nil
+}
+
+type listenerOptions struct {
+	Network        string   `json:"network" mstreamer:"required" doc:"tcp, tcp4, tcp6, unix, udp, udp4, udp6 or unixgram"`
+	Addr           string   `json:"addr" mstreamer:"required" doc:"address listened on, as in :2003 or a socket path"`
+	MaxConnections int      `json:"max_connections" doc:"stream connections served at once, no limit by default"`
+	IdleTimeout    Duration `json:"idle_timeout" doc:"closes stream connections idle for that long, never by default"`
+	MaxLineSize    int      `json:"max_line_size" doc:"longest stream line kept, 64KiB by default"`
+	ReadBufferSize int      `json:"read_buffer_size" doc:"operating system receive buffer of packet connections"`
+}
+
+func (o *listenerOptions) options() ListenerOptions {
+	return ListenerOptions{Network: o.Network, Addr: o.Addr, MaxConnections: o.MaxConnections,
+		IdleTimeout: time.Duration(o.IdleTimeout), MaxLineSize: o.MaxLineSize, ReadBufferSize: o.ReadBufferSize}
+}
+
+func (o *listenerOptions) Validate() error {
+	return o.options().Validate()
+}
+
+// componentOptions refers to a registered component from the options of another one
+type componentOptions struct {
+	Type    string                 `json:"type"`
+	Options map[string]interface{} `json:"options"`
+}
+
+// resolve finds the component in r and decodes its options, errors being located at path
+func (c componentOptions) resolve(r *Registry, kind ComponentKind, path string) (Component, interface{}, error) {
+	comp, opts, cerr := r.resolve(kind, ComponentConfig{Path: path, Type: c.Type, Options: c.Options})
+	if cerr != nil {
+		return comp, nil, cerr
+	}
+	return comp, opts, nil
+}
+
+func (c componentOptions) build(r *Registry, kind ComponentKind, path string) (interface{}, error) {
+	comp, opts, err := c.resolve(r, kind, path)
+	if err != nil {
+		return nil, err
+	}
+	v, err := comp.build(opts)
+	if err != nil {
+		return nil, &ConfigError{Path: path, Err: err}
+	}
+	return v, nil
+}
+
+type httpRouteOptions struct {
+	Path        string           `json:"path" doc:"request path matched, any path by default"`
+	ContentType string           `json:"content_type" doc:"media type matched, any by default"`
+	Encoder     componentOptions `json:"encoder" mstreamer:"required" doc:"encoder of the requests, as in {type: influx}"`
+}
+
+type httpReceiverOptions struct {
+	Address     string             `json:"address" mstreamer:"required" doc:"address listened on, as in :8080"`
+	Routes      []httpRouteOptions `json:"routes" mstreamer:"required" doc:"routes tried in order, each with its path, content_type and encoder"`
+	User        string             `json:"user" doc:"basic auth user required"`
+	Password    string             `json:"password" doc:"basic auth password required"`
+	Token       string             `json:"token" doc:"bearer token required, either credential being accepted when both are set"`
+	MaxBodySize int64              `json:"max_body_size" doc:"largest request body once decompressed, 32MiB by default"`
+	registry    *Registry
+}
+
+func (o *httpReceiverOptions) useRegistry(r *Registry) {
+	o.registry = r
+}
+
+// options builds the encoders of the routes out of the registry the options were decoded from
+func (o *httpReceiverOptions) options() (HTTPReceiverOptions, error) {
+	opts := HTTPReceiverOptions{Addr: o.Address, User: o.User, Password: o.Password, Token: o.Token, MaxBodySize: o.MaxBodySize}
+	for i, rt := range o.Routes {
+		enc, err := rt.Encoder.build(o.registry, KindEncoder, fmt.Sprintf("routes[%d].encoder", i))
+		if err != nil {
+			return opts, err
+		}
+		opts.Routes = append(opts.Routes, HTTPRoute{Path: rt.Path, ContentType: rt.ContentType, Encoder: enc.(EncoderContext)})
+	}
+	return opts, nil
+}
+
+func (o *httpReceiverOptions) Validate() error {
+	if len(o.Routes) == 0 {
+		return errors.New("receiver has no route")
+	}
+	for i, rt := range o.Routes {
+		if _, _, err := rt.Encoder.resolve(o.registry, KindEncoder, fmt.Sprintf("routes[%d].encoder", i)); err != nil {
+			return err
+		}
+	}
+	if o.MaxBodySize < 0 {
+		return errors.New("max body size must not be negative")
+	}
+	return nil
+}
+
+type nameOptions struct {
+	Name string `json:"name" mstreamer:"required" doc:"measure name"`
+}
+
+type timeOptions struct {
+	Time int64 `json:"time" mstreamer:"required" doc:"measure time in nanoseconds since epoch"`
+}
+
+type tagsOptions struct {
+	Tags map[string]string `json:"tags" mstreamer:"required" doc:"tag values by tag name"`
+}
+
+type fieldsOptions struct {
+	Fields map[string]interface{} `json:"fields" mstreamer:"required" doc:"field values by field name"`
+}
+
+func (o *fieldsOptions) Validate() error {
+	for name, data := range o.Fields {
+		if FieldValueType(data) == TNil {
+			return errors.New("field " + name + " must be a number, a string or a boolean")
+		}
+	}
+	return nil
+}
+
+type exprOptions struct {
+	Expr string `json:"expr" mstreamer:"required" doc:"bool expression, such as tags.env == \"dev\" && cpu < 5"`
+}
+
+func (o *exprOptions) Validate() error {
+	_, err := predicate(o.Expr)
+	return err
+}
+
+type computeOptions struct {
+	Set []string `json:"set" mstreamer:"required" doc:"assignments run in order, such as mem_pct = used / total * 100"`
+}
+
+func (o *computeOptions) Validate() error {
+	if len(o.Set) == 0 {
+		return errors.New("set needs at least one assignment")
+	}
+	for _, src := range o.Set {
+		if _, _, err := parseAssignment(src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rateOptions struct {
+	Mode      string   `json:"mode" doc:"rate, delta or derivative, rate by default"`
+	Fields    []string `json:"fields" doc:"fields computed, every numeric counter by default"`
+	Suffix    string   `json:"suffix" doc:"appended to the field name to name the computed field, _<mode> by default"`
+	Wrap      int      `json:"wrap" doc:"32 or 64 for counters wrapping around, a decrease is a reset by default"`
+	TTL       Duration `json:"ttl" doc:"forgets the series not seen within this duration, never by default"`
+	EmitFirst bool     `json:"emit_first" doc:"passes on the first sample of a series instead of dropping it"`
+}
+
+func (o *rateOptions) options() (RateOptions, error) {
+	mode, err := ParseRateMode(o.Mode)
+	if err != nil {
+		return RateOptions{}, err
+	}
+	return RateOptions{Mode: mode, Fields: o.Fields, Suffix: o.Suffix, Wrap: o.Wrap, TTL: time.Duration(o.TTL), EmitFirst: o.EmitFirst}, nil
+}
+
+func (o *rateOptions) Validate() error {
+	opts, err := o.options()
+	if err != nil {
+		return err
+	}
+	return opts.Validate()
+}
+
+type windowOptions struct {
+	Size       Duration `json:"size" mstreamer:"required" doc:"length of every window"`
+	Slide      Duration `json:"slide" doc:"time between the starts of sliding windows, tumbling windows by default"`
+	GroupBy    []string `json:"group_by" doc:"tags grouping measures along their name, the others are dropped"`
+	Fields     []string `json:"fields" doc:"fields aggregated, every numeric field by default"`
+	Aggregates []string `json:"aggregates" doc:"min, max, sum, count, mean, first, last or stddev, all by default"`
+}
+
+func (o *windowOptions) options() WindowOptions {
+	return WindowOptions{Size: time.Duration(o.Size), Slide: time.Duration(o.Slide), GroupBy: o.GroupBy, Fields: o.Fields, Aggregates: o.Aggregates}
+}
+
+func (o *windowOptions) Validate() error {
+	return o.options().Validate()
+}
+
+type sketchOptions struct {
+	Size      Duration  `json:"size" mstreamer:"required" doc:"length of every window"`
+	Slide     Duration  `json:"slide" doc:"time between the starts of sliding windows, tumbling windows by default"`
+	GroupBy   []string  `json:"group_by" doc:"tags grouping measures along their name, the others are dropped"`
+	Fields    []string  `json:"fields" doc:"fields sketched, every numeric field by default"`
+	Quantiles []float64 `json:"quantiles" doc:"quantiles emitted, 0.5, 0.95 and 0.99 by default"`
+	Accuracy  float64   `json:"accuracy" doc:"relative accuracy of the quantiles, 0.01 by default"`
+	Serialize bool      `json:"serialize" doc:"adds a <field>_sketch field that sketch filters downstream merge"`
+}
+
+func (o *sketchOptions) options() SketchOptions {
+	return SketchOptions{Size: time.Duration(o.Size), Slide: time.Duration(o.Slide), GroupBy: o.GroupBy,
+		Fields: o.Fields, Quantiles: o.Quantiles, Accuracy: o.Accuracy, Serialize: o.Serialize}
+}
+
+func (o *sketchOptions) Validate() error {
+	return o.options().Validate()
+}
+
+type reorderOptions struct {
+	Lateness Duration           `json:"lateness" mstreamer:"required" doc:"how long a measure may arrive after newer ones and still be put in order"`
+	Late     *lateOutputOptions `json:"late" doc:"decoder and sinker of the late measures, as in {decoder: {type: json}, sinker: {type: stdout}}, dropped by default"`
+	registry *Registry
+}
+
+func (o *reorderOptions) useRegistry(r *Registry) {
+	o.registry = r
+}
+
+type lateOutputOptions struct {
+	Decoder componentOptions `json:"decoder"`
+	Sinker  componentOptions `json:"sinker"`
+}
+
+func (o *reorderOptions) options() (ReorderOptions, error) {
+	opts := ReorderOptions{Lateness: time.Duration(o.Lateness)}
+	if o.Late == nil {
+		return opts, nil
+	}
+	dec, err := o.Late.Decoder.build(o.registry, KindDecoder, "late.decoder")
+	if err != nil {
+		return opts, err
+	}
+	snk, err := o.Late.Sinker.build(o.registry, KindSinker, "late.sinker")
+	if err != nil {
+		return opts, err
+	}
+	opts.Late, err = NewComposedOutputContext(dec.(DecoderContext), snk.(SinkerContext))
+	return opts, err
+}
+
+func (o *reorderOptions) Validate() error {
+	if o.Lateness < 0 {
+		return errors.New("lateness must not be negative")
+	}
+	if o.Late != nil {
+		if _, _, err := o.Late.Decoder.resolve(o.registry, KindDecoder, "late.decoder"); err != nil {
+			return err
+		}
+		if _, _, err := o.Late.Sinker.resolve(o.registry, KindSinker, "late.sinker"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type cardinalityOptions struct {
+	Limit       int      `json:"limit" doc:"series allowed per measure name, no limit by default"`
+	GlobalLimit int      `json:"global_limit" doc:"series allowed across measure names, no limit by default"`
+	Action      string   `json:"action" doc:"drop, strip or overflow the new series past a limit, drop by default"`
+	Tags        []string `json:"tags" doc:"tags stripped or rewritten, the tags from the most values by default"`
+	Overflow    string   `json:"overflow" doc:"value of rewritten tags, __overflow__ by default"`
+	TTL         Duration `json:"ttl" doc:"forgets the series not seen within this duration, never by default"`
+}
+
+func (o *cardinalityOptions) options() (CardinalityOptions, error) {
+	action, err := ParseCardinalityAction(o.Action)
+	if err != nil {
+		return CardinalityOptions{}, err
+	}
+	return CardinalityOptions{Limit: o.Limit, GlobalLimit: o.GlobalLimit, Action: action, Tags: o.Tags,
+		Overflow: o.Overflow, TTL: time.Duration(o.TTL)}, nil
+}
+
+func (o *cardinalityOptions) Validate() error {
+	opts, err := o.options()
+	if err != nil {
+		return err
+	}
+	return opts.Validate()
+}
+
+type statsdAggregateOptions struct {
+	FlushInterval Duration  `json:"flush_interval" doc:"time between flushes, 10s by default"`
+	Percentiles   []float64 `json:"percentiles" doc:"timer percentiles emitted, 0.5, 0.9, 0.95 and 0.99 by default"`
+	DeleteGauges  bool      `json:"delete_gauges" doc:"forgets the gauges not updated since the last flush instead of emitting them again"`
+}
+
+func (o *statsdAggregateOptions) options() StatsDAggregateOptions {
+	return StatsDAggregateOptions{FlushInterval: time.Duration(o.FlushInterval), Percentiles: o.Percentiles, DeleteGauges: o.DeleteGauges}
+}
+
+func (o *statsdAggregateOptions) Validate() error {
+	return o.options().Validate()
+}
+
+type labelOptions struct {
+	Label string `json:"label" doc:"prefix of every log line"`
+}
+
+type precisionOptions struct {
+	Precision string `json:"precision" doc:"timestamp unit: ns, us, ms or s"`
+}
+
+func (o *precisionOptions) Validate() error {
+	_, err := ParsePrecision(o.Precision)
+	return err
+}
+
+type addressOptions struct {
+	Address string `json:"address" mstreamer:"required" doc:"host:port to connect to"`
+}
+
+type prometheusOptions struct {
+	Address string   `json:"address" mstreamer:"required" doc:"host:port to listen on"`
+	Path    string   `json:"path" doc:"path of the metrics endpoint, /metrics by default"`
+	TTL     Duration `json:"ttl" doc:"drops the series not refreshed within this duration, never by default"`
+}
+
+func ignoreContextEncoderAdapter(adapter EncoderAdapter) EncoderAdapterContext {
+	return func(ctx context.Context, f Feedback, r io.Reader, w MeasureWriter) {
+		adapter(f, r, w)
+	}
+}
+
+func ignoreContextFilterAdapter(adapter FilterAdapter) FilterAdapterContext {
+	return func(ctx context.Context, f Feedback, m *Measure, mw MeasureWriter) {
+		adapter(f, m, mw)
+	}
+}
+
+// contextFilter builds a FilterContext out of an adapter and finalizer, so the filter reports its stage
+// telemetry and is aborted by the drain timeout
+func contextFilter(adapter FilterAdapter, finalizer FinalizeAdapter, err error) (FilterContext, error) {
+	if err != nil {
+		return nil, err
+	}
+	return NewFilterContext(ignoreContextFilterAdapter(adapter), finalizer)
+}
+
+func contextSinker(snk Sinker, err error) (SinkerContext, error) {
+	if err != nil {
+		return nil, err
+	}
+	return ContextSinker(snk), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
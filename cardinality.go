@@ -0,0 +1,275 @@
+package mstreamer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CardinalityAction is what a cardinality filter does with a new series past the limit
+type CardinalityAction int
+
+const (
+	//CardinalityDrop drops the measures of new series
+	CardinalityDrop CardinalityAction = iota
+	//CardinalityStrip removes the offending tags from the measures of new series
+	CardinalityStrip
+	//CardinalityOverflow sets the offending tags of the measures of new series to an overflow value
+	CardinalityOverflow
+)
+
+// ParseCardinalityAction parses drop, strip or overflow
+func ParseCardinalityAction(s string) (CardinalityAction, error) {
+	switch s {
+	case "", "drop":
+		return CardinalityDrop, nil
+	case "strip":
+		return CardinalityStrip, nil
+	case "overflow":
+		return CardinalityOverflow, nil
+	default:
+		return 0, fmt.Errorf("unknown cardinality action %q", s)
+	}
+}
+
+// OverflowTagValue is the value rewritten tags take by default
+const OverflowTagValue = "__overflow__"
+
+// cardinalityValueCap bounds the distinct values remembered per tag to rank the offending tags
+const cardinalityValueCap = 10000
+
+// CardinalityOptions configures a cardinality filter
+type CardinalityOptions struct {
+	// Limit is the number of series allowed per measure name, zero for no limit
+	Limit int
+	// GlobalLimit is the number of series allowed across measure names, zero for no limit
+	GlobalLimit int
+	Action      CardinalityAction
+	// Tags are the offending tags stripped or rewritten, by default the tags of the measure one at a time,
+	// from the most distinct values seen for its name
+	Tags []string
+	// Overflow is the value of rewritten tags, OverflowTagValue by default
+	Overflow string
+	// TTL forgets the series not seen for that long, zero keeps them forever
+	TTL time.Duration
+}
+
+// Validate checks that a limit is set and the limits and TTL are not negative
+func (o CardinalityOptions) Validate() error {
+	if o.Limit < 0 || o.GlobalLimit < 0 {
+		return errors.New("series limits must not be negative")
+	}
+	if o.Limit == 0 && o.GlobalLimit == 0 {
+		return errors.New("a series limit is needed")
+	}
+	if o.TTL < 0 {
+		return errors.New("ttl must not be negative")
+	}
+	return nil
+}
+
+// NewCardinalityFilter returns a Filter counting the series, a measure name and its tags, per measure name
+// and overall. Measures of known series pass on. Measures of new series past a limit are dropped, or have
+// their offending tags stripped or rewritten, one tag after the other until the resulting series is known
+// or fits the limits, being dropped when no tag is left.
+// Measure names over their limit are reported with their tags ranked by distinct values through the
+// Feedback every reportInterval and at the end of the stream.
+// The series outlive runs, so limits of a scheduled pipeline span consecutive scrapes
+func NewCardinalityFilter(opts CardinalityOptions) (Filter, error) {
+	return newCardinalityFilter(opts, time.Now)
+}
+
+type cardinalityState struct {
+	mu       sync.Mutex
+	opts     CardinalityOptions
+	series   map[string]*cardinalitySeries
+	perName  map[string]int
+	values   map[string]map[string]map[string]struct{}
+	rejected map[string]int
+	swept    time.Time
+	reported time.Time
+}
+
+type cardinalitySeries struct {
+	name string
+	seen time.Time
+}
+
+func newCardinalityFilter(opts CardinalityOptions, now func() time.Time) (Filter, error) {
+	return adaptedFilter(cardinalityAdapter(opts, now))
+}
+
+func cardinalityAdapter(opts CardinalityOptions, now func() time.Time) (FilterAdapter, FinalizeAdapter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if opts.Overflow == "" {
+		opts.Overflow = OverflowTagValue
+	}
+	c := &cardinalityState{
+		opts:     opts,
+		series:   make(map[string]*cardinalitySeries),
+		perName:  make(map[string]int),
+		values:   make(map[string]map[string]map[string]struct{}),
+		rejected: make(map[string]int),
+		swept:    now(),
+		reported: now(),
+	}
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+			c.mu.Lock()
+			pass := c.admit(now(), m)
+			if now().Sub(c.reported) >= reportInterval {
+				c.report(f, now())
+			}
+			c.mu.Unlock()
+			if pass {
+				mw.Write(*m)
+			}
+		}, func(f Feedback, mw MeasureWriter) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.report(f, now())
+		}, nil
+}
+
+// admit tells whether m passes on, rewriting its tags when the action says so
+func (c *cardinalityState) admit(t time.Time, m *Measure) bool {
+	if c.opts.TTL > 0 && t.Sub(c.swept) >= c.opts.TTL {
+		for key, s := range c.series {
+			if t.Sub(s.seen) > c.opts.TTL {
+				c.perName[s.name]--
+				delete(c.series, key)
+			}
+		}
+		c.values = make(map[string]map[string]map[string]struct{})
+		c.swept = t
+	}
+	key := seriesKey(m.Name, m.Tags)
+	if s, ok := c.series[key]; ok {
+		s.seen = t
+		return true
+	}
+	c.count(m)
+	if c.within(m.Name) {
+		c.add(key, m.Name, t)
+		return true
+	}
+	c.rejected[m.Name]++
+	if c.opts.Action == CardinalityDrop {
+		return false
+	}
+	rewritten := make(map[string]bool)
+	for {
+		offending := c.offending(m, rewritten)
+		if len(offending) == 0 {
+			return false
+		}
+		var tags []Tag
+		for _, tag := range m.Tags {
+			switch {
+			case !offending[tag.Name]:
+				tags = append(tags, tag)
+			case c.opts.Action == CardinalityOverflow:
+				tags = append(tags, Tag{Name: tag.Name, Data: c.opts.Overflow})
+			}
+		}
+		for name := range offending {
+			rewritten[name] = true
+		}
+		m.Tags = tags
+		key = seriesKey(m.Name, m.Tags)
+		if s, ok := c.series[key]; ok {
+			s.seen = t
+			return true
+		}
+		if c.within(m.Name) {
+			c.add(key, m.Name, t)
+			return true
+		}
+	}
+}
+
+// within tells whether a new series of the measure name fits the limits
+func (c *cardinalityState) within(name string) bool {
+	return (c.opts.Limit == 0 || c.perName[name] < c.opts.Limit) &&
+		(c.opts.GlobalLimit == 0 || len(c.series) < c.opts.GlobalLimit)
+}
+
+func (c *cardinalityState) add(key, name string, t time.Time) {
+	c.series[key] = &cardinalitySeries{name: name, seen: t}
+	c.perName[name]++
+}
+
+// count remembers the values of the tags of m, up to cardinalityValueCap per tag
+func (c *cardinalityState) count(m *Measure) {
+	byTag, ok := c.values[m.Name]
+	if !ok {
+		byTag = make(map[string]map[string]struct{})
+		c.values[m.Name] = byTag
+	}
+	for _, tag := range m.Tags {
+		vs, ok := byTag[tag.Name]
+		if !ok {
+			vs = make(map[string]struct{})
+			byTag[tag.Name] = vs
+		}
+		if len(vs) < cardinalityValueCap {
+			vs[tag.Data] = struct{}{}
+		}
+	}
+}
+
+// offending returns the opts.Tags of m, or its tag with the most distinct values, leaving out the tags
+// already rewritten
+func (c *cardinalityState) offending(m *Measure, rewritten map[string]bool) map[string]bool {
+	offending := make(map[string]bool)
+	if len(c.opts.Tags) > 0 {
+		for _, name := range c.opts.Tags {
+			if !rewritten[name] {
+				offending[name] = true
+			}
+		}
+		return offending
+	}
+	best, most := "", 0
+	for _, tag := range m.Tags {
+		if rewritten[tag.Name] {
+			continue
+		}
+		if n := len(c.values[m.Name][tag.Name]); n > most || n == most && tag.Name < best {
+			best, most = tag.Name, n
+		}
+	}
+	if best != "" {
+		offending[best] = true
+	}
+	return offending
+}
+
+// report warns about the measure names that had series rejected since the previous report
+func (c *cardinalityState) report(f Feedback, t time.Time) {
+	for _, name := range sortedKeys(c.rejected) {
+		f.Warn("series limit exceeded", "measure", name, "series", c.perName[name],
+			"rejected", c.rejected[name], "top_tags", c.topTags(name, 3))
+	}
+	c.rejected = make(map[string]int)
+	c.reported = t
+}
+
+// topTags lists the n tags of a measure name with the most distinct values, as tag=count
+func (c *cardinalityState) topTags(name string, n int) string {
+	byTag := c.values[name]
+	tags := sortedKeys(byTag)
+	sort.SliceStable(tags, func(i, j int) bool { return len(byTag[tags[i]]) > len(byTag[tags[j]]) })
+	if len(tags) > n {
+		tags = tags[:n]
+	}
+	top := make([]string, len(tags))
+	for i, tag := range tags {
+		top[i] = fmt.Sprintf("%v=%v", tag, len(byTag[tag]))
+	}
+	return strings.Join(top, " ")
+}
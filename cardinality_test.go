@@ -0,0 +1,138 @@
+package mstreamer
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewCardinalityFilter(t *testing.T) {
+	req := func(name, id, path string) Measure {
+		return Measure{Name: name, Tags: []Tag{{"path", path}, {"request_id", id}}, Flds: []Field{{"n", TInt, int64(1)}}}
+	}
+	in := []Measure{
+		{Name: "http", Tags: []Tag{{"path", "/a"}}, Flds: []Field{{"n", TInt, int64(1)}}},
+		req("http", "1", "/a"), req("http", "2", "/a"), req("http", "1", "/a"),
+		req("http", "3", "/b"), req("http", "4", "/a"), req("db", "5", "/a"),
+	}
+	// every measure of users has unique req and user tags
+	users := []Measure{{Name: "users", Tags: []Tag{{"host", "a"}}}}
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprint(i)
+		users = append(users, Measure{Name: "users", Tags: []Tag{{"host", "a"}, {"req", id}, {"user", id}}})
+	}
+	tests := []struct {
+		name string
+		opts CardinalityOptions
+		// in is the shared input when nil
+		in []Measure
+		// want lists the passed measures as name and tag values
+		want []string
+	}{
+		{
+			name: `when limit is reached then new series are dropped and known series pass`,
+			opts: CardinalityOptions{Limit: 2},
+			want: []string{"http /a", "http /a 1", "http /a 1", "db /a 5"},
+		},
+		{
+			name: `when stripping then the tag with the most values is removed`,
+			opts: CardinalityOptions{Limit: 2, Action: CardinalityStrip},
+			want: []string{"http /a", "http /a 1", "http /a", "http /a 1", "http /a", "db /a 5"},
+		},
+		{
+			name: `when overflowing then the named tags are rewritten and still new series are dropped`,
+			opts: CardinalityOptions{Limit: 3, Action: CardinalityOverflow, Tags: []string{"request_id"}, Overflow: "other"},
+			in:   append([]Measure{req("http", "other", "/a")}, in...),
+			want: []string{"http /a other", "http /a", "http /a 1", "http /a other", "http /a 1", "http /a other", "db /a 5"},
+		},
+		{
+			name: `when global limit is reached then every measure name is limited`,
+			opts: CardinalityOptions{GlobalLimit: 3},
+			want: []string{"http /a", "http /a 1", "http /a 2", "http /a 1"},
+		},
+		{
+			name: `when two tags have high cardinality then both are stripped`,
+			opts: CardinalityOptions{Limit: 2, Action: CardinalityStrip},
+			in:   users,
+			want: append([]string{"users a", "users a 0 0"}, strings.Split(strings.Repeat("users a,", 99), ",")[:99]...),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flt, err := NewCardinalityFilter(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			in := in
+			if tt.in != nil {
+				in = tt.in
+			}
+			r, w := NewChanMeasurePipe(len(in))
+			for _, m := range in {
+				w.Write(m)
+			}
+			w.Close()
+			var events []Event
+			f, _ := NewEventFeedback(func(e Event) { events = append(events, e) })
+			fr, err := flt(f, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			var m Measure
+			for fr.Read(&m) == nil {
+				s := m.Name
+				for _, tag := range m.Tags {
+					s += " " + tag.Data
+				}
+				got = append(got, s)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got measures %q want %q", got, tt.want)
+			}
+			if tt.in != nil {
+				return
+			}
+			reported := false
+			for _, e := range events {
+				reported = reported || strings.Contains(e.String(), "measure=http ") &&
+					strings.Contains(e.String(), "top_tags=request_id=4")
+			}
+			if !reported {
+				t.Errorf("got events %v want request_id reported as top tag of http", events)
+			}
+		})
+	}
+}
+
+func TestCardinalityFilterExpiry(t *testing.T) {
+	now := time.Unix(0, 0)
+	flt, err := newCardinalityFilter(CardinalityOptions{Limit: 1, TTL: time.Minute}, func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(id string) int {
+		r, w := NewChanMeasurePipe(1)
+		w.Write(Measure{Name: "m", Tags: []Tag{{"id", id}}})
+		w.Close()
+		fr, _ := flt(func(string, ...interface{}) {}, r)
+		n := 0
+		var m Measure
+		for fr.Read(&m) == nil {
+			n++
+		}
+		return n
+	}
+	if n := run("a"); n != 1 {
+		t.Fatalf("got %v measures want the first series", n)
+	}
+	if n := run("b"); n != 0 {
+		t.Fatalf("got %v measures want the second series dropped", n)
+	}
+	now = now.Add(2 * time.Minute)
+	if n := run("b"); n != 1 {
+		t.Errorf("got %v measures want the expired series to free its slot", n)
+	}
+}
@@ -0,0 +1,168 @@
+// Command mstreamer runs pipelines described by a json or yaml configuration file
+//
+// Usage:
+//
+//	mstreamer run <config>            runs the pipeline until its inputs end or it is interrupted
+//	mstreamer validate <config>       checks the configuration without building any component
+//	mstreamer dry-run <config>        runs inputs and filters and pretty prints what would be sent
+//	mstreamer list-components         lists the registered components and their options
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gracig/mstreamer"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command in args and returns the exit code of the process
+func run(args []string, stdout, stderr io.Writer) int {
+	if err := command(args, stdout, stderr); err != nil {
+		fmt.Fprintln(stderr, "mstreamer:", err)
+		return 1
+	}
+	return 0
+}
+
+func command(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		usage(stderr)
+		return errors.New("missing command")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "run":
+		return runPipeline(args, false, stdout, stderr)
+	case "dry-run":
+		return runPipeline(args, true, stdout, stderr)
+	case "validate":
+		return validate(args, stdout, stderr)
+	case "list-components":
+		return listComponents(args, stdout, stderr)
+	case "help", "-h", "--help":
+		usage(stdout)
+		return nil
+	default:
+		usage(stderr)
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `Usage: mstreamer <command> [flags] [config]
+
+Commands:
+  run <config>        runs the pipeline until its inputs end or it is interrupted
+  validate <config>   checks the configuration without building any component
+  dry-run <config>    runs inputs and filters and pretty prints measures instead of sinking them
+  list-components     lists the registered components and their options
+`)
+}
+
+func runPipeline(args []string, dry bool, stdout, stderr io.Writer) error {
+	name := "run"
+	if dry {
+		name = "dry-run"
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	drain := fs.Duration("drain-timeout", 0, "time to drain in-flight measures after an interrupt, overrides the configuration")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *drain > 0 {
+		cfg.DrainTimeout = *drain
+	}
+
+	var p mstreamer.RunnableContext
+	if dry {
+		p, err = mstreamer.DefaultRegistry.DryRun(cfg, stdout)
+	} else {
+		p, err = mstreamer.DefaultRegistry.Build(cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	logger := log.New(stderr, "", log.LstdFlags)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// a second signal kills the process right away
+			stop()
+			logger.Printf("shutting down, draining in-flight measures")
+		case <-done:
+		}
+	}()
+	start := time.Now()
+	err = p(ctx, logger.Printf)
+	if errors.Is(err, mstreamer.ErrPipelineCanceled) && !errors.Is(err, mstreamer.ErrDrainTimeout) {
+		logger.Printf("pipeline stopped after %v", time.Since(start).Round(time.Millisecond))
+		return nil
+	}
+	return err
+}
+
+func validate(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := mstreamer.DefaultRegistry.Validate(cfg); err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "configuration is valid")
+	return nil
+}
+
+func listComponents(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("list-components", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, c := range mstreamer.DefaultRegistry.Components() {
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", c.Kind, c.Name, c.Doc)
+		for _, o := range c.OptionFields() {
+			var flags []string
+			if o.Required {
+				flags = append(flags, "required")
+			}
+			flags = append(flags, o.Type)
+			fmt.Fprintf(tw, "\t  %v\t(%v) %v\n", o.Name, strings.Join(flags, ", "), o.Doc)
+		}
+	}
+	return tw.Flush()
+}
+
+func parseConfig(fs *flag.FlagSet, args []string) (*mstreamer.PipelineConfig, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("%v expects exactly one configuration file", fs.Name())
+	}
+	return mstreamer.LoadConfigFile(fs.Arg(0))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		code int
+		// stdout and stderr are expected to contain these
+		stdout string
+		stderr string
+	}{
+		{
+			name:   `when running a pipeline then its measures are sunk`,
+			args:   []string{"run", "testdata/pipeline.yaml"},
+			stdout: "cpu,host=a,env=test usage=1 10\n",
+		},
+		{
+			name:   `when dry running a pipeline then its measures are printed`,
+			args:   []string{"dry-run", "-drain-timeout", "1s", "testdata/pipeline.yaml"},
+			stdout: "outputs[0]: {\n  \"name\": \"cpu\",",
+		},
+		{
+			name:   `when validating a valid configuration then it says so`,
+			args:   []string{"validate", "testdata/pipeline.yaml"},
+			stdout: "configuration is valid\n",
+		},
+		{
+			name:   `when validating an invalid configuration then the error is located`,
+			args:   []string{"validate", "testdata/invalid.yaml"},
+			code:   1,
+			stderr: "mstreamer: filters[0].type: unknown filter \"nope\"\n",
+		},
+		{
+			name:   `when running an invalid configuration then it fails`,
+			args:   []string{"run", "testdata/invalid.yaml"},
+			code:   1,
+			stderr: `filters[0].type: unknown filter "nope"`,
+		},
+		{
+			name:   `when configuration file is missing then it fails`,
+			args:   []string{"dry-run", "testdata/missing.yaml"},
+			code:   1,
+			stderr: "mstreamer: open testdata/missing.yaml",
+		},
+		{
+			name:   `when configuration files are many then it fails`,
+			args:   []string{"validate", "testdata/pipeline.yaml", "testdata/invalid.yaml"},
+			code:   1,
+			stderr: "mstreamer: validate expects exactly one configuration file\n",
+		},
+		{
+			name:   `when a flag is unknown then it fails with the flag usage`,
+			args:   []string{"run", "-nope", "testdata/pipeline.yaml"},
+			code:   1,
+			stderr: "flag provided but not defined: -nope\nUsage of run:",
+		},
+		{
+			name:   `when listing components then kinds, names and options are listed`,
+			args:   []string{"list-components"},
+			stdout: "sinker   tcp",
+		},
+		{
+			name:   `when command is missing then usage is printed`,
+			args:   nil,
+			code:   1,
+			stderr: "Usage: mstreamer <command>",
+		},
+		{
+			name:   `when command is unknown then usage is printed`,
+			args:   []string{"nope"},
+			code:   1,
+			stderr: "mstreamer: unknown command \"nope\"\n",
+		},
+		{
+			name:   `when asking for help then usage is printed`,
+			args:   []string{"help"},
+			stdout: "Usage: mstreamer <command>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			var code int
+			// the stdout sinker writes to the standard output of the process
+			sunk := captureStdout(t, func() { code = run(tt.args, &stdout, &stderr) })
+			stdout.WriteString(sunk)
+			if code != tt.code {
+				t.Errorf("got exit code %v want %v, stderr %q", code, tt.code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.stdout) {
+				t.Errorf("got stdout %q want %q", stdout.String(), tt.stdout)
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("got stderr %q want %q", stderr.String(), tt.stderr)
+			}
+			if tt.code == 0 && strings.Contains(stderr.String(), "mstreamer:") {
+				t.Errorf("got stderr %q want no error", stderr.String())
+			}
+		})
+	}
+}
+
+// captureStdout returns what fn writes to os.Stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stdout := os.Stdout
+	os.Stdout = f
+	defer func() { os.Stdout = stdout }()
+	fn()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,792 @@
+package mstreamer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PipelineConfig describes a pipeline made of registered components
+type PipelineConfig struct {
+	DrainTimeout   time.Duration
+	PipeBufferSize int
+	Schedule       *ScheduleConfig
+	Telemetry      *TelemetryConfig
+	Inputs         []InputConfig
+	Filters        []ComponentConfig
+	Outputs        []OutputConfig
+}
+
+// InputConfig describes the filters applied to an input only, and either its source and its encoder
+// or a registered input component
+type InputConfig struct {
+	Source  ComponentConfig
+	Encoder ComponentConfig
+	Input   *ComponentConfig
+	Filters []ComponentConfig
+}
+
+// OutputConfig describes the filters applied to an output only, and either its decoder and its sinker,
+// optionally batched, or a registered output component. DiskBuffer puts a disk buffer in front of it
+// and Queue bounds the queue feeding it
+type OutputConfig struct {
+	Filters    []ComponentConfig
+	Decoder    ComponentConfig
+	Sinker     ComponentConfig
+	Batch      *BatchOptions
+	Output     *ComponentConfig
+	DiskBuffer *DiskBufferOptions
+	Queue      *QueueConfig
+}
+
+// QueueConfig sizes the queue of an output and sets its overflow policy, see OutputBranch.
+// The spill policy relies on the disk buffer of the output
+type QueueConfig struct {
+	Size     int
+	Overflow OverflowPolicy
+}
+
+// TelemetryConfig adds an input emitting the pipeline stage stats every Interval, see Telemetry
+type TelemetryConfig struct {
+	Interval time.Duration
+}
+
+// ScheduleConfig makes the whole pipeline run periodically
+type ScheduleConfig struct {
+	Schedule Schedule
+	Options  SchedulerOptions
+}
+
+// ComponentConfig refers to a registered component by type and holds its raw options
+type ComponentConfig struct {
+	Path    string
+	Type    string
+	Options map[string]interface{}
+}
+
+// ConfigError is a configuration error located at a path such as inputs[0].source.options.url
+type ConfigError struct {
+	Path string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors gathers every error found in a configuration
+type ConfigErrors []*ConfigError
+
+func (es ConfigErrors) Error() string {
+	var msgs []string
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// LoadConfigFile reads a pipeline configuration from a .json, .yaml or .yml file
+func LoadConfigFile(path string) (*PipelineConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ParseConfig(b, "json")
+	case ".yaml", ".yml":
+		return ParseConfig(b, "yaml")
+	default:
+		return nil, fmt.Errorf("unknown configuration format for %v", path)
+	}
+}
+
+// ParseConfig parses a pipeline configuration document in json or yaml format
+func ParseConfig(b []byte, format string) (*PipelineConfig, error) {
+	var tree interface{}
+	switch format {
+	case "json":
+		if err := json.Unmarshal(b, &tree); err != nil {
+			return nil, fmt.Errorf("invalid json configuration: %w", err)
+		}
+	case "yaml":
+		if err := yaml.Unmarshal(b, &tree); err != nil {
+			return nil, fmt.Errorf("invalid yaml configuration: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown configuration format %q", format)
+	}
+	return decodeConfig(tree)
+}
+
+// Validate checks that every component of cfg is registered and that its options are valid,
+// without building any component
+func (r *Registry) Validate(cfg *PipelineConfig) error {
+	if cfg == nil {
+		return errors.New("configuration is nil")
+	}
+	var errs ConfigErrors
+	if len(cfg.Inputs) == 0 {
+		errs = append(errs, &ConfigError{Path: "inputs", Err: errors.New("at least one entry is required")})
+	}
+	if len(cfg.Outputs) == 0 {
+		errs = append(errs, &ConfigError{Path: "outputs", Err: errors.New("at least one entry is required")})
+	}
+	each(cfg, func(kind ComponentKind, cc ComponentConfig) {
+		if _, _, err := r.resolve(kind, cc); err != nil {
+			errs = append(errs, err)
+		}
+	})
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Build validates cfg and builds a RunnableContext out of its components.
+// Inputs are merged, the pipeline filters are chained and every output receives all the measures
+func (r *Registry) Build(cfg *PipelineConfig) (RunnableContext, error) {
+	return r.build(cfg, nil)
+}
+
+// DryRun validates cfg and builds a RunnableContext that runs its inputs and filters but
+// replaces the decoder and sinker of every output with a pretty printer writing to w
+func (r *Registry) DryRun(cfg *PipelineConfig, w io.Writer) (RunnableContext, error) {
+	if w == nil {
+		return nil, errors.New("writer is nil")
+	}
+	// the printers of the outputs share w
+	lw := &lockedWriter{w: w}
+	return r.build(cfg, func(path string) (OutputContext, error) {
+		return NewPrettyPrintOutputContext(path, lw)
+	})
+}
+
+func (r *Registry) build(cfg *PipelineConfig, output func(path string) (OutputContext, error)) (RunnableContext, error) {
+	if err := r.Validate(cfg); err != nil {
+		return nil, err
+	}
+	b := &configBuilder{registry: r}
+	var inputs []InputContext
+	for _, ic := range cfg.Inputs {
+		var (
+			inp InputContext
+			src SourceContext
+			enc EncoderContext
+		)
+		if ic.Input != nil {
+			inp, _ = b.build(KindInput, *ic.Input).(InputContext)
+		} else {
+			src, _ = b.build(KindSource, ic.Source).(SourceContext)
+			enc, _ = b.build(KindEncoder, ic.Encoder).(EncoderContext)
+		}
+		flt := b.filters(ic.Filters)
+		if b.err != nil {
+			return nil, b.err
+		}
+		var err error
+		if ic.Input == nil {
+			if inp, err = NewComposedInputContext(src, enc); err != nil {
+				return nil, err
+			}
+		}
+		if len(ic.Filters) > 0 {
+			if inp, err = NewFilteredInputContext(inp, flt); err != nil {
+				return nil, err
+			}
+		}
+		inputs = append(inputs, inp)
+	}
+	var telemetry *Telemetry
+	if cfg.Telemetry != nil {
+		telemetry = NewTelemetry()
+		inp, err := NewTelemetryInputContext(telemetry, cfg.Telemetry.Interval)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, inp)
+	}
+	flt := b.filters(cfg.Filters)
+	var outputs []OutputBranch
+	queued := false
+	for i, oc := range cfg.Outputs {
+		oflt := b.filters(oc.Filters)
+		var out OutputContext
+		var err error
+		if output != nil {
+			out, err = output(fmt.Sprintf("outputs[%d]", i))
+		} else if oc.Output != nil {
+			out, _ = b.build(KindOutput, *oc.Output).(OutputContext)
+		} else {
+			dec, _ := b.build(KindDecoder, oc.Decoder).(DecoderContext)
+			snk, _ := b.build(KindSinker, oc.Sinker).(SinkerContext)
+			if b.err == nil && oc.Batch != nil {
+				out, err = NewBatchedOutputContext(dec, snk, *oc.Batch)
+			} else if b.err == nil {
+				out, err = NewComposedOutputContext(dec, snk)
+			}
+		}
+		if b.err != nil {
+			return nil, b.err
+		}
+		if err == nil && output == nil && oc.DiskBuffer != nil {
+			out, err = NewDiskBufferedOutputContext(out, *oc.DiskBuffer)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(oc.Filters) > 0 {
+			if out, err = NewFilteredOutputContext(oflt, out); err != nil {
+				return nil, err
+			}
+		}
+		branch := OutputBranch{Output: out}
+		if oc.Queue != nil {
+			queued = true
+			branch.QueueSize = oc.Queue.Size
+			if oc.Queue.Overflow != OverflowSpill {
+				branch.Overflow = oc.Queue.Overflow
+			}
+		}
+		outputs = append(outputs, branch)
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	inp := inputs[0]
+	if len(inputs) > 1 {
+		var err error
+		if inp, err = NewMergedInputContext(inputs...); err != nil {
+			return nil, err
+		}
+	}
+	out := outputs[0].Output
+	if len(outputs) > 1 || queued {
+		var err error
+		if out, err = NewFanOutOutputContext(outputs...); err != nil {
+			return nil, err
+		}
+	}
+	p, err := NewIOPipelineContext(inp, flt, out)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Schedule != nil {
+		if p, err = NewScheduledRunnable(cfg.Schedule.Schedule, p, cfg.Schedule.Options); err != nil {
+			return nil, err
+		}
+	}
+	return func(ctx context.Context, f Feedback) error {
+		if ctx != nil {
+			if cfg.DrainTimeout > 0 {
+				ctx = WithDrainTimeout(ctx, cfg.DrainTimeout)
+			}
+			if cfg.PipeBufferSize > 0 {
+				ctx = WithPipeBufferSize(ctx, cfg.PipeBufferSize)
+			}
+			if telemetry != nil {
+				ctx = WithTelemetry(ctx, telemetry)
+			}
+		}
+		return p(ctx, f)
+	}, nil
+}
+
+// LoadPipeline reads a configuration file and builds its pipeline with the DefaultRegistry
+func LoadPipeline(path string) (RunnableContext, error) {
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DefaultRegistry.Build(cfg)
+}
+
+// resolve finds the registered component referred by cc and decodes its options
+func (r *Registry) resolve(kind ComponentKind, cc ComponentConfig) (Component, interface{}, *ConfigError) {
+	c, ok := r.Lookup(kind, cc.Type)
+	if !ok {
+		return c, nil, &ConfigError{Path: cc.Path + ".type", Err: fmt.Errorf("unknown %v %q", kind, cc.Type)}
+	}
+	opts, err := c.decodeOptions(r, cc.Options)
+	if err != nil {
+		var cerr *ConfigError
+		if errors.As(err, &cerr) {
+			return c, nil, &ConfigError{Path: cc.Path + ".options." + cerr.Path, Err: cerr.Err}
+		}
+		return c, nil, &ConfigError{Path: cc.Path + ".options", Err: err}
+	}
+	return c, opts, nil
+}
+
+type configBuilder struct {
+	registry *Registry
+	err      error
+}
+
+func (b *configBuilder) build(kind ComponentKind, cc ComponentConfig) interface{} {
+	if b.err != nil {
+		return nil
+	}
+	c, opts, cerr := b.registry.resolve(kind, cc)
+	if cerr != nil {
+		b.err = cerr
+		return nil
+	}
+	v, err := c.build(opts)
+	if err != nil {
+		b.err = &ConfigError{Path: cc.Path, Err: err}
+		return nil
+	}
+	return withComponentIdentity(v, cc.Type, cc.Path)
+}
+
+// withComponentIdentity makes a built component stamp its feedback events with its name and path,
+// and name its stage after the path in the telemetry
+func withComponentIdentity(v interface{}, name, path string) interface{} {
+	switch c := v.(type) {
+	case SourceContext:
+		return SourceContext(func(ctx context.Context, f Feedback) (io.ReadCloser, error) {
+			return c(WithStageName(ctx, path), WithComponent(f, name, path))
+		})
+	case EncoderContext:
+		return EncoderContext(func(ctx context.Context, f Feedback, r io.ReadCloser) (MeasureReader, error) {
+			return c(WithStageName(ctx, path), WithComponent(f, name, path), r)
+		})
+	case InputContext:
+		return InputContext(func(ctx context.Context, f Feedback) (MeasureReader, error) {
+			return c(WithStageName(ctx, path), WithComponent(f, name, path))
+		})
+	case FilterContext:
+		return FilterContext(func(ctx context.Context, f Feedback, r MeasureReader) (MeasureReader, error) {
+			return c(WithStageName(ctx, path), WithComponent(f, name, path), r)
+		})
+	case DecoderContext:
+		return DecoderContext(func(ctx context.Context, f Feedback, r MeasureReader) (io.ReadCloser, error) {
+			return c(WithStageName(ctx, path), WithComponent(f, name, path), r)
+		})
+	case SinkerContext:
+		return SinkerContext(func(ctx context.Context, f Feedback, r io.ReadCloser) error {
+			return c(WithStageName(ctx, path), WithComponent(f, name, path), r)
+		})
+	case OutputContext:
+		return OutputContext(func(ctx context.Context, f Feedback, r MeasureReader) error {
+			return c(WithStageName(ctx, path), WithComponent(f, name, path), r)
+		})
+	}
+	return v
+}
+
+func (b *configBuilder) filters(ccs []ComponentConfig) FilterContext {
+	var flts []FilterContext
+	for _, cc := range ccs {
+		flt, _ := b.build(KindFilter, cc).(FilterContext)
+		flts = append(flts, flt)
+	}
+	if b.err != nil {
+		return nil
+	}
+	flt, err := NewComposedFilterContext(flts...)
+	if err != nil {
+		b.err = err
+	}
+	return flt
+}
+
+// each calls fn for every component of cfg in document order
+func each(cfg *PipelineConfig, fn func(ComponentKind, ComponentConfig)) {
+	for _, ic := range cfg.Inputs {
+		if ic.Input != nil {
+			fn(KindInput, *ic.Input)
+		} else {
+			fn(KindSource, ic.Source)
+			fn(KindEncoder, ic.Encoder)
+		}
+		for _, cc := range ic.Filters {
+			fn(KindFilter, cc)
+		}
+	}
+	for _, cc := range cfg.Filters {
+		fn(KindFilter, cc)
+	}
+	for _, oc := range cfg.Outputs {
+		for _, cc := range oc.Filters {
+			fn(KindFilter, cc)
+		}
+		if oc.Output != nil {
+			fn(KindOutput, *oc.Output)
+			continue
+		}
+		fn(KindDecoder, oc.Decoder)
+		fn(KindSinker, oc.Sinker)
+	}
+}
+
+// decodeConfig walks a generic json or yaml document and builds a PipelineConfig,
+// recording the path of every structural error
+func decodeConfig(tree interface{}) (*PipelineConfig, error) {
+	d := &configDecoder{}
+	cfg := &PipelineConfig{}
+	root := d.object("", tree, "drain_timeout", "pipe_buffer_size", "schedule", "telemetry", "inputs", "filters", "outputs")
+	if root != nil {
+		if v, ok := root["telemetry"]; ok {
+			cfg.Telemetry = d.telemetry("telemetry", v)
+			if root["schedule"] != nil {
+				d.fail("telemetry", "telemetry runs until the pipeline is interrupted and cannot be scheduled")
+			}
+		}
+		if v, ok := root["schedule"]; ok {
+			cfg.Schedule = d.schedule("schedule", v)
+		}
+		if v, ok := root["drain_timeout"]; ok {
+			cfg.DrainTimeout = d.duration("drain_timeout", v)
+		}
+		if v, ok := root["pipe_buffer_size"]; ok {
+			cfg.PipeBufferSize = d.integer("pipe_buffer_size", v)
+		}
+		for i, v := range d.list("inputs", root["inputs"], true) {
+			path := fmt.Sprintf("inputs[%d]", i)
+			obj := d.object(path, v, "source", "encoder", "input", "filters")
+			if obj == nil {
+				continue
+			}
+			var ic InputConfig
+			if in, ok := obj["input"]; ok {
+				if obj["source"] != nil || obj["encoder"] != nil {
+					d.fail(path, "input is mutually exclusive with source and encoder")
+				}
+				cc := d.component(join(path, "input"), in, true)
+				ic.Input = &cc
+			} else {
+				ic.Source = d.component(join(path, "source"), obj["source"], true)
+				ic.Encoder = d.component(join(path, "encoder"), obj["encoder"], true)
+			}
+			ic.Filters = d.components(join(path, "filters"), obj["filters"])
+			cfg.Inputs = append(cfg.Inputs, ic)
+		}
+		cfg.Filters = d.components("filters", root["filters"])
+		for i, v := range d.list("outputs", root["outputs"], true) {
+			path := fmt.Sprintf("outputs[%d]", i)
+			obj := d.object(path, v, "filters", "decoder", "sinker", "batch", "output", "disk_buffer", "queue")
+			if obj == nil {
+				continue
+			}
+			oc := OutputConfig{Filters: d.components(join(path, "filters"), obj["filters"])}
+			if buffer, ok := obj["disk_buffer"]; ok {
+				oc.DiskBuffer = d.diskBuffer(join(path, "disk_buffer"), buffer)
+			}
+			if queue, ok := obj["queue"]; ok {
+				oc.Queue = d.queue(join(path, "queue"), queue)
+				if oc.Queue != nil && oc.Queue.Overflow == OverflowSpill && oc.DiskBuffer == nil {
+					d.fail(join(path, "queue.overflow"), "spill needs a disk_buffer")
+				}
+			}
+			if batch, ok := obj["batch"]; ok {
+				oc.Batch = d.batch(join(path, "batch"), batch)
+			}
+			if out, ok := obj["output"]; ok {
+				if obj["decoder"] != nil || obj["sinker"] != nil || obj["batch"] != nil {
+					d.fail(path, "output is mutually exclusive with decoder, sinker and batch")
+				}
+				cc := d.component(join(path, "output"), out, true)
+				oc.Output = &cc
+			} else {
+				oc.Decoder = d.component(join(path, "decoder"), obj["decoder"], true)
+				oc.Sinker = d.component(join(path, "sinker"), obj["sinker"], true)
+			}
+			cfg.Outputs = append(cfg.Outputs, oc)
+		}
+	}
+	if len(d.errs) > 0 {
+		return nil, d.errs
+	}
+	return cfg, nil
+}
+
+type configDecoder struct {
+	errs ConfigErrors
+}
+
+func (d *configDecoder) fail(path string, format string, a ...interface{}) {
+	d.errs = append(d.errs, &ConfigError{Path: path, Err: fmt.Errorf(format, a...)})
+}
+
+func (d *configDecoder) object(path string, v interface{}, keys ...string) map[string]interface{} {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		d.fail(path, "expected an object, got %v", describe(v))
+		return nil
+	}
+	var unknown []string
+	for k := range obj {
+		known := false
+		for _, key := range keys {
+			if k == key {
+				known = true
+				break
+			}
+		}
+		if !known {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		d.fail(join(path, k), "unknown key")
+	}
+	return obj
+}
+
+func (d *configDecoder) list(path string, v interface{}, required bool) []interface{} {
+	if v == nil {
+		if required {
+			d.fail(path, "at least one entry is required")
+		}
+		return nil
+	}
+	l, ok := v.([]interface{})
+	if !ok {
+		d.fail(path, "expected a list, got %v", describe(v))
+		return nil
+	}
+	if required && len(l) == 0 {
+		d.fail(path, "at least one entry is required")
+	}
+	return l
+}
+
+func (d *configDecoder) component(path string, v interface{}, required bool) ComponentConfig {
+	cc := ComponentConfig{Path: path}
+	if v == nil {
+		if required {
+			d.fail(path, "is required")
+		}
+		return cc
+	}
+	obj := d.object(path, v, "type", "options")
+	if obj == nil {
+		return cc
+	}
+	t, ok := obj["type"].(string)
+	if !ok || t == "" {
+		d.fail(join(path, "type"), "expected a component type name")
+	}
+	cc.Type = t
+	if o, ok := obj["options"]; ok && o != nil {
+		opts, ok := o.(map[string]interface{})
+		if !ok {
+			d.fail(join(path, "options"), "expected an object, got %v", describe(o))
+		}
+		cc.Options = opts
+	}
+	return cc
+}
+
+func (d *configDecoder) components(path string, v interface{}) []ComponentConfig {
+	var ccs []ComponentConfig
+	for i, c := range d.list(path, v, false) {
+		ccs = append(ccs, d.component(fmt.Sprintf("%s[%d]", path, i), c, true))
+	}
+	return ccs
+}
+
+func (d *configDecoder) schedule(path string, v interface{}) *ScheduleConfig {
+	obj := d.object(path, v, "interval", "cron", "jitter", "overlap", "timeout")
+	if obj == nil {
+		return nil
+	}
+	sc := &ScheduleConfig{}
+	interval, hasInterval := obj["interval"]
+	cron, hasCron := obj["cron"]
+	switch {
+	case hasInterval && hasCron:
+		d.fail(path, "interval and cron are mutually exclusive")
+	case hasInterval:
+		sch, err := Every(d.duration(join(path, "interval"), interval))
+		if err != nil {
+			d.fail(join(path, "interval"), "%v", err)
+		}
+		sc.Schedule = sch
+	case hasCron:
+		expr, ok := cron.(string)
+		if !ok {
+			d.fail(join(path, "cron"), "expected a cron expression, got %v", describe(cron))
+			break
+		}
+		sch, err := ParseCron(expr)
+		if err != nil {
+			d.fail(join(path, "cron"), "%v", err)
+		}
+		sc.Schedule = sch
+	default:
+		d.fail(path, "either interval or cron is required")
+	}
+	if v, ok := obj["jitter"]; ok {
+		sc.Options.Jitter = d.duration(join(path, "jitter"), v)
+	}
+	if v, ok := obj["timeout"]; ok {
+		sc.Options.Timeout = d.duration(join(path, "timeout"), v)
+	}
+	if v, ok := obj["overlap"]; ok {
+		s, _ := v.(string)
+		overlap, err := ParseOverlapPolicy(s)
+		if err != nil {
+			d.fail(join(path, "overlap"), "%v", err)
+		}
+		sc.Options.Overlap = overlap
+	}
+	return sc
+}
+
+func (d *configDecoder) batch(path string, v interface{}) *BatchOptions {
+	obj := d.object(path, v, "max_count", "max_bytes", "max_age")
+	if obj == nil {
+		return nil
+	}
+	opts := &BatchOptions{}
+	if v, ok := obj["max_count"]; ok {
+		opts.MaxCount = d.integer(join(path, "max_count"), v)
+	}
+	if v, ok := obj["max_bytes"]; ok {
+		opts.MaxBytes = d.integer(join(path, "max_bytes"), v)
+	}
+	if v, ok := obj["max_age"]; ok {
+		opts.MaxAge = d.duration(join(path, "max_age"), v)
+	}
+	if opts.MaxCount <= 0 && opts.MaxBytes <= 0 && opts.MaxAge <= 0 {
+		d.fail(path, "at least one positive max_count, max_bytes or max_age is required")
+	}
+	return opts
+}
+
+func (d *configDecoder) diskBuffer(path string, v interface{}) *DiskBufferOptions {
+	obj := d.object(path, v, "dir", "segment_size", "max_size", "drop", "retry_backoff")
+	if obj == nil {
+		return nil
+	}
+	opts := &DiskBufferOptions{}
+	if dir, ok := obj["dir"].(string); ok && dir != "" {
+		opts.Dir = dir
+	} else {
+		d.fail(join(path, "dir"), "expected a directory, got %v", describe(obj["dir"]))
+	}
+	if v, ok := obj["segment_size"]; ok {
+		opts.SegmentSize = int64(d.integer(join(path, "segment_size"), v))
+	}
+	if v, ok := obj["max_size"]; ok {
+		opts.MaxSize = int64(d.integer(join(path, "max_size"), v))
+	}
+	if v, ok := obj["drop"]; ok {
+		s, _ := v.(string)
+		drop, err := ParseDropPolicy(s)
+		if err != nil {
+			d.fail(join(path, "drop"), "%v", err)
+		}
+		opts.Drop = drop
+	}
+	if v, ok := obj["retry_backoff"]; ok {
+		opts.RetryBackoff = d.duration(join(path, "retry_backoff"), v)
+	}
+	return opts
+}
+
+func (d *configDecoder) telemetry(path string, v interface{}) *TelemetryConfig {
+	obj := d.object(path, v, "interval")
+	if obj == nil {
+		return nil
+	}
+	tc := &TelemetryConfig{}
+	if v, ok := obj["interval"]; ok {
+		tc.Interval = d.duration(join(path, "interval"), v)
+	}
+	if tc.Interval <= 0 {
+		d.fail(join(path, "interval"), "a positive interval is required")
+	}
+	return tc
+}
+
+func (d *configDecoder) queue(path string, v interface{}) *QueueConfig {
+	obj := d.object(path, v, "size", "overflow")
+	if obj == nil {
+		return nil
+	}
+	qc := &QueueConfig{}
+	if v, ok := obj["size"]; ok {
+		if qc.Size = d.integer(join(path, "size"), v); qc.Size < 0 {
+			d.fail(join(path, "size"), "must not be negative")
+		}
+	}
+	if v, ok := obj["overflow"]; ok {
+		s, _ := v.(string)
+		overflow, err := ParseOverflowPolicy(s)
+		if err != nil {
+			d.fail(join(path, "overflow"), "%v", err)
+		}
+		qc.Overflow = overflow
+	}
+	return qc
+}
+
+func (d *configDecoder) duration(path string, v interface{}) time.Duration {
+	s, ok := v.(string)
+	if !ok {
+		d.fail(path, "expected a duration such as \"5s\", got %v", describe(v))
+		return 0
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		d.fail(path, "%v", err)
+	}
+	return dur
+}
+
+func (d *configDecoder) integer(path string, v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		if n == float64(int(n)) {
+			return int(n)
+		}
+	}
+	d.fail(path, "expected an integer, got %v", describe(v))
+	return 0
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describe(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nothing"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	default:
+		return fmt.Sprintf("%T %v", v, v)
+	}
+}
@@ -0,0 +1,483 @@
+package mstreamer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRegistryValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		doc     string
+		wantErr string
+	}{
+		{
+			name: `when configuration is valid then should not fail`, format: "yaml",
+			doc: `
+inputs:
+  - source: {type: http, options: {url: "http://localhost/metrics"}}
+    encoder: {type: json}
+filters:
+  - {type: name_injector, options: {name: cpu}}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: stdout}
+`,
+		},
+		{
+			name: `when component type is unknown then should point at its type`, format: "json",
+			doc: `{"inputs":[{"source":{"type":"http","options":{"url":"x"}},"encoder":{"type":"json"}}],
+				"filters":[{"type":"name_injector","options":{"name":"cpu"}},{"type":"nope"}],
+				"outputs":[{"decoder":{"type":"json"},"sinker":{"type":"stdout"}}]}`,
+			wantErr: `filters[1].type: unknown filter "nope"`,
+		},
+		{
+			name: `when required option is missing then should point at the option`, format: "yaml",
+			doc: `
+inputs:
+  - source: {type: http}
+    encoder: {type: json}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: stdout}
+`,
+			wantErr: `inputs[0].source.options.url: required option is missing`,
+		},
+		{
+			name: `when option has the wrong type then should point at the option`, format: "yaml",
+			doc: `
+inputs:
+  - source: {type: http, options: {url: x}}
+    encoder: {type: json}
+    filters:
+      - {type: time_injector, options: {time: now}}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: stdout}
+`,
+			wantErr: `inputs[0].filters[0].options.time: expected integer`,
+		},
+		{
+			name: `when option is unknown then should point at the options`, format: "yaml",
+			doc: `
+inputs:
+  - source: {type: http, options: {url: x, verb: POST}}
+    encoder: {type: json}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: stdout}
+`,
+			wantErr: `inputs[0].source.options: unknown field "verb"`,
+		},
+		{
+			name: `when outputs are missing then should fail`, format: "yaml",
+			doc: `
+inputs:
+  - source: {type: http, options: {url: x}}
+    encoder: {type: json}
+`,
+			wantErr: `outputs: at least one entry is required`,
+		},
+		{
+			name: `when a key is unknown then should point at the key`, format: "yaml",
+			doc: `
+inputs:
+  - source: {type: http, options: {url: x}}
+    encoder: {type: json}
+    decoder: {type: json}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: stdout}
+`,
+			wantErr: `inputs[0].decoder: unknown key`,
+		},
+		{
+			name: `when output replaces decoder and sinker then should not fail`, format: "yaml",
+			doc: `
+inputs:
+  - source: {type: http, options: {url: x}}
+    encoder: {type: prometheus}
+outputs:
+  - output: {type: prometheus, options: {address: ":9100", ttl: 5m}}
+`,
+		},
+		{
+			name: `when batch has no bound then should point at the batch`, format: "yaml",
+			doc: `
+inputs:
+  - source: {type: http, options: {url: x}}
+    encoder: {type: json}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: http, options: {url: x}}
+    batch: {max_age: 0s}
+`,
+			wantErr: `outputs[0].batch: at least one positive max_count, max_bytes or max_age is required`,
+		},
+		{
+			name: `when disk buffer has no directory then should point at it`, format: "yaml",
+			doc: `
+inputs:
+  - source: {type: http, options: {url: x}}
+    encoder: {type: json}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: stdout}
+    disk_buffer: {max_size: 1000, drop: newest}
+`,
+			wantErr: `outputs[0].disk_buffer.dir: expected a directory, got nothing`,
+		},
+		{
+			name: `when queue spills without disk buffer then should point at the overflow`, format: "yaml",
+			doc: `
+inputs:
+  - source: {type: http, options: {url: x}}
+    encoder: {type: json}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: stdout}
+    queue: {size: 100, overflow: spill}
+  - decoder: {type: json}
+    sinker: {type: stdout}
+    queue: {overflow: drop_oldest}
+`,
+			wantErr: `outputs[0].queue.overflow: spill needs a disk_buffer`,
+		},
+		{
+			name: `when telemetry is scheduled then should point at the telemetry`, format: "yaml",
+			doc: `
+schedule: {interval: 10s}
+telemetry: {interval: 10s}
+inputs:
+  - source: {type: http, options: {url: x}}
+    encoder: {type: json}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: stdout}
+`,
+			wantErr: `telemetry: telemetry runs until the pipeline is interrupted and cannot be scheduled`,
+		},
+		{
+			name: `when late output sinker is unknown then should point at it`, format: "yaml",
+			doc: `
+inputs:
+  - source: {type: http, options: {url: x}}
+    encoder: {type: json}
+filters:
+  - {type: reorder, options: {lateness: 10s, late: {decoder: {type: json}, sinker: {type: nope}}}}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: stdout}
+`,
+			wantErr: `filters[0].options.late.sinker.type: unknown sinker "nope"`,
+		},
+		{
+			name: `when input is an http receiver then should not fail`, format: "yaml",
+			doc: `
+inputs:
+  - input:
+      type: http_receiver
+      options:
+        address: ":8080"
+        token: secret
+        routes:
+          - {path: /write, encoder: {type: influx, options: {precision: s}}}
+          - {path: /api/v1/write, content_type: application/x-protobuf, encoder: {type: remote_write}}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: stdout}
+`,
+		},
+		{
+			name: `when route encoder is unknown then should point at the route`, format: "yaml",
+			doc: `
+inputs:
+  - input: {type: http_receiver, options: {address: ":8080", routes: [{encoder: {type: nope}}]}}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: stdout}
+`,
+			wantErr: `inputs[0].input.options.routes[0].encoder.type: unknown encoder "nope"`,
+		},
+		{
+			name: `when input comes with a source then should fail`, format: "yaml",
+			doc: `
+inputs:
+  - input: {type: http_receiver, options: {address: ":8080", routes: [{encoder: {type: json}}]}}
+    source: {type: http, options: {url: x}}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: stdout}
+`,
+			wantErr: `inputs[0]: input is mutually exclusive with source and encoder`,
+		},
+		{
+			name: `when output comes with a sinker then should fail`, format: "yaml",
+			doc: `
+inputs:
+  - source: {type: http, options: {url: x}}
+    encoder: {type: json}
+outputs:
+  - output: {type: prometheus, options: {address: ":9100"}}
+    sinker: {type: stdout}
+`,
+			wantErr: `outputs[0]: output is mutually exclusive with decoder, sinker and batch`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseConfig([]byte(tt.doc), tt.format)
+			if err == nil {
+				err = DefaultRegistry.Validate(cfg)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("got error %v want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegistryEmptyConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *PipelineConfig
+		want string
+	}{
+		{
+			name: `when configuration is empty then inputs and outputs are required`,
+			cfg:  &PipelineConfig{},
+			want: "inputs: at least one entry is required\noutputs: at least one entry is required",
+		},
+		{
+			name: `when outputs are empty then they are required`,
+			cfg: &PipelineConfig{Inputs: []InputConfig{{
+				Source:  ComponentConfig{Path: "inputs[0].source", Type: "http", Options: map[string]interface{}{"url": "x"}},
+				Encoder: ComponentConfig{Path: "inputs[0].encoder", Type: "json"},
+			}}},
+			want: "outputs: at least one entry is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := DefaultRegistry.Validate(tt.cfg); err == nil || err.Error() != tt.want {
+				t.Errorf("got validate error %v want %v", err, tt.want)
+			}
+			var errs ConfigErrors
+			if _, err := DefaultRegistry.Build(tt.cfg); !errors.As(err, &errs) || errs.Error() != tt.want {
+				t.Errorf("got build error %v want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// testRegistry registers a static source, json encoder and decoder, a name_injector filter and a buffer
+// sinker writing to out
+func testRegistry(out io.Writer) *Registry {
+	type staticOptions struct {
+		Body string `json:"body" mstreamer:"required"`
+	}
+	var mu sync.Mutex
+	r := NewRegistry()
+	r.RegisterSource("static", "", &staticOptions{}, func(o interface{}) (SourceContext, error) {
+		body := o.(*staticOptions).Body
+		return NewSourceContext(func(ctx context.Context, f Feedback, w io.Writer) {
+			io.WriteString(w, body)
+		})
+	})
+	r.RegisterEncoder("json", "", nil, func(interface{}) (EncoderContext, error) {
+		return NewEncoderContext(ignoreContextEncoderAdapter(measureJSONEncoderAdapter))
+	})
+	r.RegisterFilter("name_injector", "", &nameOptions{}, func(o interface{}) (FilterContext, error) {
+		return contextFilter(nameInjectorAdapter(o.(*nameOptions).Name))
+	})
+	r.RegisterDecoder("json", "", nil, func(interface{}) (DecoderContext, error) {
+		return NewGenericDecoderContext(measureJSONDecoderToWriter)
+	})
+	r.RegisterSinker("buffer", "", nil, func(interface{}) (SinkerContext, error) {
+		return NewSinkerContext(func(ctx context.Context, f Feedback, rc io.ReadCloser) error {
+			mu.Lock()
+			defer mu.Unlock()
+			_, err := io.Copy(out, rc)
+			return err
+		})
+	})
+	return r
+}
+
+const testRegistryConfig = `
+drain_timeout: 1s
+inputs:
+  - source: {type: static, options: {body: '{"name":"a","flds":[{"name":"v","type":105,"data":4}],"time":1}'}}
+    encoder: {type: json}
+  - source: {type: static, options: {body: '{"name":"b","flds":[{"name":"v","type":105,"data":4}],"time":1}'}}
+    encoder: {type: json}
+filters:
+  - {type: name_injector, options: {name: cpu}}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: buffer}
+  - decoder: {type: json}
+    sinker: {type: buffer}
+`
+
+func TestRegistryBuild(t *testing.T) {
+	var out bytes.Buffer
+	r := testRegistry(&out)
+	cfg, err := ParseConfig([]byte(testRegistryConfig), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.Build(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p(context.Background(), t.Logf); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"name":"cpu","flds":[{"name":"v","type":105,"data":4}],"time":1}`
+	if got := strings.Count(out.String(), want); got != 4 {
+		t.Errorf("got %v measures %q want 4 of %v", got, out.String(), want)
+	}
+}
+
+func TestRegistryDryRun(t *testing.T) {
+	var out, printed bytes.Buffer
+	r := testRegistry(&out)
+	cfg, err := ParseConfig([]byte(testRegistryConfig), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.DryRun(cfg, nil); err == nil {
+		t.Errorf("got nil error for a nil writer")
+	}
+	p, err := r.DryRun(cfg, &printed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p(context.Background(), t.Logf); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("got %q sunk want nothing", out.String())
+	}
+	for _, label := range []string{"outputs[0]: {", "outputs[1]: {"} {
+		if got := strings.Count(printed.String(), label); got != 2 {
+			t.Errorf("got %v measures printed as %q want 2 in %q", got, label, printed.String())
+		}
+	}
+	if got := strings.Count(printed.String(), `"name": "cpu"`); got != 4 {
+		t.Errorf("got %v filtered measures want 4 in %q", got, printed.String())
+	}
+}
+
+func TestRegistryBuildFilterTelemetry(t *testing.T) {
+	var out bytes.Buffer
+	r := testRegistry(&out)
+	for _, name := range []string{"keep", "rate"} {
+		c, _ := DefaultRegistry.Lookup(KindFilter, name)
+		if err := r.register(KindFilter, c.Name, c.Doc, c.Options, c.build); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := ParseConfig([]byte(`
+inputs:
+  - source: {type: static, options: {body: "{\"name\":\"a\",\"flds\":[{\"name\":\"v\",\"type\":105,\"data\":4}],\"time\":1}\n{\"name\":\"b\",\"flds\":[{\"name\":\"v\",\"type\":105,\"data\":4}],\"time\":1}\n"}}
+    encoder: {type: json}
+filters:
+  - {type: keep, options: {expr: 'name == "a"'}}
+  - {type: rate, options: {mode: delta}}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: buffer}
+`), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.Build(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := NewTelemetry()
+	if err := p(WithTelemetry(context.Background(), tm), t.Logf); err != nil {
+		t.Fatal(err)
+	}
+	stages := make(map[string]StageStats)
+	for _, s := range tm.Stats() {
+		if s.Kind == "filter" {
+			stages[s.Name] = s
+		}
+	}
+	tests := []struct {
+		stage                  string
+		read, written, dropped uint64
+	}{
+		{stage: "filters[0]", read: 2, written: 1, dropped: 1},
+		{stage: "filters[1]", read: 1, written: 0, dropped: 1},
+	}
+	for _, tt := range tests {
+		s, ok := stages[tt.stage]
+		if !ok {
+			t.Errorf("got no telemetry for stage %v in %+v", tt.stage, stages)
+			continue
+		}
+		if s.Read != tt.read || s.Written != tt.written || s.Dropped != tt.dropped {
+			t.Errorf("got %+v want %v read, %v written and %v dropped for stage %v", s, tt.read, tt.written, tt.dropped, tt.stage)
+		}
+	}
+}
+
+func TestRegistryBuildNestedComponents(t *testing.T) {
+	var out, late bytes.Buffer
+	r := testRegistry(&out)
+	c, _ := DefaultRegistry.Lookup(KindFilter, "reorder")
+	if err := r.register(KindFilter, c.Name, c.Doc, c.Options, c.build); err != nil {
+		t.Fatal(err)
+	}
+	r.RegisterSinker("late", "", nil, func(interface{}) (SinkerContext, error) {
+		return NewSinkerContext(func(ctx context.Context, f Feedback, rc io.ReadCloser) error {
+			_, err := io.Copy(&late, rc)
+			return err
+		})
+	})
+	cfg, err := ParseConfig([]byte(`
+inputs:
+  - source: {type: static, options: {body: "{\"name\":\"m\",\"flds\":[{\"name\":\"v\",\"type\":105,\"data\":1}],\"time\":10000000000}\n{\"name\":\"m\",\"flds\":[{\"name\":\"v\",\"type\":105,\"data\":2}],\"time\":5000000000}\n"}}
+    encoder: {type: json}
+filters:
+  - {type: reorder, options: {lateness: 1s, late: {decoder: {type: json}, sinker: {type: late}}}}
+outputs:
+  - decoder: {type: json}
+    sinker: {type: buffer}
+`), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.Build(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p(context.Background(), t.Logf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"data":1`) {
+		t.Errorf("got %q sunk want the measure in time", out.String())
+	}
+	if !strings.Contains(late.String(), `"data":2`) {
+		t.Errorf("got %q sunk late want the late measure", late.String())
+	}
+}
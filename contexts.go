@@ -0,0 +1,162 @@
+package mstreamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrPipelineCanceled is returned by a RunnableContext when its context is done before the pipeline ends
+var ErrPipelineCanceled = errors.New("pipeline canceled")
+
+// ErrDrainTimeout is the cause used to abort components that did not drain before the drain timeout
+var ErrDrainTimeout = errors.New("pipeline drain timeout exceeded")
+
+// Components built with the Context constructors follow the same shutdown sequence:
+// sources and producers end their streams as soon as the context is done, so every
+// downstream stage reads end of stream, runs its FinalizeAdapter and drains what is in flight.
+// Adapters of downstream stages receive a context that is only done once the drain timeout
+// elapses, at which point every pipe still open is closed with ErrDrainTimeout.
+
+// DefaultDrainTimeout is the time components have to drain in-flight measures after cancellation
+const DefaultDrainTimeout = 5 * time.Second
+
+type drainTimeoutKey struct{}
+
+// WithDrainTimeout returns a copy of ctx carrying the time components have to drain
+// in-flight measures once ctx is done. Past that time every pipe is closed with ErrDrainTimeout
+func WithDrainTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, drainTimeoutKey{}, d)
+}
+
+// DrainTimeout returns the drain timeout carried by ctx or DefaultDrainTimeout
+func DrainTimeout(ctx context.Context) time.Duration {
+	if d, ok := ctx.Value(drainTimeoutKey{}).(time.Duration); ok {
+		return d
+	}
+	return DefaultDrainTimeout
+}
+
+// ContextInput lifts an Input into an InputContext. The wrapped Input ignores the context
+func ContextInput(i Input) InputContext {
+	return func(ctx context.Context, f Feedback) (MeasureReader, error) {
+		return i(f)
+	}
+}
+
+// ContextFilter lifts a Filter into a FilterContext. The wrapped Filter ignores the context
+// and stops when its upstream reaches end of stream
+func ContextFilter(flt Filter) FilterContext {
+	return func(ctx context.Context, f Feedback, r MeasureReader) (MeasureReader, error) {
+		return flt(f, r)
+	}
+}
+
+// ContextOutput lifts an Output into an OutputContext. The wrapped Output ignores the context
+func ContextOutput(o Output) OutputContext {
+	return func(ctx context.Context, f Feedback, r MeasureReader) error {
+		return o(f, r)
+	}
+}
+
+// ContextSource lifts a Source into a SourceContext. The wrapped Source ignores the context
+func ContextSource(src Source) SourceContext {
+	return func(ctx context.Context, f Feedback) (io.ReadCloser, error) {
+		return src(f)
+	}
+}
+
+// ContextEncoder lifts an Encoder into an EncoderContext. The wrapped Encoder ignores the context
+func ContextEncoder(enc Encoder) EncoderContext {
+	return func(ctx context.Context, f Feedback, r io.ReadCloser) (MeasureReader, error) {
+		return enc(f, r)
+	}
+}
+
+// ContextDecoder lifts a Decoder into a DecoderContext. The wrapped Decoder ignores the context
+func ContextDecoder(dec Decoder) DecoderContext {
+	return func(ctx context.Context, f Feedback, r MeasureReader) (io.ReadCloser, error) {
+		return dec(f, r)
+	}
+}
+
+// ContextSinker lifts a Sinker into a SinkerContext. The wrapped Sinker ignores the context
+func ContextSinker(snk Sinker) SinkerContext {
+	return func(ctx context.Context, f Feedback, r io.ReadCloser) error {
+		return snk(f, r)
+	}
+}
+
+// canceledError builds the error returned by runnables whose context is done
+func canceledError(ctx, dctx context.Context) error {
+	if errors.Is(context.Cause(dctx), ErrDrainTimeout) {
+		return fmt.Errorf("%w: %w: %w", ErrPipelineCanceled, context.Cause(ctx), ErrDrainTimeout)
+	}
+	return fmt.Errorf("%w: %w", ErrPipelineCanceled, context.Cause(ctx))
+}
+
+// drainContext returns a context carrying the values of ctx that is done only when
+// the drain timeout has elapsed after ctx is done. Its cause is then ErrDrainTimeout
+func drainContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	dctx, cancel := context.WithCancelCause(detachedContext{ctx})
+	if ctx.Done() == nil {
+		return dctx, func() { cancel(nil) }
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-dctx.Done():
+			return
+		}
+		t := time.NewTimer(DrainTimeout(ctx))
+		defer t.Stop()
+		select {
+		case <-t.C:
+			cancel(ErrDrainTimeout)
+		case <-dctx.Done():
+		}
+	}()
+	return dctx, func() { cancel(nil) }
+}
+
+// detachedContext keeps the values of its parent but is never done
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// onDone calls fn once ctx is done unless the returned stop function is called before
+func onDone(ctx context.Context, fn func()) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stopc := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			fn()
+		case <-stopc:
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(stopc) }) }
+}
+
+// closeMeasureReader releases the stream behind a MeasureReader, if any,
+// so upstream writers blocked on it are unblocked
+func closeMeasureReader(r MeasureReader) {
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// aborted tells whether a read error was caused by the stream being aborted after the drain timeout
+func aborted(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || errors.Is(err, ErrDrainTimeout) || errors.Is(err, io.ErrClosedPipe)
+}
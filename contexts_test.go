@@ -0,0 +1,86 @@
+package mstreamer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewIOPipelineContext(t *testing.T) {
+	producer := func(ctx context.Context, f Feedback, w MeasureWriter) {
+		for ctx.Err() == nil {
+			if err := w.Write(Measure{Name: "sample", Time: 1}); err != nil {
+				return
+			}
+		}
+	}
+	var finalized int32
+	finalizer := func(f Feedback, mw MeasureWriter) {
+		atomic.StoreInt32(&finalized, 1)
+	}
+	inp, err := NewInputFromProducerContext(producer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flt, err := NewFilterContext(func(ctx context.Context, f Feedback, m *Measure, mw MeasureWriter) {
+		mw.Write(*m)
+	}, finalizer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		handler      func(ctx context.Context, m Measure) error
+		wantDrain    bool
+		wantFinalize bool
+	}{
+		{
+			name:         `when context is cancelled then pipeline drains, finalizes and reports cancellation`,
+			handler:      func(ctx context.Context, m Measure) error { return nil },
+			wantDrain:    false,
+			wantFinalize: true,
+		},
+		{
+			name: `when output does not drain before timeout then pipeline is aborted`,
+			handler: func(ctx context.Context, m Measure) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			wantDrain: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&finalized, 0)
+			out, err := NewOutputContext(tt.handler)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := NewIOPipelineContext(inp, flt, out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(WithDrainTimeout(context.Background(), 100*time.Millisecond))
+			time.AfterFunc(20*time.Millisecond, cancel)
+			done := make(chan error)
+			go func() { done <- p(ctx, t.Logf) }()
+			select {
+			case err = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("pipeline did not stop after cancellation")
+			}
+			if !errors.Is(err, ErrPipelineCanceled) || !errors.Is(err, context.Canceled) {
+				t.Errorf("got error %v want %v", err, ErrPipelineCanceled)
+			}
+			if errors.Is(err, ErrDrainTimeout) != tt.wantDrain {
+				t.Errorf("got error %v, want drain timeout %v", err, tt.wantDrain)
+			}
+			if tt.wantFinalize && atomic.LoadInt32(&finalized) != 1 {
+				t.Errorf("finalizer was not called")
+			}
+		})
+	}
+}
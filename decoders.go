@@ -1,13 +1,19 @@
 package mstreamer
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"io"
+	"time"
 )
 
 // DecoderAdapter takes
 type DecoderAdapter func(Feedback, MeasureReader, io.Writer)
 
+// DecoderAdapterContext is a DecoderAdapter that receives the decoder drain context
+type DecoderAdapterContext func(context.Context, Feedback, MeasureReader, io.Writer)
+
 // DecoderToWriter das
 type DecoderToWriter func(Measure, io.Writer) error
 
@@ -16,21 +22,64 @@ func NewGenericDecoder(decw DecoderToWriter) (Decoder, error) {
 	return newGenericDecoder(decw)
 }
 
+// NewGenericDecoderContext is the DecoderContext counterpart of NewGenericDecoder
+func NewGenericDecoderContext(decw DecoderToWriter) (DecoderContext, error) {
+	return newGenericDecoderContext(decw)
+}
+
+// NewMeasureJSONDecoder returns a Decoder that writes every measure as a json document per line
+func NewMeasureJSONDecoder() (Decoder, error) {
+	return NewGenericDecoder(measureJSONDecoderToWriter)
+}
+
+// NewMeasureGobDecoder returns a Decoder that writes measures as a gob stream
+func NewMeasureGobDecoder() (Decoder, error) {
+	return NewDecoder(measureGobDecoderAdapter)
+}
+
 // NewDecoder takes
 func NewDecoder(adapter DecoderAdapter) (Decoder, error) {
 	return newDecoder(adapter, io.Pipe)
 }
+
+// NewDecoderContext takes an adapter and returns a DecoderContext that is aborted once the drain timeout expires
+func NewDecoderContext(adapter DecoderAdapterContext) (DecoderContext, error) {
+	return newDecoderContext(adapter, io.Pipe)
+}
+
 func newDecoder(
 	adapter DecoderAdapter,
 	ioPipe func() (*io.PipeReader, *io.PipeWriter),
 ) (Decoder, error) {
+	if adapter == nil {
+		return nil, errors.New("adapter function is nil")
+	}
+	dec, err := newDecoderContext(
+		func(ctx context.Context, f Feedback, r MeasureReader, w io.Writer) {
+			adapter(f, r, w)
+		}, ioPipe)
+	if err != nil {
+		return nil, err
+	}
+	return func(f Feedback, r MeasureReader) (io.ReadCloser, error) {
+		return dec(context.Background(), f, r)
+	}, nil
+}
+
+func newDecoderContext(
+	adapter DecoderAdapterContext,
+	ioPipe func() (*io.PipeReader, *io.PipeWriter),
+) (DecoderContext, error) {
 	if adapter == nil {
 		return nil, errors.New("adapter function is nil")
 	}
 	if ioPipe == nil {
 		return nil, errors.New("iopipe is nil")
 	}
-	return func(f Feedback, r MeasureReader) (io.ReadCloser, error) {
+	return func(ctx context.Context, f Feedback, r MeasureReader) (io.ReadCloser, error) {
+		if ctx == nil {
+			return nil, errors.New("context is nil")
+		}
 		if f == nil {
 			return nil, errors.New("feedback funcion is nil")
 		}
@@ -39,18 +88,37 @@ func newDecoder(
 		}
 		pr, pw := ioPipe()
 		go func() {
+			dctx, cancel := drainContext(ctx)
+			defer cancel()
+			stop := onDone(dctx, func() {
+				pw.CloseWithError(context.Cause(dctx))
+				closeMeasureReader(r)
+			})
+			defer stop()
 			defer pw.Close()
-			adapter(f, r, pw)
+			s := stageTelemetry(ctx, "decoder")
+			defer s.observe(time.Now())
+			adapter(dctx, f, s.reader(r), pw)
 		}()
 		return pr, nil
 	}, nil
 }
 
 func newGenericDecoder(decw DecoderToWriter) (Decoder, error) {
+	dec, err := newGenericDecoderContext(decw)
+	if err != nil {
+		return nil, err
+	}
+	return func(f Feedback, r MeasureReader) (io.ReadCloser, error) {
+		return dec(context.Background(), f, r)
+	}, nil
+}
+
+func newGenericDecoderContext(decw DecoderToWriter) (DecoderContext, error) {
 	if decw == nil {
 		return nil, errors.New("decoder function is nil")
 	}
-	adapter := func(f Feedback, r MeasureReader, w io.Writer) {
+	adapter := func(ctx context.Context, f Feedback, r MeasureReader, w io.Writer) {
 		for {
 			var measure Measure
 			err := r.Read(&measure)
@@ -58,15 +126,39 @@ func newGenericDecoder(decw DecoderToWriter) (Decoder, error) {
 				if err == io.EOF {
 					break
 				}
-				f("genericDecoder read error- %v", err)
+				if aborted(ctx, err) {
+					break
+				}
+				f.Error(err, "genericDecoder read error")
 				continue
 			}
 			err = decw(measure, w)
 			if err != nil {
-				f("genericDecoder decode error- %v", err)
+				f.Error(err, "genericDecoder decode error", "measure", measure.Name)
 				continue
 			}
 		}
 	}
-	return NewDecoder(adapter)
+	return NewDecoderContext(adapter)
+}
+
+func measureJSONDecoderToWriter(m Measure, w io.Writer) error {
+	return json.NewEncoder(w).Encode(m)
+}
+
+func measureGobDecoderAdapter(f Feedback, r MeasureReader, w io.Writer) {
+	mw := NewWriter(w)
+	for {
+		var m Measure
+		if err := r.Read(&m); err != nil {
+			if err != io.EOF {
+				f("error reading measure %v", err)
+			}
+			return
+		}
+		if err := mw.Write(m); err != nil {
+			f("error encoding gob measure %v", err)
+			return
+		}
+	}
 }
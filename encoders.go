@@ -1,14 +1,20 @@
 package mstreamer
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
+	"strconv"
+	"time"
 )
 
 // EncoderAdapter takes
 type EncoderAdapter func(Feedback, io.Reader, MeasureWriter)
 
+// EncoderAdapterContext is an EncoderAdapter that receives the encoder drain context
+type EncoderAdapterContext func(context.Context, Feedback, io.Reader, MeasureWriter)
+
 // EncodeToWriter das
 type EncodeToWriter func(interface{}, MeasureWriter) error
 
@@ -17,54 +23,155 @@ func NewFromJSONEncoder(data interface{}, encode EncodeToWriter) (Encoder, error
 	return newFromJSONEncoder(data, encode)
 }
 
+// NewFromJSONEncoderContext is the EncoderContext counterpart of NewFromJSONEncoder
+func NewFromJSONEncoderContext(data interface{}, encode EncodeToWriter) (EncoderContext, error) {
+	return newFromJSONEncoderContext(data, encode)
+}
+
+// NewMeasureJSONEncoder returns an Encoder that reads a stream of json encoded measures,
+// as written by NewMeasureJSONDecoder
+func NewMeasureJSONEncoder() (Encoder, error) {
+	return NewEncoder(measureJSONEncoderAdapter)
+}
+
+// NewMeasureGobEncoder returns an Encoder that reads a stream of gob encoded measures,
+// as written by NewMeasureGobDecoder
+func NewMeasureGobEncoder() (Encoder, error) {
+	return NewEncoder(measureGobEncoderAdapter)
+}
+
 // NewEncoder builds an encoder function
 func NewEncoder(adapter EncoderAdapter) (Encoder, error) {
-	return newEncoder(adapter, io.Pipe, NewWriter, NewReader)
+	return newEncoder(adapter, ChanMeasurePipe)
+}
+
+// NewEncoderContext builds an EncoderContext that is aborted once the drain timeout expires
+func NewEncoderContext(adapter EncoderAdapterContext) (EncoderContext, error) {
+	return newEncoderContext(adapter, ChanMeasurePipe)
 }
 
 func newFromJSONEncoder(data interface{}, encode EncodeToWriter) (Encoder, error) {
-	adapter := func(f Feedback, r io.Reader, w MeasureWriter) {
+	return NewEncoder(jsonEncoderAdapter(data, encode))
+}
+
+func newFromJSONEncoderContext(data interface{}, encode EncodeToWriter) (EncoderContext, error) {
+	adapter := jsonEncoderAdapter(data, encode)
+	return NewEncoderContext(func(ctx context.Context, f Feedback, r io.Reader, w MeasureWriter) {
+		adapter(f, r, w)
+	})
+}
+
+func jsonEncoderAdapter(data interface{}, encode EncodeToWriter) EncoderAdapter {
+	return func(f Feedback, r io.Reader, w MeasureWriter) {
 		if err := json.NewDecoder(r).Decode(data); err != nil {
 			f("error decoding json %v", err)
 		}
 		encode(data, w)
 	}
-	return NewEncoder(adapter)
 }
 
 func newEncoder(
 	adapter EncoderAdapter,
-	ioPipe func() (*io.PipeReader, *io.PipeWriter),
-	mWriter func(io.Writer) MeasureWriter,
-	mReader func(io.Reader) MeasureReader,
+	pipe MeasurePipe,
 ) (Encoder, error) {
 	if adapter == nil {
 		return nil, errors.New("adapter function is nil")
 	}
-	if ioPipe == nil {
-		return nil, errors.New("iopipe is nil")
+	enc, err := newEncoderContext(
+		func(ctx context.Context, f Feedback, r io.Reader, w MeasureWriter) {
+			adapter(f, r, w)
+		}, pipe)
+	if err != nil {
+		return nil, err
 	}
-	if mWriter == nil {
-		return nil, errors.New("mWriter is nil")
+	return func(f Feedback, r io.ReadCloser) (MeasureReader, error) {
+		return enc(context.Background(), f, r)
+	}, nil
+}
+
+func newEncoderContext(
+	adapter EncoderAdapterContext,
+	pipe MeasurePipe,
+) (EncoderContext, error) {
+	if adapter == nil {
+		return nil, errors.New("adapter function is nil")
 	}
-	if mReader == nil {
-		return nil, errors.New("mReader is nil")
+	if pipe == nil {
+		return nil, errors.New("pipe is nil")
 	}
-	return func(f Feedback, r io.ReadCloser) (MeasureReader, error) {
+	return func(ctx context.Context, f Feedback, r io.ReadCloser) (MeasureReader, error) {
+		if ctx == nil {
+			return nil, errors.New("context is nil")
+		}
 		if f == nil {
 			return nil, errors.New("feedback funcion is nil")
 		}
 		if r == nil {
 			return nil, errors.New("reader stream is nil")
 		}
-		pr, pw := ioPipe()
-		mr := mReader(pr)
-		mw := mWriter(pw)
+		mr, mw := pipe(ctx)
 		go func() {
+			dctx, cancel := drainContext(ctx)
+			defer cancel()
+			stop := onDone(dctx, func() {
+				mw.CloseWithError(context.Cause(dctx))
+				r.Close()
+			})
+			defer stop()
 			defer r.Close()
-			defer pw.Close()
-			adapter(f, r, mw)
+			defer mw.Close()
+			s := stageTelemetry(ctx, "encoder")
+			defer s.observe(time.Now())
+			adapter(dctx, f, r, s.writer(mw))
 		}()
 		return mr, nil
 	}, nil
 }
+
+func measureJSONEncoderAdapter(f Feedback, r io.Reader, w MeasureWriter) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	for {
+		var m Measure
+		if err := dec.Decode(&m); err != nil {
+			if err != io.EOF {
+				f("error decoding json measure %v", err)
+			}
+			return
+		}
+		for i := range m.Flds {
+			m.Flds[i].Data = normalizeFieldData(m.Flds[i].Type, m.Flds[i].Data)
+		}
+		w.Write(m)
+	}
+}
+
+func measureGobEncoderAdapter(f Feedback, r io.Reader, w MeasureWriter) {
+	mr := NewReader(r)
+	for {
+		var m Measure
+		if err := mr.Read(&m); err != nil {
+			if err != io.EOF {
+				f("error decoding gob measure %v", err)
+			}
+			return
+		}
+		w.Write(m)
+	}
+}
+
+// normalizeFieldData converts a json decoded value to the go type of the field type
+func normalizeFieldData(t FieldType, data interface{}) interface{} {
+	switch v := data.(type) {
+	case json.Number:
+		if t == TNil || t == TString || t == TBool {
+			return ParseValue(TFloat, v.String())
+		}
+		return ParseValue(t, v.String())
+	case float64:
+		return normalizeFieldData(t, json.Number(strconv.FormatFloat(v, 'g', -1, 64)))
+	case int:
+		return normalizeFieldData(t, json.Number(strconv.Itoa(v)))
+	}
+	return data
+}
@@ -0,0 +1,121 @@
+package mstreamer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// execWaitDelay bounds the wait for the output of a killed command, held open by its orphans
+const execWaitDelay = time.Second
+
+// ExecOptions configures an exec source
+type ExecOptions struct {
+	// Command is the program run, looked up in PATH unless it holds a separator
+	Command string
+	Args    []string
+	// Env are KEY=VALUE variables added to the environment of mstreamer
+	Env []string
+	// Dir is the working directory, the one of mstreamer when empty
+	Dir string
+	// Timeout kills the command when it runs for longer, zero lets it run until the context is done
+	Timeout time.Duration
+}
+
+// Validate checks the command and the timeout
+func (o ExecOptions) Validate() error {
+	if o.Command == "" {
+		return errors.New("command is empty")
+	}
+	if o.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// NewExecSource returns a Source running a command on every run and streaming its stdout
+func NewExecSource(opts ExecOptions) (Source, error) {
+	src, err := NewExecSourceContext(opts)
+	if err != nil {
+		return nil, err
+	}
+	return func(f Feedback) (io.ReadCloser, error) {
+		return src(context.Background(), f)
+	}, nil
+}
+
+// NewExecSourceContext returns a SourceContext running a command on every run and streaming its stdout.
+// Every stderr line is a warning through the Feedback, and a failed start or a non-zero exit an error.
+// The command runs in its own process group, killed as a whole once the timeout expires or the context
+// is done, so a scheduled exec source replaces a cron job
+func NewExecSourceContext(opts ExecOptions) (SourceContext, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return NewSourceContext(func(ctx context.Context, f Feedback, w io.Writer) {
+		cctx := ctx
+		if opts.Timeout > 0 {
+			var cancel context.CancelFunc
+			cctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
+		}
+		cmd := exec.CommandContext(cctx, opts.Command, opts.Args...)
+		cmd.Dir = opts.Dir
+		if len(opts.Env) > 0 {
+			cmd.Env = append(os.Environ(), opts.Env...)
+		}
+		stderr := &feedbackLineWriter{f: f, command: opts.Command}
+		cmd.Stdout, cmd.Stderr = w, stderr
+		setProcessGroup(cmd)
+		cmd.Cancel = func() error { return killProcessGroup(cmd) }
+		cmd.WaitDelay = execWaitDelay
+		err := cmd.Run()
+		stderr.flush()
+		var exitErr *exec.ExitError
+		switch {
+		case ctx.Err() != nil:
+			f.Info("command killed as the pipeline is done", "command", opts.Command)
+		case cctx.Err() != nil:
+			f.Error(cctx.Err(), "command timed out", "command", opts.Command, "timeout", opts.Timeout)
+		case errors.As(err, &exitErr):
+			f.Error(err, "command failed", "command", opts.Command, "exit_code", exitErr.ExitCode())
+		case err != nil:
+			f.Error(err, "command failed", "command", opts.Command)
+		}
+	})
+}
+
+// feedbackLineWriter sends every line written to it as a warning
+type feedbackLineWriter struct {
+	f       Feedback
+	command string
+	buf     []byte
+}
+
+func (w *feedbackLineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// flush sends the last line when it does not end with a newline
+func (w *feedbackLineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *feedbackLineWriter) emit(line []byte) {
+	w.f.Warn(string(bytes.TrimSuffix(line, []byte("\r"))), "command", w.command, "stream", "stderr")
+}
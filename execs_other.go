@@ -0,0 +1,13 @@
+//go:build !unix
+
+package mstreamer
+
+import "os/exec"
+
+// setProcessGroup does nothing where process groups are not available
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the process of cmd alone where process groups are not available
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
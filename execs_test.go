@@ -0,0 +1,102 @@
+//go:build unix
+
+package mstreamer
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewExecSourceContext(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       ExecOptions
+		cancel     time.Duration
+		wantOut    string
+		wantEvents []string
+	}{
+		{
+			name:    `when command succeeds then stdout is streamed`,
+			opts:    ExecOptions{Command: "sh", Args: []string{"-c", "echo $GREETING; pwd"}, Env: []string{"GREETING=hello"}, Dir: "/"},
+			wantOut: "hello\n/\n",
+		},
+		{
+			name:       `when command writes to stderr then lines are warnings`,
+			opts:       ExecOptions{Command: "sh", Args: []string{"-c", "echo one >&2; printf two >&2"}},
+			wantEvents: []string{"WARN one command=sh stream=stderr", "WARN two command=sh stream=stderr"},
+		},
+		{
+			name:       `when command exits non-zero then exit code is reported`,
+			opts:       ExecOptions{Command: "sh", Args: []string{"-c", "echo partial; exit 3"}},
+			wantOut:    "partial\n",
+			wantEvents: []string{"ERROR command failed err=exit status 3 command=sh exit_code=3"},
+		},
+		{
+			name:       `when command is missing then start failure is reported`,
+			opts:       ExecOptions{Command: "/nonexistent/command"},
+			wantEvents: []string{"ERROR command failed err=fork/exec /nonexistent/command: no such file or directory command=/nonexistent/command"},
+		},
+		{
+			name:       `when timeout expires then the process group is killed`,
+			opts:       ExecOptions{Command: "sh", Args: []string{"-c", "sleep 30 & sleep 30"}, Timeout: 50 * time.Millisecond},
+			wantEvents: []string{"ERROR command timed out err=context deadline exceeded command=sh timeout=50ms"},
+		},
+		{
+			name:       `when context is canceled then the process group is killed`,
+			opts:       ExecOptions{Command: "sh", Args: []string{"-c", "sleep 30 & sleep 30"}},
+			cancel:     50 * time.Millisecond,
+			wantEvents: []string{"INFO command killed as the pipeline is done command=sh"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := NewExecSourceContext(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel > 0 {
+				time.AfterFunc(tt.cancel, cancel)
+			}
+			var (
+				mu     sync.Mutex
+				events []string
+				done   = make(chan struct{})
+			)
+			f, _ := NewEventFeedback(func(e Event) {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, e.String())
+				if e.Msg == "command killed as the pipeline is done" {
+					close(done)
+				}
+			})
+			start := time.Now()
+			r, err := src(ctx, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, _ := io.ReadAll(r)
+			if tt.cancel > 0 {
+				// the stream is closed on cancellation, before the command is reaped
+				<-done
+			}
+			if d := time.Since(start); d > 10*time.Second {
+				t.Errorf("command ran for %v, its process group was not killed", d)
+			}
+			if string(out) != tt.wantOut {
+				t.Errorf("got stdout %q want %q", out, tt.wantOut)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if strings.Join(events, "\n") != strings.Join(tt.wantEvents, "\n") {
+				t.Errorf("got events %q want %q", events, tt.wantEvents)
+			}
+		})
+	}
+}
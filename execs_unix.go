@@ -0,0 +1,18 @@
+//go:build unix
+
+package mstreamer
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes cmd lead a process group of its own, holding every process it starts
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the process group led by cmd
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
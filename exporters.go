@@ -0,0 +1,382 @@
+package mstreamer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrometheusExporter keeps the latest value of every series, a measure name and its tags, and serves them
+// to Prometheus scrapes in the text exposition format or in OpenMetrics when the scraper asks for it.
+//
+// Measures built by the Prometheus encoders are exposed back as the metric family they were parsed from.
+// Any other measure exposes every numeric or boolean field as an untyped metric named <name>_<field>,
+// or <name> for a field called value. Samples are exposed without timestamp so Prometheus stamps them
+type PrometheusExporter struct {
+	ttl    time.Duration
+	now    func() time.Time
+	mu     sync.Mutex
+	series map[string]*promSeries
+}
+
+type promSeries struct {
+	measure Measure
+	updated time.Time
+}
+
+// NewPrometheusExporter returns a PrometheusExporter dropping the series not refreshed within ttl.
+// A zero ttl keeps series forever
+func NewPrometheusExporter(ttl time.Duration) *PrometheusExporter {
+	return newPrometheusExporter(ttl, time.Now)
+}
+
+func newPrometheusExporter(ttl time.Duration, now func() time.Time) *PrometheusExporter {
+	return &PrometheusExporter{ttl: ttl, now: now, series: make(map[string]*promSeries)}
+}
+
+// Write replaces the latest value of the series of m
+func (e *PrometheusExporter) Write(m Measure) error {
+	if m.Name == "" {
+		return errors.New("measure name is empty")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.series[seriesKey(m.Name, m.Tags)] = &promSeries{measure: m, updated: e.now()}
+	return nil
+}
+
+// Output returns an OutputContext writing every measure it reads to the exporter
+func (e *PrometheusExporter) Output() OutputContext {
+	out, _ := NewOutputContext(func(ctx context.Context, m Measure) error {
+		return e.Write(m)
+	})
+	return out
+}
+
+// ServeHTTP writes the live series in the format negotiated through the Accept header
+func (e *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	var buf bytes.Buffer
+	e.Expose(&buf, openMetrics)
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	w.Write(buf.Bytes())
+}
+
+// Expose drops the expired series and writes the live ones to w
+func (e *PrometheusExporter) Expose(w io.Writer, openMetrics bool) error {
+	x := &promExposition{openMetrics: openMetrics, families: make(map[string]*promExpFamily)}
+	for _, m := range e.live() {
+		x.add(m)
+	}
+	_, err := w.Write(x.bytes())
+	return err
+}
+
+// live drops the expired series and returns the others sorted by series key
+func (e *PrometheusExporter) live() []Measure {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.now()
+	keys := make([]string, 0, len(e.series))
+	for key, s := range e.series {
+		if e.ttl > 0 && now.Sub(s.updated) > e.ttl {
+			delete(e.series, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ms := make([]Measure, len(keys))
+	for i, key := range keys {
+		ms[i] = e.series[key].measure
+	}
+	return ms
+}
+
+// NewPrometheusOutput returns an Output feeding a PrometheusExporter served at path on addr.
+// See NewPrometheusOutputContext
+func NewPrometheusOutput(addr, path string, ttl time.Duration) (Output, error) {
+	out, err := NewPrometheusOutputContext(addr, path, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return func(f Feedback, r MeasureReader) error {
+		return out(context.Background(), f, r)
+	}, nil
+}
+
+// NewPrometheusOutputContext returns an OutputContext feeding a PrometheusExporter served at path on addr.
+// The endpoint starts listening with the first run and keeps serving after the measures end, so scrapes
+// keep working between scheduled runs. It stops once the schedule, or the run when not scheduled, is
+// cancelled, the end of a single scheduled run leaving it serving
+func NewPrometheusOutputContext(addr, path string, ttl time.Duration) (OutputContext, error) {
+	if addr == "" {
+		return nil, errors.New("address is empty")
+	}
+	if path == "" {
+		path = "/metrics"
+	}
+	e := NewPrometheusExporter(ttl)
+	mux := http.NewServeMux()
+	mux.Handle(path, e)
+	var (
+		mu  sync.Mutex
+		srv *http.Server
+	)
+	serve := func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if srv != nil {
+			return nil
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		s := &http.Server{Handler: mux}
+		srv = s
+		go s.Serve(ln)
+		onDone(scheduleContext(ctx), func() {
+			mu.Lock()
+			defer mu.Unlock()
+			s.Close()
+			if srv == s {
+				srv = nil
+			}
+		})
+		return nil
+	}
+	out := e.Output()
+	return func(ctx context.Context, f Feedback, r MeasureReader) error {
+		if ctx == nil {
+			return errors.New("context is nil")
+		}
+		if err := serve(ctx); err != nil {
+			return err
+		}
+		return out(ctx, f, r)
+	}, nil
+}
+
+type promExpSample struct {
+	name   string
+	labels []Tag
+	value  float64
+}
+
+type promExpFamily struct {
+	typ     string
+	unit    string
+	samples []promExpSample
+}
+
+// promExposition groups the samples of every measure by metric family
+type promExposition struct {
+	openMetrics bool
+	families    map[string]*promExpFamily
+}
+
+func (x *promExposition) add(m Measure) {
+	var typ, unit string
+	labels := make([]Tag, 0, len(m.Tags))
+	for _, t := range m.Tags {
+		switch t.Name {
+		case PromTypeTag:
+			typ = t.Data
+		case PromUnitTag:
+			unit = t.Data
+		default:
+			labels = append(labels, MakeTag(promName(t.Name, false), t.Data))
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	name := promName(m.Name, true)
+	for _, f := range m.Flds {
+		v, ok := promValue(f)
+		if !ok || typ != "" && strings.HasSuffix(f.Name, "_exemplar") {
+			continue
+		}
+		switch {
+		case typ == "":
+			family := name
+			if f.Name != "value" {
+				family = promName(m.Name+"_"+f.Name, true)
+			}
+			x.sample(family, "untyped", unit, "", labels, v)
+		case f.Name == "created":
+			x.sample(strings.TrimSuffix(strings.TrimSuffix(name, "_total"), "_info"), typ, unit, "_created", labels, v)
+		case typ == "counter" && f.Name == "counter":
+			x.sample(strings.TrimSuffix(name, "_total"), typ, unit, "_total", labels, v)
+		case typ == "info" && f.Name == "info":
+			x.sample(strings.TrimSuffix(name, "_info"), typ, unit, "_info", labels, v)
+		case typ == "gauge" && f.Name == "gauge",
+			(typ == "untyped" || typ == "unknown") && f.Name == "value",
+			typ == "stateset" && f.Name == "state":
+			x.sample(name, typ, unit, "", labels, v)
+		case (typ == "histogram" || typ == "gaugehistogram") && strings.HasPrefix(f.Name, "bucket_"):
+			x.sample(name, typ, unit, "_bucket", append(labels[:len(labels):len(labels)], MakeTag("le", strings.TrimPrefix(f.Name, "bucket_"))), v)
+		case typ == "summary" && strings.HasPrefix(f.Name, "quantile_"):
+			x.sample(name, typ, unit, "", append(labels[:len(labels):len(labels)], MakeTag("quantile", strings.TrimPrefix(f.Name, "quantile_"))), v)
+		case (typ == "histogram" || typ == "gaugehistogram" || typ == "summary") && (f.Name == "sum" || f.Name == "count"):
+			x.sample(name, typ, unit, "_"+f.Name, labels, v)
+		}
+		// exemplars and fields that have no place in the family are not exposed
+	}
+}
+
+// sample adds a sample of family, adapting the family to what the selected format supports
+func (x *promExposition) sample(family, typ, unit, suffix string, labels []Tag, v float64) {
+	if x.openMetrics {
+		if typ == "untyped" {
+			typ = "unknown"
+		}
+		if typ == "gaugehistogram" && (suffix == "_sum" || suffix == "_count") {
+			suffix = "_g" + strings.TrimPrefix(suffix, "_")
+		}
+	} else {
+		switch typ {
+		case "counter", "info":
+			if suffix == "_created" {
+				return
+			}
+			family, suffix = family+suffix, ""
+			if typ == "info" {
+				typ = "gauge"
+			}
+		case "stateset":
+			typ = "gauge"
+		case "gaugehistogram":
+			typ = "histogram"
+		case "unknown":
+			typ = "untyped"
+		}
+		if suffix == "_created" {
+			return
+		}
+	}
+	fam, ok := x.families[family]
+	if !ok {
+		fam = &promExpFamily{typ: typ, unit: unit}
+		x.families[family] = fam
+	}
+	fam.samples = append(fam.samples, promExpSample{name: family + suffix, labels: labels, value: v})
+}
+
+func (x *promExposition) bytes() []byte {
+	names := make([]string, 0, len(x.families))
+	for name := range x.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf []byte
+	for _, name := range names {
+		fam := x.families[name]
+		buf = append(buf, "# TYPE "...)
+		buf = append(buf, name...)
+		buf = append(buf, ' ')
+		buf = append(buf, fam.typ...)
+		buf = append(buf, '\n')
+		if x.openMetrics && fam.unit != "" {
+			buf = append(buf, "# UNIT "+name+" "+fam.unit+"\n"...)
+		}
+		for _, s := range fam.samples {
+			buf = append(buf, s.name...)
+			if len(s.labels) > 0 {
+				buf = append(buf, '{')
+				for i, l := range s.labels {
+					if i > 0 {
+						buf = append(buf, ',')
+					}
+					buf = append(buf, l.Name...)
+					buf = append(buf, '=')
+					buf = appendPromLabelValue(buf, l.Data)
+				}
+				buf = append(buf, '}')
+			}
+			buf = append(buf, ' ')
+			buf = appendPromFloat(buf, s.value)
+			buf = append(buf, '\n')
+		}
+	}
+	if x.openMetrics {
+		buf = append(buf, "# EOF\n"...)
+	}
+	return buf
+}
+
+func appendPromLabelValue(buf []byte, v string) []byte {
+	buf = append(buf, '"')
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case '\\':
+			buf = append(buf, `\\`...)
+		case '"':
+			buf = append(buf, `\"`...)
+		case '\n':
+			buf = append(buf, `\n`...)
+		default:
+			buf = append(buf, v[i])
+		}
+	}
+	return append(buf, '"')
+}
+
+func appendPromFloat(buf []byte, v float64) []byte {
+	switch {
+	case math.IsInf(v, 1):
+		return append(buf, "+Inf"...)
+	case math.IsInf(v, -1):
+		return append(buf, "-Inf"...)
+	case math.IsNaN(v):
+		return append(buf, "NaN"...)
+	default:
+		return strconv.AppendFloat(buf, v, 'g', -1, 64)
+	}
+}
+
+func promValue(f Field) (float64, bool) {
+	switch v := f.Data.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// promName replaces the characters Prometheus does not accept in metric or label names
+func promName(s string, metric bool) string {
+	b := []byte(s)
+	for i, c := range b {
+		valid := c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' || metric && c == ':'
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
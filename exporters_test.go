@@ -0,0 +1,190 @@
+package mstreamer
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusExporter(t *testing.T) {
+	scrape := `# TYPE http_requests_total counter
+http_requests_total{code="200"} 1027
+# TYPE go_goroutines gauge
+go_goroutines 69
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds_sum 17560473
+rpc_duration_seconds_count 2693
+# TYPE foo histogram
+foo_bucket{le="0.1"} 8
+foo_bucket{le="+Inf"} 17
+foo_sum 324789.3
+foo_count 17
+`
+	enc, err := NewPrometheusEncoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, _ := readPromMeasures(t, enc, scrape)
+	now := time.Unix(100, 0)
+	e := newPrometheusExporter(time.Minute, func() time.Time { return now })
+	for _, m := range ms {
+		e.Write(m)
+	}
+	e.Write(Measure{Name: "disk", Tags: []Tag{{"path", `C:\"x"`}}, Flds: []Field{{"free", TInt, int64(3)}, {"ok", TBool, true}, {"label", TString, "no"}}})
+
+	tests := []struct {
+		name        string
+		openMetrics bool
+		want        string
+	}{
+		{
+			name: `when scraped as text then families keep their original samples`,
+			want: `# TYPE disk_free untyped
+disk_free{path="C:\\\"x\""} 3
+# TYPE disk_ok untyped
+disk_ok{path="C:\\\"x\""} 1
+# TYPE foo histogram
+foo_bucket{le="0.1"} 8
+foo_bucket{le="+Inf"} 17
+foo_sum 324789.3
+foo_count 17
+# TYPE go_goroutines gauge
+go_goroutines 69
+# TYPE http_requests_total counter
+http_requests_total{code="200"} 1027
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+`,
+		},
+		{
+			name: `when scraped as openmetrics then counters are named without their suffix`, openMetrics: true,
+			want: `# TYPE disk_free unknown
+disk_free{path="C:\\\"x\""} 3
+# TYPE disk_ok unknown
+disk_ok{path="C:\\\"x\""} 1
+# TYPE foo histogram
+foo_bucket{le="0.1"} 8
+foo_bucket{le="+Inf"} 17
+foo_sum 324789.3
+foo_count 17
+# TYPE go_goroutines gauge
+go_goroutines 69
+# TYPE http_requests counter
+http_requests_total{code="200"} 1027
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+# EOF
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := e.Expose(&buf, tt.openMetrics); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+			reparse := NewPrometheusEncoder
+			if tt.openMetrics {
+				reparse = NewOpenMetricsEncoder
+			}
+			enc, err := reparse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, errs := readPromMeasures(t, enc, buf.String()); len(errs) != 0 {
+				t.Errorf("exposition does not parse back: %v", errs)
+			}
+		})
+	}
+
+	t.Run(`when series are not refreshed within ttl then they expire`, func(t *testing.T) {
+		now = now.Add(30 * time.Second)
+		e.Write(Measure{Name: "fresh", Flds: []Field{{"value", TFloat, 1.0}}})
+		now = now.Add(45 * time.Second)
+		var buf bytes.Buffer
+		e.Expose(&buf, false)
+		if want := "# TYPE fresh untyped\nfresh 1\n"; buf.String() != want {
+			t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+		}
+	})
+
+	t.Run(`when a series comes with its tags reordered then it is exposed once with sorted labels`, func(t *testing.T) {
+		e.Write(Measure{Name: "fresh", Tags: []Tag{{"zone", "a"}, {"host", "h1"}}, Flds: []Field{{"value", TFloat, 1.0}}})
+		e.Write(Measure{Name: "fresh", Tags: []Tag{{"host", "h1"}, {"zone", "a"}}, Flds: []Field{{"value", TFloat, 2.0}}})
+		var buf bytes.Buffer
+		e.Expose(&buf, false)
+		if want := "# TYPE fresh untyped\nfresh 1\nfresh{host=\"h1\",zone=\"a\"} 2\n"; buf.String() != want {
+			t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+		}
+	})
+
+	t.Run(`when scraper accepts openmetrics then it is served`, func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0,text/plain;q=0.5")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		body, _ := ioutil.ReadAll(rec.Body)
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+			t.Errorf("got content type %v", ct)
+		}
+		if !strings.HasSuffix(string(body), "# EOF\n") {
+			t.Errorf("got body %s", body)
+		}
+	})
+}
+
+func TestPrometheusOutputContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	out, err := NewPrometheusOutputContext(addr, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scrape := func() (string, error) {
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return string(b), err
+	}
+	sctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// cancelling a run must not stop the endpoint of its schedule
+	rctx, rcancel := runContext(sctx, time.Now(), 0)
+	r, w := NewChanMeasurePipe(1)
+	w.Write(Measure{Name: "up", Flds: []Field{{"value", TFloat, 1.0}}, Time: time.Now().UnixNano()})
+	w.Close()
+	out(rctx, func(string, ...interface{}) {}, r)
+	rcancel()
+	if body, err := scrape(); err != nil || !strings.Contains(body, "up 1") {
+		t.Errorf("got %q, %v after the run ended want the series served", body, err)
+	}
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err := scrape(); err == nil && time.Now().Before(deadline); _, err = scrape() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := scrape(); err == nil {
+		t.Errorf("got the endpoint serving once the schedule is cancelled want it stopped")
+	}
+}
@@ -0,0 +1,827 @@
+package mstreamer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is an expression compiled once and evaluated against measures.
+//
+// An expression reads the measure through name, its name as a string, time, its time in nanoseconds as an int,
+// tags.<tag> or tags["<tag>"], a tag value as a string, and <field>, fields.<field> or fields["<field>"],
+// a field value typed as the field. Literals are ints (42), floats (4.2), strings ("text" or 'text') and
+// booleans (true, false). Operators, by increasing precedence, are ||, &&, the comparisons == != < <= > >=
+// and the regular expression matches =~ !~, + -, * / % and the unary ! -. Comparisons follow Field.Compare,
+// except numbers of different types that are compared as floats. / always divides floats, the other
+// arithmetic operators keep ints and uints when both sides share the type and + also joins strings.
+// The functions are has(x), true when the tag or field x exists, and the conversions float(x), int(x)
+// and string(x).
+//
+// Types known at compile time, those of literals, name, time, tags and functions, are checked by
+// CompileExpression. Field types are checked on evaluation
+type Expression struct {
+	src  string
+	root *exprNode
+}
+
+// CompileExpression parses and type checks src
+func CompileExpression(src string) (*Expression, error) {
+	p := &exprParser{src: src}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at column %v", t.text, t.pos+1)
+	}
+	return &Expression{src: src, root: n}, nil
+}
+
+// Eval evaluates the expression against m
+func (e *Expression) Eval(m *Measure) (Field, error) {
+	return e.root.eval(m)
+}
+
+// Type returns the type of the expression result, or zero when it depends on the type of a field
+func (e *Expression) Type() FieldType {
+	return e.root.typ
+}
+
+func (e *Expression) String() string {
+	return e.src
+}
+
+// predicate compiles src and checks it evaluates to a bool
+func predicate(src string) (*Expression, error) {
+	e, err := CompileExpression(src)
+	if err != nil {
+		return nil, err
+	}
+	if e.Type() != 0 && e.Type() != TBool {
+		return nil, fmt.Errorf("expression %q is a %v, not a bool", src, typeName(e.Type()))
+	}
+	return e, nil
+}
+
+// matches evaluates a predicate, a failed evaluation does not match
+func (e *Expression) matches(m *Measure) (bool, error) {
+	v, err := e.Eval(m)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.Data.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q is a %v, not a bool", e.src, typeName(v.Type))
+	}
+	return b, nil
+}
+
+// NewKeepFilter returns a Filter passing on the measures matching expr and dropping the others.
+// A measure the expression fails on, for a missing field or a type mismatch, does not match.
+// See Expression for the syntax
+func NewKeepFilter(expr string) (Filter, error) {
+	return adaptedFilter(keepAdapter(expr))
+}
+
+func keepAdapter(expr string) (FilterAdapter, FinalizeAdapter, error) {
+	e, err := predicate(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+		ok, err := e.matches(m)
+		if err != nil {
+			f.Debug("keep expression failed", "measure", m.Name, "expr", expr, "err", err)
+		}
+		if ok {
+			mw.Write(*m)
+		}
+	}, nil, nil
+}
+
+// NewDropFilter returns a Filter dropping the measures matching expr and passing on the others.
+// A measure the expression fails on does not match, so it is passed on
+func NewDropFilter(expr string) (Filter, error) {
+	return adaptedFilter(dropAdapter(expr))
+}
+
+func dropAdapter(expr string) (FilterAdapter, FinalizeAdapter, error) {
+	e, err := predicate(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+		ok, err := e.matches(m)
+		if err != nil {
+			f.Debug("drop expression failed", "measure", m.Name, "expr", expr, "err", err)
+		}
+		if !ok {
+			mw.Write(*m)
+		}
+	}, nil, nil
+}
+
+// NewComputeFilter returns a Filter setting fields from assignments such as mem_pct = used / total * 100.
+// Assignments run in order, so one can use the fields set by the previous ones. A field whose expression
+// fails is left as it is
+func NewComputeFilter(assignments ...string) (Filter, error) {
+	return adaptedFilter(computeAdapter(assignments...))
+}
+
+func computeAdapter(assignments ...string) (FilterAdapter, FinalizeAdapter, error) {
+	if len(assignments) == 0 {
+		return nil, nil, errors.New("compute filter needs at least one assignment")
+	}
+	type assignment struct {
+		field string
+		expr  *Expression
+	}
+	var as []assignment
+	for _, src := range assignments {
+		field, expr, err := parseAssignment(src)
+		if err != nil {
+			return nil, nil, err
+		}
+		as = append(as, assignment{field: field, expr: expr})
+	}
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+		for _, a := range as {
+			v, err := a.expr.Eval(m)
+			if err != nil {
+				f.Debug("compute expression failed", "measure", m.Name, "field", a.field, "expr", a.expr.src, "err", err)
+				continue
+			}
+			setField(m, Field{Name: a.field, Type: v.Type, Data: v.Data})
+		}
+		mw.Write(*m)
+	}, nil, nil
+}
+
+// parseAssignment splits field = expr and compiles expr
+func parseAssignment(src string) (string, *Expression, error) {
+	i := strings.IndexByte(src, '=')
+	if i <= 0 || strings.ContainsAny(src[i-1:i], "!<>") || i+1 < len(src) && strings.ContainsAny(src[i+1:i+2], "=~") {
+		return "", nil, fmt.Errorf("assignment %q is not <field> = <expression>", src)
+	}
+	field := strings.TrimSpace(src[:i])
+	if field == "" || strings.IndexFunc(field, unicode.IsSpace) >= 0 {
+		return "", nil, fmt.Errorf("assignment %q has an invalid field name", src)
+	}
+	e, err := CompileExpression(src[i+1:])
+	if err != nil {
+		return "", nil, fmt.Errorf("assignment of %v: %w", field, err)
+	}
+	return field, e, nil
+}
+
+// setField replaces the field of the same name or appends fld
+func setField(m *Measure, fld Field) {
+	for i := range m.Flds {
+		if m.Flds[i].Name == fld.Name {
+			m.Flds[i] = fld
+			return
+		}
+	}
+	m.Flds = append(m.Flds, fld)
+}
+
+// exprNode is a node of a compiled expression. typ is zero when it is only known on evaluation
+type exprNode struct {
+	typ  FieldType
+	eval func(m *Measure) (Field, error)
+	// ref is set on tag and field references, for has
+	ref func(m *Measure) bool
+}
+
+func typeName(t FieldType) string {
+	switch t {
+	case TBool:
+		return "bool"
+	case TInt:
+		return "int"
+	case TUint:
+		return "uint"
+	case TFloat:
+		return "float"
+	case TString:
+		return "string"
+	case TNil:
+		return "nil"
+	default:
+		return "dynamic value"
+	}
+}
+
+func isNumeric(t FieldType) bool {
+	return t == TInt || t == TUint || t == TFloat
+}
+
+func toFloat(v Field) float64 {
+	switch d := v.Data.(type) {
+	case int64:
+		return float64(d)
+	case uint64:
+		return float64(d)
+	case float64:
+		return d
+	}
+	return math.NaN()
+}
+
+// valueOf types a field after its data, so the value is safe to compare whatever type it declares
+func valueOf(f Field) Field {
+	return Field{Type: FieldValueType(f.Data), Data: f.Data}
+}
+
+func compareValues(a, b Field) (int, error) {
+	if a.Type != b.Type && isNumeric(a.Type) && isNumeric(b.Type) {
+		a = Field{Type: TFloat, Data: toFloat(a)}
+		b = Field{Type: TFloat, Data: toFloat(b)}
+	}
+	if a.Type != b.Type {
+		return 0, fmt.Errorf("cannot compare %v with %v", typeName(a.Type), typeName(b.Type))
+	}
+	return a.Compare(b)
+}
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	src    string
+	tokens []exprToken
+	next   int
+}
+
+var exprOps = []string{"||", "&&", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "+", "-", "*", "/", "%", "!", "(", ")", "[", "]", ".", ","}
+
+func (p *exprParser) tokenize() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] >= 'a' && s[j] <= 'z' || s[j] >= 'A' && s[j] <= 'Z' || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+			p.tokens = append(p.tokens, exprToken{tokIdent, s[i:j], i})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.' || s[j] == 'e' || s[j] == 'E' ||
+				(s[j] == '+' || s[j] == '-') && (s[j-1] == 'e' || s[j-1] == 'E')) {
+				j++
+			}
+			p.tokens = append(p.tokens, exprToken{tokNumber, s[i:j], i})
+			i = j
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+					switch s[j] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(s[j])
+					}
+					continue
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return fmt.Errorf("unterminated string at column %v", i+1)
+			}
+			p.tokens = append(p.tokens, exprToken{tokString, b.String(), i})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range exprOps {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("unexpected %q at column %v", c, i+1)
+			}
+			p.tokens = append(p.tokens, exprToken{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	p.tokens = append(p.tokens, exprToken{tokEOF, "end of expression", len(s)})
+	return nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.next]
+}
+
+func (p *exprParser) take() exprToken {
+	t := p.tokens[p.next]
+	if t.kind != tokEOF {
+		p.next++
+	}
+	return t
+}
+
+// accept takes the next token if it is one of ops
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.next++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		t := p.peek()
+		return fmt.Errorf("expected %q at column %v, got %q", op, t.pos+1, t.text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (*exprNode, error) {
+	return p.parseLogical("||", p.parseAnd)
+}
+
+func (p *exprParser) parseAnd() (*exprNode, error) {
+	return p.parseLogical("&&", p.parseComparison)
+}
+
+func (p *exprParser) parseLogical(op string, operand func() (*exprNode, error)) (*exprNode, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept(op); !ok {
+			return l, nil
+		}
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range []*exprNode{l, r} {
+			if n.typ != 0 && n.typ != TBool {
+				return nil, fmt.Errorf("%v needs bools, got a %v", op, typeName(n.typ))
+			}
+		}
+		l = logicalNode(op, l, r)
+	}
+}
+
+func logicalNode(op string, l, r *exprNode) *exprNode {
+	or := op == "||"
+	side := func(n *exprNode, m *Measure) (bool, error) {
+		v, err := n.eval(m)
+		if err != nil {
+			return false, err
+		}
+		b, ok := v.Data.(bool)
+		if !ok {
+			return false, fmt.Errorf("%v needs bools, got a %v", op, typeName(v.Type))
+		}
+		return b, nil
+	}
+	return &exprNode{typ: TBool, eval: func(m *Measure) (Field, error) {
+		b, err := side(l, m)
+		if err != nil {
+			return Field{}, err
+		}
+		if b != or {
+			if b, err = side(r, m); err != nil {
+				return Field{}, err
+			}
+		}
+		return Field{Type: TBool, Data: b}, nil
+	}}
+}
+
+func (p *exprParser) parseComparison() (*exprNode, error) {
+	l, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">", "=~", "!~")
+	if !ok {
+		return l, nil
+	}
+	if op == "=~" || op == "!~" {
+		t := p.take()
+		if t.kind != tokString {
+			return nil, fmt.Errorf("%v needs a regular expression string at column %v", op, t.pos+1)
+		}
+		re, err := regexp.Compile(t.text)
+		if err != nil {
+			return nil, err
+		}
+		if l.typ != 0 && l.typ != TString {
+			return nil, fmt.Errorf("%v needs a string, got a %v", op, typeName(l.typ))
+		}
+		want := op == "=~"
+		return &exprNode{typ: TBool, eval: func(m *Measure) (Field, error) {
+			v, err := l.eval(m)
+			if err != nil {
+				return Field{}, err
+			}
+			s, ok := v.Data.(string)
+			if !ok {
+				return Field{}, fmt.Errorf("%v needs a string, got a %v", op, typeName(v.Type))
+			}
+			return Field{Type: TBool, Data: re.MatchString(s) == want}, nil
+		}}, nil
+	}
+	r, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if l.typ != 0 && r.typ != 0 && l.typ != r.typ && !(isNumeric(l.typ) && isNumeric(r.typ)) {
+		return nil, fmt.Errorf("cannot compare %v with %v", typeName(l.typ), typeName(r.typ))
+	}
+	test := map[string]func(int) bool{
+		"==": func(c int) bool { return c == 0 },
+		"!=": func(c int) bool { return c != 0 },
+		"<":  func(c int) bool { return c < 0 },
+		"<=": func(c int) bool { return c <= 0 },
+		">":  func(c int) bool { return c > 0 },
+		">=": func(c int) bool { return c >= 0 },
+	}[op]
+	return &exprNode{typ: TBool, eval: func(m *Measure) (Field, error) {
+		a, err := l.eval(m)
+		if err != nil {
+			return Field{}, err
+		}
+		b, err := r.eval(m)
+		if err != nil {
+			return Field{}, err
+		}
+		c, err := compareValues(a, b)
+		if err != nil {
+			return Field{}, err
+		}
+		return Field{Type: TBool, Data: test(c)}, nil
+	}}, nil
+}
+
+func (p *exprParser) parseAdditive() (*exprNode, error) {
+	return p.parseArithmetic(p.parseMultiplicative, "+", "-")
+}
+
+func (p *exprParser) parseMultiplicative() (*exprNode, error) {
+	return p.parseArithmetic(p.parseUnary, "*", "/", "%")
+}
+
+func (p *exprParser) parseArithmetic(operand func() (*exprNode, error), ops ...string) (*exprNode, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return l, nil
+		}
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		typ, err := arithmeticType(op, l.typ, r.typ)
+		if err != nil {
+			return nil, err
+		}
+		l = arithmeticNode(op, typ, l, r)
+	}
+}
+
+// arithmeticType returns the result type of op, zero when it depends on a field type
+func arithmeticType(op string, l, r FieldType) (FieldType, error) {
+	for _, t := range []FieldType{l, r} {
+		if t != 0 && !isNumeric(t) && !(op == "+" && t == TString) {
+			return 0, fmt.Errorf("%v does not apply to a %v", op, typeName(t))
+		}
+	}
+	switch {
+	case l != 0 && r != 0 && (l == TString) != (r == TString):
+		return 0, fmt.Errorf("cannot apply %v to a %v and a %v", op, typeName(l), typeName(r))
+	case op == "/" && l != TString && r != TString:
+		return TFloat, nil
+	case l == 0 || r == 0:
+		return 0, nil
+	case l == r:
+		return l, nil
+	default:
+		return TFloat, nil
+	}
+}
+
+func arithmeticNode(op string, typ FieldType, l, r *exprNode) *exprNode {
+	return &exprNode{typ: typ, eval: func(m *Measure) (Field, error) {
+		a, err := l.eval(m)
+		if err != nil {
+			return Field{}, err
+		}
+		b, err := r.eval(m)
+		if err != nil {
+			return Field{}, err
+		}
+		t, err := arithmeticType(op, a.Type, b.Type)
+		if err != nil {
+			return Field{}, err
+		}
+		switch t {
+		case TString:
+			return Field{Type: TString, Data: a.Data.(string) + b.Data.(string)}, nil
+		case TInt:
+			x, y := a.Data.(int64), b.Data.(int64)
+			switch op {
+			case "+":
+				return Field{Type: TInt, Data: x + y}, nil
+			case "-":
+				return Field{Type: TInt, Data: x - y}, nil
+			case "*":
+				return Field{Type: TInt, Data: x * y}, nil
+			default:
+				if y == 0 {
+					return Field{}, errors.New("modulo by zero")
+				}
+				return Field{Type: TInt, Data: x % y}, nil
+			}
+		case TUint:
+			x, y := a.Data.(uint64), b.Data.(uint64)
+			switch op {
+			case "+":
+				return Field{Type: TUint, Data: x + y}, nil
+			case "-":
+				return Field{Type: TUint, Data: x - y}, nil
+			case "*":
+				return Field{Type: TUint, Data: x * y}, nil
+			default:
+				if y == 0 {
+					return Field{}, errors.New("modulo by zero")
+				}
+				return Field{Type: TUint, Data: x % y}, nil
+			}
+		default:
+			x, y := toFloat(a), toFloat(b)
+			switch op {
+			case "+":
+				return Field{Type: TFloat, Data: x + y}, nil
+			case "-":
+				return Field{Type: TFloat, Data: x - y}, nil
+			case "*":
+				return Field{Type: TFloat, Data: x * y}, nil
+			case "/":
+				return Field{Type: TFloat, Data: x / y}, nil
+			default:
+				return Field{Type: TFloat, Data: math.Mod(x, y)}, nil
+			}
+		}
+	}}
+}
+
+func (p *exprParser) parseUnary() (*exprNode, error) {
+	op, ok := p.accept("!", "-")
+	if !ok {
+		return p.parsePrimary()
+	}
+	n, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if op == "!" {
+		if n.typ != 0 && n.typ != TBool {
+			return nil, fmt.Errorf("! needs a bool, got a %v", typeName(n.typ))
+		}
+		return &exprNode{typ: TBool, eval: func(m *Measure) (Field, error) {
+			v, err := n.eval(m)
+			if err != nil {
+				return Field{}, err
+			}
+			b, ok := v.Data.(bool)
+			if !ok {
+				return Field{}, fmt.Errorf("! needs a bool, got a %v", typeName(v.Type))
+			}
+			return Field{Type: TBool, Data: !b}, nil
+		}}, nil
+	}
+	if n.typ != 0 && n.typ != TInt && n.typ != TFloat {
+		return nil, fmt.Errorf("- does not apply to a %v", typeName(n.typ))
+	}
+	return &exprNode{typ: n.typ, eval: func(m *Measure) (Field, error) {
+		v, err := n.eval(m)
+		if err != nil {
+			return Field{}, err
+		}
+		switch d := v.Data.(type) {
+		case int64:
+			return Field{Type: TInt, Data: -d}, nil
+		case float64:
+			return Field{Type: TFloat, Data: -d}, nil
+		}
+		return Field{}, fmt.Errorf("- does not apply to a %v", typeName(v.Type))
+	}}, nil
+}
+
+func (p *exprParser) parsePrimary() (*exprNode, error) {
+	t := p.take()
+	switch t.kind {
+	case tokNumber:
+		if !strings.ContainsAny(t.text, ".eE") {
+			if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+				return literal(Field{Type: TInt, Data: i}), nil
+			}
+		}
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at column %v", t.text, t.pos+1)
+		}
+		return literal(Field{Type: TFloat, Data: v}), nil
+	case tokString:
+		return literal(Field{Type: TString, Data: t.text}), nil
+	case tokOp:
+		if t.text == "(" {
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	case tokIdent:
+		return p.parseIdent(t)
+	}
+	return nil, fmt.Errorf("unexpected %q at column %v", t.text, t.pos+1)
+}
+
+func literal(v Field) *exprNode {
+	return &exprNode{typ: v.Type, eval: func(*Measure) (Field, error) { return v, nil }}
+}
+
+func (p *exprParser) parseIdent(t exprToken) (*exprNode, error) {
+	switch t.text {
+	case "true", "false":
+		return literal(Field{Type: TBool, Data: t.text == "true"}), nil
+	case "name":
+		return &exprNode{typ: TString, eval: func(m *Measure) (Field, error) {
+			return Field{Type: TString, Data: m.Name}, nil
+		}}, nil
+	case "time":
+		return &exprNode{typ: TInt, eval: func(m *Measure) (Field, error) {
+			return Field{Type: TInt, Data: m.Time}, nil
+		}}, nil
+	case "tags", "fields":
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "tags" {
+			return tagNode(key), nil
+		}
+		return fieldNode(key), nil
+	case "has", "float", "int", "string":
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(t.text)
+		}
+	}
+	return fieldNode(t.text), nil
+}
+
+// parseKey parses .<name> or ["<name>"]
+func (p *exprParser) parseKey() (string, error) {
+	if _, ok := p.accept("."); ok {
+		t := p.take()
+		if t.kind != tokIdent {
+			return "", fmt.Errorf("expected a name at column %v, got %q", t.pos+1, t.text)
+		}
+		return t.text, nil
+	}
+	if _, ok := p.accept("["); ok {
+		t := p.take()
+		if t.kind != tokString {
+			return "", fmt.Errorf("expected a quoted name at column %v, got %q", t.pos+1, t.text)
+		}
+		return t.text, p.expect("]")
+	}
+	t := p.peek()
+	return "", fmt.Errorf("expected . or [ at column %v, got %q", t.pos+1, t.text)
+}
+
+func tagNode(key string) *exprNode {
+	return &exprNode{
+		typ: TString,
+		eval: func(m *Measure) (Field, error) {
+			for _, t := range m.Tags {
+				if t.Name == key {
+					return Field{Type: TString, Data: t.Data}, nil
+				}
+			}
+			return Field{}, fmt.Errorf("tag %v not found", key)
+		},
+		ref: func(m *Measure) bool {
+			_, err := m.Tag(key)
+			return err == nil
+		},
+	}
+}
+
+func fieldNode(key string) *exprNode {
+	return &exprNode{
+		eval: func(m *Measure) (Field, error) {
+			for _, f := range m.Flds {
+				if f.Name == key {
+					return valueOf(f), nil
+				}
+			}
+			return Field{}, fmt.Errorf("field %v not found", key)
+		},
+		ref: func(m *Measure) bool {
+			_, err := m.Field(key)
+			return err == nil
+		},
+	}
+}
+
+func (p *exprParser) parseCall(fn string) (*exprNode, error) {
+	arg, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if fn == "has" {
+		if arg.ref == nil {
+			return nil, errors.New("has needs a tag or a field")
+		}
+		return &exprNode{typ: TBool, eval: func(m *Measure) (Field, error) {
+			return Field{Type: TBool, Data: arg.ref(m)}, nil
+		}}, nil
+	}
+	typ := map[string]FieldType{"float": TFloat, "int": TInt, "string": TString}[fn]
+	if typ != TString && arg.typ == TBool {
+		return nil, fmt.Errorf("%v does not apply to a bool", fn)
+	}
+	return &exprNode{typ: typ, eval: func(m *Measure) (Field, error) {
+		v, err := arg.eval(m)
+		if err != nil {
+			return Field{}, err
+		}
+		return convert(v, typ)
+	}}, nil
+}
+
+// convert implements the float, int and string functions
+func convert(v Field, typ FieldType) (Field, error) {
+	if typ == TString {
+		return Field{Type: TString, Data: fmt.Sprint(v.Data)}, nil
+	}
+	f := toFloat(v)
+	if s, ok := v.Data.(string); ok {
+		var err error
+		if f, err = strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+			return Field{}, fmt.Errorf("cannot convert %q to a number", s)
+		}
+	}
+	if math.IsNaN(f) && v.Type != TFloat {
+		return Field{}, fmt.Errorf("cannot convert a %v to a number", typeName(v.Type))
+	}
+	if typ == TInt {
+		if i, ok := v.Data.(int64); ok {
+			return Field{Type: TInt, Data: i}, nil
+		}
+		return Field{Type: TInt, Data: int64(f)}, nil
+	}
+	return Field{Type: TFloat, Data: f}, nil
+}
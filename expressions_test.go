@@ -0,0 +1,127 @@
+package mstreamer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileExpression(t *testing.T) {
+	m := &Measure{
+		Name: "mem",
+		Tags: []Tag{{"env", "dev"}, {"host-name", "a1"}},
+		Flds: []Field{
+			{"used", TInt, int64(512)},
+			{"total", TInt, int64(2048)},
+			{"cpu", TFloat, 4.5},
+			{"count", TUint, uint64(3)},
+			{"up", TBool, true},
+			{"name", TString, "field"},
+		},
+		Time: 1000,
+	}
+	tests := []struct {
+		expr    string
+		want    Field
+		wantErr string
+	}{
+		{expr: `tags.env == "dev" && cpu < 5`, want: Field{Type: TBool, Data: true}},
+		{expr: `used / total * 100`, want: Field{Type: TFloat, Data: 25.0}},
+		{expr: `used + 1`, want: Field{Type: TInt, Data: int64(513)}},
+		{expr: `count * 2 > 5`, want: Field{Type: TBool, Data: true}},
+		{expr: `name + "." + fields.name`, want: Field{Type: TString, Data: "mem.field"}},
+		{expr: `tags["host-name"] =~ '^a[0-9]$'`, want: Field{Type: TBool, Data: true}},
+		{expr: `!up || time >= 1000`, want: Field{Type: TBool, Data: true}},
+		{expr: `-(used % 5)`, want: Field{Type: TInt, Data: int64(-2)}},
+		{expr: `has(tags.region) || has(fields["cpu"])`, want: Field{Type: TBool, Data: true}},
+		{expr: `int(cpu) + int("2")`, want: Field{Type: TInt, Data: int64(6)}},
+		{expr: `string(used)`, want: Field{Type: TString, Data: "512"}},
+		{expr: `missing > 1`, wantErr: "field missing not found"},
+		{expr: `tags.env > 1`, wantErr: "cannot compare string with int"},
+		{expr: `up + 1`, wantErr: "+ does not apply to a bool"},
+		{expr: `used && up`, wantErr: "&& needs bools, got a int"},
+		{expr: `name * 2`, wantErr: "* does not apply to a string"},
+		{expr: `cpu < `, wantErr: `unexpected "end of expression"`},
+		{expr: `(cpu`, wantErr: `expected ")"`},
+		{expr: `name =~ "("`, wantErr: "missing closing )"},
+		{expr: `has(1)`, wantErr: "has needs a tag or a field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := CompileExpression(tt.expr)
+			var got Field
+			if err == nil {
+				got, err = e.Eval(m)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpressionFilters(t *testing.T) {
+	in := []Measure{
+		{Name: "mem", Tags: []Tag{{"env", "dev"}}, Flds: []Field{{"used", TInt, int64(1)}, {"total", TInt, int64(4)}}},
+		{Name: "mem", Tags: []Tag{{"env", "prod"}}, Flds: []Field{{"used", TInt, int64(3)}, {"total", TInt, int64(4)}}},
+		{Name: "mem", Tags: []Tag{{"env", "prod"}}},
+	}
+	keep, _ := NewKeepFilter(`tags.env == "prod" && used > 1`)
+	drop, _ := NewDropFilter(`tags.env == "dev"`)
+	compute, _ := NewComputeFilter(`mem_pct = used / total * 100`, `mem_pct = mem_pct + 1`)
+	tests := []struct {
+		name string
+		flt  Filter
+		want []Measure
+	}{
+		{name: `when predicate matches then keep passes the measure on`, flt: keep, want: in[1:2]},
+		{name: `when predicate fails then drop passes the measure on`, flt: drop, want: in[1:]},
+		{name: `when assignments chain then compute sets the last value`, flt: compute, want: []Measure{
+			{Name: "mem", Tags: in[0].Tags, Flds: append(in[0].Flds[:2:2], Field{"mem_pct", TFloat, 26.0})},
+			{Name: "mem", Tags: in[1].Tags, Flds: append(in[1].Flds[:2:2], Field{"mem_pct", TFloat, 76.0})},
+			in[2],
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, w := NewChanMeasurePipe(len(in))
+			for _, m := range in {
+				w.Write(m)
+			}
+			w.Close()
+			fr, err := tt.flt(func(string, ...interface{}) {}, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []Measure
+			for {
+				var m Measure
+				if err := fr.Read(&m); err != nil {
+					break
+				}
+				got = append(got, m)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewKeepFilterTypeError(t *testing.T) {
+	if _, err := NewKeepFilter(`name + "_total"`); err == nil || !strings.Contains(err.Error(), "not a bool") {
+		t.Errorf("got error %v want a not a bool error", err)
+	}
+	if _, err := NewComputeFilter(`x == 1`); err == nil {
+		t.Errorf("got no error for a comparison given as assignment")
+	}
+}
@@ -0,0 +1,166 @@
+package mstreamer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Level is the severity of a feedback event. Its values match the log/slog levels
+type Level int
+
+const (
+	//LevelDebug is for details only worth reading while troubleshooting
+	LevelDebug Level = -4
+	//LevelInfo is for counts, progress and state changes
+	LevelInfo Level = 0
+	//LevelWarn is for measures lost or delayed while the pipeline keeps going
+	LevelWarn Level = 4
+	//LevelError is for failures of a component
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// Attr is a key/value attribute of a feedback event
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Event is a structured feedback. Component is the registered name of the component that sent it
+// and Path its place in the pipeline configuration, such as inputs[0].source
+type Event struct {
+	Time      time.Time
+	Level     Level
+	Component string
+	Path      string
+	Msg       string
+	Err       error
+	Attrs     []Attr
+}
+
+// String formats the event as a single line, which is what a printf style Feedback receives from Emit
+func (e Event) String() string {
+	var b strings.Builder
+	b.WriteString(e.Level.String())
+	if id := strings.TrimSpace(e.Path + " " + e.Component); id != "" {
+		b.WriteString(" [" + id + "]")
+	}
+	b.WriteString(" " + e.Msg)
+	if e.Err != nil && !strings.Contains(e.Msg, e.Err.Error()) {
+		fmt.Fprintf(&b, " err=%v", e.Err)
+	}
+	for _, a := range e.Attrs {
+		fmt.Fprintf(&b, " %v=%v", a.Key, a.Value)
+	}
+	return b.String()
+}
+
+// EventHandler receives the structured feedback of the components
+type EventHandler func(Event)
+
+// NewEventFeedback returns a Feedback that hands every event to h. Events sent with Emit or the leveled
+// methods arrive as they are. Printf style messages become info events, or error events carrying the
+// first error argument
+func NewEventFeedback(h EventHandler) (Feedback, error) {
+	if h == nil {
+		return nil, errors.New("event handler is nil")
+	}
+	return func(format string, a ...interface{}) {
+		h(eventOf(format, a))
+	}, nil
+}
+
+// WithComponent returns a Feedback stamping the events that do not carry a component yet with name and path
+func WithComponent(f Feedback, name, path string) Feedback {
+	if f == nil {
+		return nil
+	}
+	return func(format string, a ...interface{}) {
+		e := eventOf(format, a)
+		if e.Component == "" && e.Path == "" {
+			e.Component, e.Path = name, path
+		}
+		f.Emit(e)
+	}
+}
+
+// Emit sends e through f. A Feedback built by NewEventFeedback gets the event itself, any other one
+// gets it formatted by Event.String
+func (f Feedback) Emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	f(eventFormat, e)
+}
+
+// Debug emits a debug event with key/value pairs as attributes
+func (f Feedback) Debug(msg string, kv ...interface{}) {
+	f.Emit(Event{Level: LevelDebug, Msg: msg, Attrs: attrs(kv)})
+}
+
+// Info emits an info event with key/value pairs as attributes
+func (f Feedback) Info(msg string, kv ...interface{}) {
+	f.Emit(Event{Level: LevelInfo, Msg: msg, Attrs: attrs(kv)})
+}
+
+// Warn emits a warning event with key/value pairs as attributes
+func (f Feedback) Warn(msg string, kv ...interface{}) {
+	f.Emit(Event{Level: LevelWarn, Msg: msg, Attrs: attrs(kv)})
+}
+
+// Error emits an error event carrying err, with key/value pairs as attributes
+func (f Feedback) Error(err error, msg string, kv ...interface{}) {
+	f.Emit(Event{Level: LevelError, Msg: msg, Err: err, Attrs: attrs(kv)})
+}
+
+// eventFormat is the format Emit passes along the event
+const eventFormat = "%v"
+
+// eventOf turns the arguments of a Feedback call back into an event
+func eventOf(format string, a []interface{}) Event {
+	if format == eventFormat && len(a) == 1 {
+		if e, ok := a[0].(Event); ok {
+			return e
+		}
+	}
+	e := Event{Time: time.Now(), Level: LevelInfo, Msg: fmt.Sprintf(format, a...)}
+	for _, v := range a {
+		if err, ok := v.(error); ok {
+			e.Level, e.Err = LevelError, err
+			break
+		}
+	}
+	return e
+}
+
+// attrs pairs keys and values the way log/slog does, a key without value is kept under !BADKEY
+func attrs(kv []interface{}) []Attr {
+	if len(kv) == 0 {
+		return nil
+	}
+	as := make([]Attr, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok || i+1 == len(kv) {
+			as = append(as, Attr{Key: "!BADKEY", Value: kv[i]})
+			i--
+			continue
+		}
+		as = append(as, Attr{Key: key, Value: kv[i+1]})
+	}
+	return as
+}
@@ -0,0 +1,73 @@
+package mstreamer
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNewEventFeedback(t *testing.T) {
+	errBoom := errors.New("boom")
+	tests := []struct {
+		name string
+		send func(Feedback)
+		want Event
+	}{
+		{
+			name: `when message is printf style then it is an info event`,
+			send: func(f Feedback) { f("Total %v records: %v", "cpu", 3) },
+			want: Event{Level: LevelInfo, Msg: "Total cpu records: 3"},
+		},
+		{
+			name: `when printf arguments hold an error then it is an error event`,
+			send: func(f Feedback) { f("sink fail %v", errBoom) },
+			want: Event{Level: LevelError, Msg: "sink fail boom", Err: errBoom},
+		},
+		{
+			name: `when leveled method is used then attributes are paired`,
+			send: func(f Feedback) { f.Warn("queue full", "output", 1, "dropped") },
+			want: Event{Level: LevelWarn, Msg: "queue full", Attrs: []Attr{{"output", 1}, {"!BADKEY", "dropped"}}},
+		},
+		{
+			name: `when feedback is stamped then events carry the component`,
+			send: func(f Feedback) { WithComponent(f, "json", "inputs[0].encoder").Error(errBoom, "decode error") },
+			want: Event{Level: LevelError, Component: "json", Path: "inputs[0].encoder", Msg: "decode error", Err: errBoom},
+		},
+		{
+			name: `when feedback is stamped twice then the inner component wins`,
+			send: func(f Feedback) { WithComponent(WithComponent(f, "outer", "a"), "inner", "b")("hello") },
+			want: Event{Level: LevelInfo, Component: "inner", Path: "b", Msg: "hello"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Event
+			f, err := NewEventFeedback(func(e Event) { got = append(got, e) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.send(f)
+			if len(got) != 1 {
+				t.Fatalf("got %v events want 1", len(got))
+			}
+			if got[0].Time.IsZero() {
+				t.Errorf("event time is not set")
+			}
+			got[0].Time = tt.want.Time
+			if !reflect.DeepEqual(got[0], tt.want) {
+				t.Errorf("got %+v want %+v", got[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestFeedbackEmitOnPrintf(t *testing.T) {
+	var got string
+	f := Feedback(func(format string, a ...interface{}) { got = fmt.Sprintf(format, a...) })
+	WithComponent(f, "http", "outputs[0].sinker").Error(errors.New("refused"), "post failed", "attempt", 2)
+	want := "ERROR [outputs[0].sinker http] post failed err=refused attempt=2"
+	if got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
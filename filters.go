@@ -1,6 +1,7 @@
 package mstreamer
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"log"
 	"sort"
 	"strings"
+	"time"
 )
 
 // FilterAdapter takes a Feedback, a Measure pointer and a MeasureWriter and
@@ -16,13 +18,22 @@ import (
 // Use Feedback funtion to inform about any errors or debug information
 type FilterAdapter func(Feedback, *Measure, MeasureWriter)
 
+// FilterAdapterContext is a FilterAdapter that receives the filter drain context
+type FilterAdapterContext func(context.Context, Feedback, *Measure, MeasureWriter)
+
 // FinalizeAdapter is a function called when filter stream has been closed
 type FinalizeAdapter func(Feedback, MeasureWriter)
 
 // NewFilter takes and adapter that implements filter logic and returns a brand new filter
 // to be used in pipelines
 func NewFilter(adapter FilterAdapter, finalizer FinalizeAdapter) (Filter, error) {
-	return newFilter(adapter, finalizer, io.Pipe, NewWriter, NewReader)
+	return newFilter(adapter, finalizer, ChanMeasurePipe)
+}
+
+// NewFilterContext takes an adapter that implements filter logic and returns a brand new FilterContext.
+// The filter keeps draining its upstream after cancellation and is aborted once the drain timeout expires
+func NewFilterContext(adapter FilterAdapterContext, finalizer FinalizeAdapter) (FilterContext, error) {
+	return newFilterContext(adapter, finalizer, ChanMeasurePipe)
 }
 
 // NewComposedFilter takes a list of Filters and returns a new Filter that is a composition of all
@@ -31,36 +42,54 @@ func NewComposedFilter(flts ...Filter) (Filter, error) {
 	return newComposedFilter(flts...)
 }
 
+// NewComposedFilterContext takes a list of FilterContexts and chains them from left to right
+func NewComposedFilterContext(flts ...FilterContext) (FilterContext, error) {
+	return newComposedFilterContext(flts...)
+}
+
 // NewTimeInjectorFilter takes a time and returns a Filter that inject that time on every measure received
 func NewTimeInjectorFilter(time int64) (Filter, error) {
-	return NewFilter(
-		func(f Feedback, m *Measure, mw MeasureWriter) {
-			m.Time = time
-			mw.Write(*m)
-		}, nil)
+	return adaptedFilter(timeInjectorAdapter(time))
+}
+
+func timeInjectorAdapter(time int64) (FilterAdapter, FinalizeAdapter, error) {
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+		m.Time = time
+		mw.Write(*m)
+	}, nil, nil
 }
 
 // NewNameInjectorFilter takes a name and returns a Filter that inject that name on every measure received
 func NewNameInjectorFilter(name string) (Filter, error) {
-	return NewFilter(
-		func(f Feedback, m *Measure, mw MeasureWriter) {
-			m.Name = name
-			mw.Write(*m)
-		}, nil)
+	return adaptedFilter(nameInjectorAdapter(name))
+}
+
+func nameInjectorAdapter(name string) (FilterAdapter, FinalizeAdapter, error) {
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+		m.Name = name
+		mw.Write(*m)
+	}, nil, nil
 }
 
 // NewNameSanityFilter takes a name and returns a Filter that inject that name on every measure received
 func NewNameSanityFilter() (Filter, error) {
-	return NewFilter(
-		func(f Feedback, m *Measure, mw MeasureWriter) {
-			m.Name = strings.ReplaceAll(m.Name, " ", "")
-			m.Name = strings.ToLower(m.Name)
-			mw.Write(*m)
-		}, nil)
+	return adaptedFilter(nameSanityAdapter())
+}
+
+func nameSanityAdapter() (FilterAdapter, FinalizeAdapter, error) {
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+		m.Name = strings.ReplaceAll(m.Name, " ", "")
+		m.Name = strings.ToLower(m.Name)
+		mw.Write(*m)
+	}, nil, nil
 }
 
 // NewMeasureCountFilter counts the number of inputed measures
 func NewMeasureCountFilter() (Filter, error) {
+	return adaptedFilter(measureCountAdapter())
+}
+
+func measureCountAdapter() (FilterAdapter, FinalizeAdapter, error) {
 	counter := make(map[string]uint64)
 	finalizer := func(f Feedback, mw MeasureWriter) {
 		var keys []string
@@ -69,110 +98,162 @@ func NewMeasureCountFilter() (Filter, error) {
 		}
 		sort.Strings(keys)
 		for _, k := range keys {
-			f("Total %v records: %v", k, counter[k])
+			f.Info("total records", "measure", k, "count", counter[k])
 		}
 	}
-	return NewFilter(
-		func(f Feedback, m *Measure, mw MeasureWriter) {
-			if _, ok := counter[m.Name]; !ok {
-				counter[m.Name] = 0
-			}
-			counter[m.Name]++
-			mw.Write(*m)
-		}, finalizer)
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+		if _, ok := counter[m.Name]; !ok {
+			counter[m.Name] = 0
+		}
+		counter[m.Name]++
+		mw.Write(*m)
+	}, finalizer, nil
 }
 
 // NewByPassFilter takes a name and returns a Filter that inject that name on every measure received
 func NewByPassFilter() (Filter, error) {
-	return NewFilter(
-		func(f Feedback, m *Measure, mw MeasureWriter) {
-			mw.Write(*m)
-		}, nil)
+	return adaptedFilter(byPassAdapter())
+}
+
+func byPassAdapter() (FilterAdapter, FinalizeAdapter, error) {
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+		mw.Write(*m)
+	}, nil, nil
 }
 
 // NewTagInjectorFilter takes a list of tags and returns a Filter that inject those tags on every measure received
 func NewTagInjectorFilter(tags ...Tag) (Filter, error) {
+	return adaptedFilter(tagInjectorAdapter(tags...))
+}
+
+func tagInjectorAdapter(tags ...Tag) (FilterAdapter, FinalizeAdapter, error) {
 	if tags == nil {
-		return nil, errors.New("Tag list is nil")
+		return nil, nil, errors.New("Tag list is nil")
 	}
-	return NewFilter(
-		func(f Feedback, m *Measure, mw MeasureWriter) {
-			m.Tags = append(m.Tags, tags...)
-			mw.Write(*m)
-		}, nil)
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+		m.Tags = append(m.Tags, tags...)
+		mw.Write(*m)
+	}, nil, nil
 }
 
 // NewFieldInjectorFilter takes a list of fields and returnas a filter that inject those fields on every measure received
 func NewFieldInjectorFilter(fields ...Field) (Filter, error) {
+	return adaptedFilter(fieldInjectorAdapter(fields...))
+}
+
+func fieldInjectorAdapter(fields ...Field) (FilterAdapter, FinalizeAdapter, error) {
 	if fields == nil {
-		return nil, errors.New("Field list is nil")
+		return nil, nil, errors.New("Field list is nil")
 	}
-	return NewFilter(
-		func(f Feedback, m *Measure, mw MeasureWriter) {
-			m.Flds = append(m.Flds, fields...)
-			mw.Write(*m)
-		}, nil)
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+		m.Flds = append(m.Flds, fields...)
+		mw.Write(*m)
+	}, nil, nil
 }
 
 // NewLogFilter takes a label and returns a Filters that prints out every Measure, tagged with the label,  on console output
 func NewLogFilter(label string) (Filter, error) {
-	return NewFilter(
-		func(f Feedback, m *Measure, mw MeasureWriter) {
-			b, err := json.MarshalIndent(m, "", "  ")
-			if err != nil {
-				fmt.Println("error:", err)
-			}
-			log.Printf("%v: %s", label, b)
-			mw.Write(*m)
-		}, nil)
+	return adaptedFilter(logAdapter(label))
+}
+
+func logAdapter(label string) (FilterAdapter, FinalizeAdapter, error) {
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+		b, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			fmt.Println("error:", err)
+		}
+		log.Printf("%v: %s", label, b)
+		mw.Write(*m)
+	}, nil, nil
+}
+
+// adaptedFilter returns the Filter of an adapter and finalizer, unless their constructor failed
+func adaptedFilter(adapter FilterAdapter, finalizer FinalizeAdapter, err error) (Filter, error) {
+	if err != nil {
+		return nil, err
+	}
+	return NewFilter(adapter, finalizer)
 }
 
 // newFilter receives a filterAdapter with the filter logic and returns a new filter to be used in pipelines
 func newFilter(
 	adapter FilterAdapter,
 	finalizer FinalizeAdapter,
-	ioPipe func() (*io.PipeReader, *io.PipeWriter),
-	mWriter func(io.Writer) MeasureWriter,
-	mReader func(io.Reader) MeasureReader,
+	pipe MeasurePipe,
 ) (Filter, error) {
 	if adapter == nil {
 		return nil, errors.New("adapter function is nil")
 	}
-	if ioPipe == nil {
-		return nil, errors.New("iopipe is nil")
+	flt, err := newFilterContext(
+		func(ctx context.Context, f Feedback, m *Measure, mw MeasureWriter) {
+			adapter(f, m, mw)
+		}, finalizer, pipe)
+	if err != nil {
+		return nil, err
 	}
-	if mWriter == nil {
-		return nil, errors.New("mWriter is nil")
+	return func(f Feedback, r MeasureReader) (MeasureReader, error) {
+		return flt(context.Background(), f, r)
+	}, nil
+}
+
+// newFilterContext receives a FilterAdapterContext with the filter logic and returns a new FilterContext
+func newFilterContext(
+	adapter FilterAdapterContext,
+	finalizer FinalizeAdapter,
+	pipe MeasurePipe,
+) (FilterContext, error) {
+	if adapter == nil {
+		return nil, errors.New("adapter function is nil")
 	}
-	if mReader == nil {
-		return nil, errors.New("mReader is nil")
+	if pipe == nil {
+		return nil, errors.New("pipe is nil")
 	}
-	return func(f Feedback, r MeasureReader) (MeasureReader, error) {
+	return func(ctx context.Context, f Feedback, r MeasureReader) (MeasureReader, error) {
+		if ctx == nil {
+			return nil, errors.New("context is nil")
+		}
 		if f == nil {
 			return nil, errors.New("feedback funcion is nil")
 		}
 		if r == nil {
 			return nil, errors.New("reader stream is nil")
 		}
-		pr, pw := ioPipe()
-		mr := mReader(pr)
-		mw := mWriter(pw)
+		mr, mw := pipe(ctx)
+		s := stageTelemetry(ctx, "filter")
 		go func() {
-			defer pw.Close()
+			dctx, cancel := drainContext(ctx)
+			defer cancel()
+			stop := onDone(dctx, func() {
+				mw.CloseWithError(context.Cause(dctx))
+				closeMeasureReader(r)
+			})
+			defer stop()
+			defer mw.Close()
+			w := s.writer(mw)
 			for {
 				var m Measure
 				err := r.Read(&m)
 				if err != nil {
 					if err == io.EOF {
 						if finalizer != nil {
-							finalizer(f, mw)
+							finalizer(f, w)
 						}
 						break
 					}
+					if aborted(dctx, err) {
+						break
+					}
+					s.addFailed(1)
 					f("applierFilter error on read: %v", err)
 					continue
 				}
-				adapter(f, &m, mw)
+				s.addRead(1)
+				written, start := writtenBy(w), time.Now()
+				adapter(dctx, f, &m, w)
+				s.observe(start)
+				if writtenBy(w) == written {
+					s.addDropped(1)
+				}
 			}
 		}()
 		return mr, nil
@@ -195,3 +276,21 @@ func newComposedFilter(flts ...Filter) (Filter, error) {
 		return r, nil
 	}, nil
 }
+
+func newComposedFilterContext(flts ...FilterContext) (FilterContext, error) {
+	for _, flt := range flts {
+		if flt == nil {
+			return nil, errors.New("filter function is nil")
+		}
+	}
+	return func(ctx context.Context, f Feedback, r MeasureReader) (MeasureReader, error) {
+		for _, flt := range flts {
+			fr, err := flt(ctx, f, r)
+			if err != nil {
+				return nil, err
+			}
+			r = fr
+		}
+		return r, nil
+	}, nil
+}
@@ -1,6 +1,7 @@
 package mstreamer
 
 import (
+	"context"
 	"errors"
 	"io"
 	"sync"
@@ -8,12 +9,23 @@ import (
 
 // NewInputFromProducer takes a producer function and returns an Input function
 func NewInputFromProducer(producer func(f Feedback, w MeasureWriter)) (Input, error) {
-	return newInputFromProducer(producer, io.Pipe, NewWriter, NewReader)
+	return newInputFromProducer(producer, ChanMeasurePipe)
+}
+
+// NewInputFromProducerContext takes a producer function and returns an InputContext whose
+// stream is ended as soon as the context is done. The producer should return at that point
+func NewInputFromProducerContext(producer func(ctx context.Context, f Feedback, w MeasureWriter)) (InputContext, error) {
+	return newInputFromProducerContext(producer, ChanMeasurePipe)
 }
 
 // NewMergedInput takes a list of inputs and merges it in a single input
 func NewMergedInput(inputs ...Input) (Input, error) {
-	return newMergedInput(io.Pipe, NewWriter, NewReader, inputs...)
+	return newMergedInput(ChanMeasurePipe, inputs...)
+}
+
+// NewMergedInputContext takes a list of InputContexts and merges it in a single InputContext
+func NewMergedInputContext(inputs ...InputContext) (InputContext, error) {
+	return newMergedInputContext(ChanMeasurePipe, inputs...)
 }
 
 // NewComposedInput composes a source and a encoder
@@ -34,6 +46,24 @@ func NewComposedInput(src Source, enc Encoder) (Input, error) {
 	}, nil
 }
 
+// NewComposedInputContext composes a SourceContext and an EncoderContext
+func NewComposedInputContext(src SourceContext, enc EncoderContext) (InputContext, error) {
+	if src == nil {
+		return nil, errors.New("source function is nil")
+	}
+	if enc == nil {
+		return nil, errors.New("encode function is nil")
+	}
+
+	return func(ctx context.Context, f Feedback) (MeasureReader, error) {
+		rsrc, err := src(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		return enc(ctx, f, rsrc)
+	}, nil
+}
+
 // NewFilteredInput composes a Input and a Filter and returns an Input function
 func NewFilteredInput(inp Input, flt Filter) (Input, error) {
 	if inp == nil {
@@ -51,34 +81,65 @@ func NewFilteredInput(inp Input, flt Filter) (Input, error) {
 	}, nil
 }
 
+// NewFilteredInputContext composes an InputContext and a FilterContext and returns an InputContext
+func NewFilteredInputContext(inp InputContext, flt FilterContext) (InputContext, error) {
+	if inp == nil {
+		return nil, errors.New("input function is nil")
+	}
+	if flt == nil {
+		return nil, errors.New("filter function is nil")
+	}
+	return func(ctx context.Context, f Feedback) (MeasureReader, error) {
+		rinp, err := inp(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		return flt(ctx, f, rinp)
+	}, nil
+}
+
 func newInputFromProducer(
 	producer func(f Feedback, w MeasureWriter),
-	ioPipe func() (*io.PipeReader, *io.PipeWriter),
-	mWriter func(io.Writer) MeasureWriter,
-	mReader func(io.Reader) MeasureReader,
+	pipe MeasurePipe,
 ) (Input, error) {
 	if producer == nil {
 		return nil, errors.New("Input producer function is nil")
 	}
-	if ioPipe == nil {
-		return nil, errors.New("ioPipe is nil")
+	inp, err := newInputFromProducerContext(
+		func(ctx context.Context, f Feedback, w MeasureWriter) {
+			producer(f, w)
+		}, pipe)
+	if err != nil {
+		return nil, err
 	}
-	if mWriter == nil {
-		return nil, errors.New("ioWriter is nil")
+	return func(f Feedback) (MeasureReader, error) {
+		return inp(context.Background(), f)
+	}, nil
+}
+
+func newInputFromProducerContext(
+	producer func(ctx context.Context, f Feedback, w MeasureWriter),
+	pipe MeasurePipe,
+) (InputContext, error) {
+	if producer == nil {
+		return nil, errors.New("Input producer function is nil")
 	}
-	if mReader == nil {
-		return nil, errors.New("ioReader is nil")
+	if pipe == nil {
+		return nil, errors.New("pipe is nil")
 	}
-	return func(f Feedback) (MeasureReader, error) {
+	return func(ctx context.Context, f Feedback) (MeasureReader, error) {
+		if ctx == nil {
+			return nil, errors.New("context is nil")
+		}
 		if f == nil {
 			return nil, errors.New("Feedback function is nil")
 		}
-		pr, pw := ioPipe()
-		mr := mReader(pr)
-		mw := mWriter(pw)
+		mr, mw := pipe(ctx)
 		go func() {
-			defer pw.Close()
-			producer(f, mw)
+			stop := onDone(ctx, func() { mw.Close() })
+			defer stop()
+			defer mw.Close()
+			producer(ctx, f, mw)
 		}()
 		return mr, nil
 	}, nil
@@ -86,34 +147,59 @@ func newInputFromProducer(
 
 // newMergedInput takes a list of inputs and merges it in a single input
 func newMergedInput(
-	ioPipe func() (*io.PipeReader, *io.PipeWriter),
-	mWriter func(io.Writer) MeasureWriter,
-	mReader func(io.Reader) MeasureReader,
+	pipe MeasurePipe,
 	inputs ...Input,
 ) (Input, error) {
-	if ioPipe == nil {
-		return nil, errors.New("iopipe is nil")
+	var ctxInputs []InputContext
+	for _, input := range inputs {
+		ctxInputs = append(ctxInputs, ContextInput(input))
 	}
-	if mWriter == nil {
-		return nil, errors.New("mWriter is nil")
+	inp, err := newMergedInputContext(pipe, ctxInputs...)
+	if err != nil {
+		return nil, err
 	}
-	if mReader == nil {
-		return nil, errors.New("mReader is nil")
+	return func(f Feedback) (MeasureReader, error) {
+		return inp(context.Background(), f)
+	}, nil
+}
+
+// newMergedInputContext takes a list of InputContexts and merges it in a single InputContext
+func newMergedInputContext(
+	pipe MeasurePipe,
+	inputs ...InputContext,
+) (InputContext, error) {
+	if pipe == nil {
+		return nil, errors.New("pipe is nil")
 	}
 
-	return func(f Feedback) (MeasureReader, error) {
+	return func(ctx context.Context, f Feedback) (MeasureReader, error) {
+		if ctx == nil {
+			return nil, errors.New("context is nil")
+		}
 		if f == nil {
 			return nil, errors.New("feedback funcion is nil")
 		}
-		var wg sync.WaitGroup
-		outc := make(chan Measure)
-		//FanOut
+		var readers []MeasureReader
 		for _, input := range inputs {
-			r, err := input(f)
+			r, err := input(ctx, f)
 			if err != nil {
+				for _, r := range readers {
+					closeMeasureReader(r)
+				}
 				return nil, err
 			}
+			readers = append(readers, r)
+		}
+
+		dctx, cancel := drainContext(ctx)
+		s := stageTelemetry(ctx, "merged_input")
+		var wg sync.WaitGroup
+		outc := make(chan Measure)
+		//FanOut
+		for _, r := range readers {
+			wg.Add(1)
 			go func(r MeasureReader) {
+				defer wg.Done()
 				for {
 					var m Measure
 					err := r.Read(&m)
@@ -121,27 +207,42 @@ func newMergedInput(
 						if err == io.EOF {
 							break
 						}
+						if aborted(dctx, err) {
+							break
+						}
+						s.addFailed(1)
 						f("error on reading measure %v", err)
+						continue
+					}
+					s.addRead(1)
+					select {
+					case outc <- m:
+					case <-dctx.Done():
+						return
 					}
-					outc <- m
 				}
-				wg.Done()
 			}(r)
-			wg.Add(1)
 		}
 		go func() {
 			wg.Wait()
 			close(outc)
 		}()
 
-		pr, pw := ioPipe()
-		mr := mReader(pr)
-		mw := mWriter(pw)
+		mr, mw := pipe(ctx)
 		//FanIn
 		go func() {
-			defer pw.Close()
+			defer cancel()
+			stop := onDone(dctx, func() {
+				mw.CloseWithError(context.Cause(dctx))
+				for _, r := range readers {
+					closeMeasureReader(r)
+				}
+			})
+			defer stop()
+			defer mw.Close()
+			w := s.writer(mw)
 			for m := range outc {
-				mw.Write(m)
+				w.Write(m)
 			}
 		}()
 
@@ -0,0 +1,337 @@
+package mstreamer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// NewLineProtocolEncoder returns an Encoder that parses InfluxDB line protocol.
+// precision is the unit of the timestamps: ns (default), us, ms or s.
+// Lines without timestamp get the time they are parsed at and comment lines are ignored
+func NewLineProtocolEncoder(precision string) (Encoder, error) {
+	adapter, err := lineProtocolEncoderAdapter(precision)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncoder(adapter)
+}
+
+// NewLineProtocolDecoder returns a Decoder that writes measures as InfluxDB line protocol.
+// precision is the unit of the written timestamps: ns (default), us, ms or s.
+// Measures with a zero time are written without timestamp
+func NewLineProtocolDecoder(precision string) (Decoder, error) {
+	decw, err := lineProtocolDecoderToWriter(precision)
+	if err != nil {
+		return nil, err
+	}
+	return NewGenericDecoder(decw)
+}
+
+// ParseLineProtocol parses a single line protocol line. precision is the unit of its timestamp
+func ParseLineProtocol(line []byte, precision time.Duration) (Measure, error) {
+	p := &lineParser{buf: line}
+	return p.parse(precision)
+}
+
+// AppendLineProtocol appends m as a line protocol line, without trailing newline, to buf.
+// precision is the unit of the written timestamp
+func AppendLineProtocol(buf []byte, m Measure, precision time.Duration) ([]byte, error) {
+	if m.Name == "" {
+		return buf, errors.New("measure name is empty")
+	}
+	buf = appendEscaped(buf, m.Name, measurementEscapes)
+	for _, t := range m.Tags {
+		if t.Name == "" || t.Data == "" {
+			continue
+		}
+		buf = append(buf, ',')
+		buf = appendEscaped(buf, t.Name, keyEscapes)
+		buf = append(buf, '=')
+		buf = appendEscaped(buf, t.Data, keyEscapes)
+	}
+	sep := byte(' ')
+	written := 0
+	for _, f := range m.Flds {
+		if f.Name == "" {
+			continue
+		}
+		value, ok := appendFieldValue(nil, f)
+		if !ok {
+			continue
+		}
+		buf = append(buf, sep)
+		buf = appendEscaped(buf, f.Name, keyEscapes)
+		buf = append(buf, '=')
+		buf = append(buf, value...)
+		sep = ','
+		written++
+	}
+	if written == 0 {
+		return buf, fmt.Errorf("measure %v has no field line protocol can represent", m.Name)
+	}
+	if m.Time != 0 {
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, m.Time/int64(precision), 10)
+	}
+	return buf, nil
+}
+
+// ParsePrecision converts a line protocol precision (ns, us, ms, s) to its duration
+func ParsePrecision(precision string) (time.Duration, error) {
+	switch precision {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µs":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	default:
+		return 0, fmt.Errorf("unknown precision %q", precision)
+	}
+}
+
+func lineProtocolEncoderAdapter(precision string) (EncoderAdapter, error) {
+	unit, err := ParsePrecision(precision)
+	if err != nil {
+		return nil, err
+	}
+	return func(f Feedback, r io.Reader, w MeasureWriter) {
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		n := 0
+		for sc.Scan() {
+			n++
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 || line[0] == '#' {
+				continue
+			}
+			m, err := ParseLineProtocol(line, unit)
+			if err != nil {
+				f("line protocol error at line %v: %v", n, err)
+				continue
+			}
+			if err := w.Write(m); err != nil {
+				f("line protocol write error: %v", err)
+				return
+			}
+		}
+		if err := sc.Err(); err != nil {
+			f("line protocol read error: %v", err)
+		}
+	}, nil
+}
+
+func lineProtocolDecoderToWriter(precision string) (DecoderToWriter, error) {
+	unit, err := ParsePrecision(precision)
+	if err != nil {
+		return nil, err
+	}
+	return func(m Measure, w io.Writer) error {
+		buf, err := AppendLineProtocol(nil, m, unit)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(buf, '\n'))
+		return err
+	}, nil
+}
+
+var (
+	measurementEscapes = []byte{',', ' '}
+	keyEscapes         = []byte{',', '=', ' '}
+)
+
+func appendEscaped(buf []byte, s string, escapes []byte) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if bytes.IndexByte(escapes, c) >= 0 {
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, c)
+	}
+	return buf
+}
+
+func appendFieldValue(buf []byte, f Field) ([]byte, bool) {
+	switch v := f.Data.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return buf, false
+		}
+		return strconv.AppendFloat(buf, v, 'g', -1, 64), true
+	case int64:
+		return append(strconv.AppendInt(buf, v, 10), 'i'), true
+	case uint64:
+		return append(strconv.AppendUint(buf, v, 10), 'u'), true
+	case bool:
+		return strconv.AppendBool(buf, v), true
+	case string:
+		buf = append(buf, '"')
+		for i := 0; i < len(v); i++ {
+			if v[i] == '"' || v[i] == '\\' {
+				buf = append(buf, '\\')
+			}
+			buf = append(buf, v[i])
+		}
+		return append(buf, '"'), true
+	default:
+		return buf, false
+	}
+}
+
+type lineParser struct {
+	buf []byte
+	pos int
+}
+
+func (p *lineParser) parse(precision time.Duration) (Measure, error) {
+	var m Measure
+	name := p.token(measurementEscapes, ", ")
+	if name == "" {
+		return m, errors.New("missing measurement name")
+	}
+	m.Name = name
+	for p.pos < len(p.buf) && p.buf[p.pos] == ',' {
+		p.pos++
+		key := p.token(keyEscapes, "=, ")
+		if !p.expect('=') || key == "" {
+			return m, fmt.Errorf("invalid tag at column %v", p.pos)
+		}
+		value := p.token(keyEscapes, ", ")
+		if value == "" {
+			return m, fmt.Errorf("tag %v has no value", key)
+		}
+		m.Tags = append(m.Tags, MakeTag(key, value))
+	}
+	if !p.spaces() {
+		return m, errors.New("missing fields")
+	}
+	for {
+		key := p.token(keyEscapes, "=, ")
+		if !p.expect('=') || key == "" {
+			return m, fmt.Errorf("invalid field at column %v", p.pos)
+		}
+		field, err := p.fieldValue(key)
+		if err != nil {
+			return m, err
+		}
+		m.Flds = append(m.Flds, field)
+		if p.pos < len(p.buf) && p.buf[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	if p.spaces() && p.pos < len(p.buf) {
+		ts, err := strconv.ParseInt(string(p.buf[p.pos:]), 10, 64)
+		if err != nil {
+			return m, fmt.Errorf("invalid timestamp %q", p.buf[p.pos:])
+		}
+		m.Time = ts * int64(precision)
+		p.pos = len(p.buf)
+	} else {
+		m.Time = time.Now().UnixNano()
+	}
+	if p.pos < len(p.buf) {
+		return m, fmt.Errorf("unexpected %q at column %v", p.buf[p.pos:], p.pos)
+	}
+	return m, nil
+}
+
+// token reads up to an unescaped stop byte, unescaping the escapes bytes
+func (p *lineParser) token(escapes []byte, stops string) string {
+	var out []byte
+	for p.pos < len(p.buf) {
+		c := p.buf[p.pos]
+		if c == '\\' && p.pos+1 < len(p.buf) && bytes.IndexByte(escapes, p.buf[p.pos+1]) >= 0 {
+			out = append(out, p.buf[p.pos+1])
+			p.pos += 2
+			continue
+		}
+		if bytes.IndexByte([]byte(stops), c) >= 0 {
+			break
+		}
+		out = append(out, c)
+		p.pos++
+	}
+	return string(out)
+}
+
+func (p *lineParser) expect(c byte) bool {
+	if p.pos < len(p.buf) && p.buf[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *lineParser) spaces() bool {
+	start := p.pos
+	for p.pos < len(p.buf) && p.buf[p.pos] == ' ' {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func (p *lineParser) fieldValue(key string) (Field, error) {
+	if p.pos < len(p.buf) && p.buf[p.pos] == '"' {
+		p.pos++
+		var out []byte
+		for p.pos < len(p.buf) {
+			c := p.buf[p.pos]
+			if c == '\\' && p.pos+1 < len(p.buf) && (p.buf[p.pos+1] == '"' || p.buf[p.pos+1] == '\\') {
+				out = append(out, p.buf[p.pos+1])
+				p.pos += 2
+				continue
+			}
+			if c == '"' {
+				p.pos++
+				return Field{Name: key, Type: TString, Data: string(out)}, nil
+			}
+			out = append(out, c)
+			p.pos++
+		}
+		return Field{}, fmt.Errorf("unterminated string in field %v", key)
+	}
+	start := p.pos
+	for p.pos < len(p.buf) && p.buf[p.pos] != ',' && p.buf[p.pos] != ' ' {
+		p.pos++
+	}
+	raw := string(p.buf[start:p.pos])
+	if raw == "" {
+		return Field{}, fmt.Errorf("field %v has no value", key)
+	}
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return Field{Name: key, Type: TBool, Data: true}, nil
+	case "f", "F", "false", "False", "FALSE":
+		return Field{Name: key, Type: TBool, Data: false}, nil
+	}
+	switch raw[len(raw)-1] {
+	case 'i':
+		v, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return Field{}, fmt.Errorf("invalid integer in field %v: %v", key, raw)
+		}
+		return Field{Name: key, Type: TInt, Data: v}, nil
+	case 'u':
+		v, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return Field{}, fmt.Errorf("invalid unsigned integer in field %v: %v", key, raw)
+		}
+		return Field{Name: key, Type: TUint, Data: v}, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return Field{}, fmt.Errorf("invalid float in field %v: %v", key, raw)
+	}
+	return Field{Name: key, Type: TFloat, Data: v}, nil
+}
@@ -0,0 +1,130 @@
+package mstreamer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLineProtocol(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		precision time.Duration
+		want      Measure
+		wantErr   bool
+	}{
+		{
+			name: `when line has tags and typed fields then all are parsed`,
+			line: `cpu,host=a,region=us usage=0.5,count=3i,total=4u,up=t,msg="ok" 1257894000000000000`,
+			want: Measure{Name: "cpu", Tags: []Tag{{"host", "a"}, {"region", "us"}}, Flds: []Field{
+				{"usage", TFloat, 0.5}, {"count", TInt, int64(3)}, {"total", TUint, uint64(4)},
+				{"up", TBool, true}, {"msg", TString, "ok"},
+			}, Time: 1257894000000000000},
+		},
+		{
+			name: `when measurement has escaped comma and space then they are unescaped`,
+			line: `cpu\,load\ avg value=1 1`,
+			want: Measure{Name: "cpu,load avg", Flds: []Field{{"value", TFloat, 1.0}}, Time: 1},
+		},
+		{
+			name: `when tag keys and values have escaped characters then they are unescaped`,
+			line: `cpu,host\ name=a\=b\,c value=1 1`,
+			want: Measure{Name: "cpu", Tags: []Tag{{"host name", "a=b,c"}}, Flds: []Field{{"value", TFloat, 1.0}}, Time: 1},
+		},
+		{
+			name: `when measurement contains an equal sign then it is kept`,
+			line: `a=b value=1 1`,
+			want: Measure{Name: "a=b", Flds: []Field{{"value", TFloat, 1.0}}, Time: 1},
+		},
+		{
+			name: `when string field has escaped quotes, backslashes, spaces and commas then they are kept`,
+			line: `log msg="say \"hi\", c:\\ ok",n=-2i 1`,
+			want: Measure{Name: "log", Flds: []Field{{"msg", TString, `say "hi", c:\ ok`}, {"n", TInt, int64(-2)}}, Time: 1},
+		},
+		{
+			name: `when floats use exponents and booleans long forms then they are parsed`,
+			line: `m a=-1.5e+06,b=1e-3,c=FALSE,d=True 1`,
+			want: Measure{Name: "m", Flds: []Field{{"a", TFloat, -1.5e6}, {"b", TFloat, 1e-3}, {"c", TBool, false}, {"d", TBool, true}}, Time: 1},
+		},
+		{
+			name: `when precision is seconds then timestamp is scaled`,
+			line: `m v=1i 1257894000`, precision: time.Second,
+			want: Measure{Name: "m", Flds: []Field{{"v", TInt, int64(1)}}, Time: 1257894000000000000},
+		},
+		{name: `when fields are missing then should fail`, line: `cpu,host=a`, wantErr: true},
+		{name: `when tag has no value then should fail`, line: `cpu,host= v=1`, wantErr: true},
+		{name: `when integer is invalid then should fail`, line: `cpu v=1.5i`, wantErr: true},
+		{name: `when unsigned is negative then should fail`, line: `cpu v=-1u`, wantErr: true},
+		{name: `when string is unterminated then should fail`, line: `cpu v="abc`, wantErr: true},
+		{name: `when timestamp is invalid then should fail`, line: `cpu v=1 abc`, wantErr: true},
+		{name: `when float is not a number then should fail`, line: `cpu v=NaN`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			precision := tt.precision
+			if precision == 0 {
+				precision = time.Nanosecond
+			}
+			got, err := ParseLineProtocol([]byte(tt.line), precision)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLineProtocol() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLineProtocol() got %#v want %#v", got, tt.want)
+			}
+			line, err := AppendLineProtocol(nil, got, precision)
+			if err != nil {
+				t.Fatalf("AppendLineProtocol() error = %v", err)
+			}
+			back, err := ParseLineProtocol(line, precision)
+			if err != nil {
+				t.Fatalf("ParseLineProtocol(%s) error = %v", line, err)
+			}
+			if !reflect.DeepEqual(back, got) {
+				t.Errorf("round trip of %s got %#v want %#v", line, back, got)
+			}
+		})
+	}
+}
+
+func TestLineProtocolEncoderDecoder(t *testing.T) {
+	in := "# comment\n\ncpu,host=a v=1i 1\nbad line\nmem,host=b free=2.5,ok=true 2\n"
+	enc, err := NewLineProtocolEncoder("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewLineProtocolDecoder("ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []string
+	f := func(format string, a ...interface{}) { errs = append(errs, format) }
+	mr, err := enc(f, ioutil.NopCloser(strings.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := dec(f, mr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	io.Copy(&buf, out)
+	want := "cpu,host=a v=1i 1\nmem,host=b free=2.5,ok=true 2\n"
+	if buf.String() != want {
+		t.Errorf("got %q want %q", buf.String(), want)
+	}
+	if len(errs) != 1 {
+		t.Errorf("got %v feedback messages want 1: %v", len(errs), errs)
+	}
+	if _, err := NewLineProtocolEncoder("minutes"); err == nil {
+		t.Errorf("want error on unknown precision")
+	}
+}
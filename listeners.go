@@ -0,0 +1,243 @@
+package mstreamer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxLineSize bounds the lines read from stream connections by default
+	DefaultMaxLineSize = 64 * 1024
+	// maxPacketSize is the largest datagram read from packet connections
+	maxPacketSize = 64 * 1024
+)
+
+// ListenerOptions configures a listener source
+type ListenerOptions struct {
+	// Network is tcp, tcp4, tcp6 or unix for newline framed streams,
+	// udp, udp4, udp6 or unixgram for packets
+	Network string
+	// Addr is the address listened on, as in :2003 or a socket path
+	Addr string
+	// Listener or PacketConn are served instead of listening on Addr when set. They are closed at the end of the run
+	Listener   net.Listener
+	PacketConn net.PacketConn
+	// MaxConnections bounds the stream connections served at once, others are closed right away. Zero is no limit
+	MaxConnections int
+	// IdleTimeout closes stream connections idle for that long, zero never does
+	IdleTimeout time.Duration
+	// MaxLineSize bounds stream lines, longer ones are dropped. DefaultMaxLineSize when zero
+	MaxLineSize int
+	// ReadBufferSize sets the operating system receive buffer of packet connections when not zero
+	ReadBufferSize int
+}
+
+// Validate checks the network, the address and the limits
+func (o ListenerOptions) Validate() error {
+	if !o.stream() && !o.packet() {
+		return fmt.Errorf("unknown network %q", o.Network)
+	}
+	if o.Addr == "" && o.Listener == nil && o.PacketConn == nil {
+		return errors.New("listener address is empty")
+	}
+	if o.MaxConnections < 0 || o.IdleTimeout < 0 || o.MaxLineSize < 0 || o.ReadBufferSize < 0 {
+		return errors.New("listener limits must not be negative")
+	}
+	return nil
+}
+
+func (o ListenerOptions) stream() bool {
+	switch o.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return true
+	}
+	return false
+}
+
+func (o ListenerOptions) packet() bool {
+	switch o.Network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	}
+	return false
+}
+
+// NewListenerSourceContext returns a SourceContext listening for line based protocols such as Graphite
+// or StatsD until its context is done. Lines of every stream connection and datagrams, a newline added
+// when missing, are multiplexed whole into the stream, so one Encoder parses the traffic of all clients
+func NewListenerSourceContext(opts ListenerOptions) (SourceContext, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.MaxLineSize == 0 {
+		opts.MaxLineSize = DefaultMaxLineSize
+	}
+	return NewSourceContext(func(ctx context.Context, f Feedback, w io.Writer) {
+		lw := &lockedWriter{w: w}
+		if opts.stream() {
+			serveStream(ctx, f, lw, opts)
+		} else {
+			servePackets(ctx, f, lw, opts)
+		}
+	})
+}
+
+// lockedWriter writes every line whole whatever the goroutines writing
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func serveStream(ctx context.Context, f Feedback, w io.Writer, opts ListenerOptions) {
+	ln := opts.Listener
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen(opts.Network, opts.Addr); err != nil {
+			f.Error(err, "cannot listen", "network", opts.Network, "addr", opts.Addr)
+			return
+		}
+	}
+	var (
+		mu    sync.Mutex
+		conns = make(map[net.Conn]struct{})
+		wg    sync.WaitGroup
+	)
+	// canceling closes the listener and every connection, once the stream or the listener fails too
+	ctx, cancel := context.WithCancel(ctx)
+	stop := onDone(ctx, func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for c := range conns {
+			c.Close()
+		}
+	})
+	defer stop()
+	defer wg.Wait()
+	defer cancel()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				f.Error(err, "listener stopped", "addr", ln.Addr().String())
+			}
+			return
+		}
+		mu.Lock()
+		if opts.MaxConnections > 0 && len(conns) >= opts.MaxConnections {
+			mu.Unlock()
+			f.Warn("connection refused, too many connections", "remote", c.RemoteAddr().String())
+			c.Close()
+			continue
+		}
+		conns[c] = struct{}{}
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(conns, c)
+				mu.Unlock()
+				c.Close()
+			}()
+			if !serveConn(f, c, w, opts) {
+				cancel()
+			}
+		}()
+	}
+}
+
+// serveConn writes the lines read from c until it is closed or idle, or returns false once the stream is closed
+func serveConn(f Feedback, c net.Conn, w io.Writer, opts ListenerOptions) bool {
+	r := bufio.NewReaderSize(c, opts.MaxLineSize)
+	skipping := false
+	for {
+		if opts.IdleTimeout > 0 {
+			c.SetReadDeadline(time.Now().Add(opts.IdleTimeout))
+		}
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			if !skipping {
+				f.Warn("line dropped, longer than the max line size", "remote", c.RemoteAddr().String(), "max", opts.MaxLineSize)
+			}
+			skipping = true
+			continue
+		}
+		if skipping {
+			// the end of the line dropped
+			line, skipping = nil, false
+		}
+		if len(line) > 0 {
+			if err != nil {
+				line = append(line, '\n')
+			}
+			if _, werr := w.Write(line); werr != nil {
+				return false
+			}
+		}
+		var nerr net.Error
+		switch {
+		case err == nil, err == io.EOF:
+		case errors.As(err, &nerr) && nerr.Timeout():
+			f.Info("idle connection closed", "remote", c.RemoteAddr().String())
+		case !errors.Is(err, net.ErrClosed):
+			f.Error(err, "connection read failed", "remote", c.RemoteAddr().String())
+		}
+		if err != nil {
+			return true
+		}
+	}
+}
+
+func servePackets(ctx context.Context, f Feedback, w io.Writer, opts ListenerOptions) {
+	pc := opts.PacketConn
+	if pc == nil {
+		var err error
+		if pc, err = net.ListenPacket(opts.Network, opts.Addr); err != nil {
+			f.Error(err, "cannot listen", "network", opts.Network, "addr", opts.Addr)
+			return
+		}
+	}
+	defer pc.Close()
+	stop := onDone(ctx, func() { pc.Close() })
+	defer stop()
+	if opts.ReadBufferSize > 0 {
+		if rb, ok := pc.(interface{ SetReadBuffer(int) error }); ok {
+			if err := rb.SetReadBuffer(opts.ReadBufferSize); err != nil {
+				f.Warn("cannot set read buffer", "size", opts.ReadBufferSize, "err", err)
+			}
+		}
+	}
+	buf := make([]byte, maxPacketSize+1)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if n > 0 {
+			packet := buf[:n]
+			if packet[n-1] != '\n' {
+				packet = append(packet, '\n')
+			}
+			if _, werr := w.Write(packet); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				f.Error(err, "packet read failed", "addr", pc.LocalAddr().String())
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package mstreamer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runListener starts a listener source, returning its stream and the events it reported so far
+func runListener(t *testing.T, opts ListenerOptions) (*bufio.Reader, func() []string, context.CancelFunc) {
+	t.Helper()
+	src, err := NewListenerSourceContext(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	f, _ := NewEventFeedback(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e.Msg)
+	})
+	r, err := src(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return bufio.NewReader(r), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), events...)
+	}, cancel
+}
+
+func readLines(t *testing.T, r *bufio.Reader, n int) []string {
+	t.Helper()
+	var lines []string
+	for len(lines) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("got %q then %v", lines, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestListenerSourceTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, cancel := runListener(t, ListenerOptions{Network: "tcp", Listener: ln})
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+	// halves of lines written by turns on both connections
+	conns[0].Write([]byte("a.one 1 "))
+	conns[1].Write([]byte("b.one 1 "))
+	time.Sleep(20 * time.Millisecond)
+	conns[0].Write([]byte("10\na.two 2 10\n"))
+	conns[1].Write([]byte("10\nb.two"))
+	conns[1].Close()
+	got := readLines(t, r, 4)
+	sort.Strings(got)
+	want := []string{"a.one 1 10\n", "a.two 2 10\n", "b.one 1 10\n", "b.two\n"}
+	if strings.Join(got, "") != strings.Join(want, "") {
+		t.Errorf("got lines %q want %q", got, want)
+	}
+	cancel()
+	if rest, err := io.ReadAll(r); err != nil || len(rest) > 0 {
+		t.Errorf("got %q, %v once canceled, want the stream closed", rest, err)
+	}
+}
+
+func TestListenerSourceLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      ListenerOptions
+		send      string
+		second    bool
+		want      string
+		wantEvent string
+	}{
+		{
+			name:      `when a line is too long then it is dropped`,
+			opts:      ListenerOptions{MaxLineSize: 16},
+			send:      "short 1\n" + strings.Repeat("x", 40) + " 2\nafter 3\n",
+			want:      "short 1\nafter 3\n",
+			wantEvent: "line dropped, longer than the max line size",
+		},
+		{
+			name:      `when connections are above the max then they are refused`,
+			opts:      ListenerOptions{MaxConnections: 1},
+			send:      "first 1\n",
+			second:    true,
+			want:      "first 1\n",
+			wantEvent: "connection refused, too many connections",
+		},
+		{
+			name:      `when a connection is idle then it is closed`,
+			opts:      ListenerOptions{IdleTimeout: 50 * time.Millisecond},
+			send:      "idle 1\n",
+			want:      "idle 1\n",
+			wantEvent: "idle connection closed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.opts.Network, tt.opts.Listener = "tcp", ln
+			r, events, _ := runListener(t, tt.opts)
+			c, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.Write([]byte(tt.send))
+			got := readLines(t, r, strings.Count(tt.want, "\n"))
+			if strings.Join(got, "") != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+			if tt.second {
+				c2, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer c2.Close()
+				c2.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if _, err := c2.Read(make([]byte, 1)); err != io.EOF {
+					t.Errorf("got %v reading the second connection want EOF", err)
+				}
+			} else if tt.opts.IdleTimeout > 0 {
+				c.SetReadDeadline(time.Now().Add(5 * time.Second))
+				if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+					t.Errorf("got %v reading the idle connection want EOF", err)
+				}
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for !contains(events(), tt.wantEvent) && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if !contains(events(), tt.wantEvent) {
+				t.Errorf("got events %q want %q", events(), tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestListenerSourceUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, _ := runListener(t, ListenerOptions{Network: "udp", PacketConn: pc, ReadBufferSize: 1 << 16})
+	c, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("hits:1|c"))
+	got := readLines(t, r, 1)
+	c.Write([]byte("load:2|g\nmem:3|g\n"))
+	got = append(got, readLines(t, r, 2)...)
+	want := "hits:1|c\nload:2|g\nmem:3|g\n"
+	if strings.Join(got, "") != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestListenerSourceUnix(t *testing.T) {
+	// socket paths are short, t.TempDir may be too long
+	dir, err := os.MkdirTemp("", "mst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "s.sock")
+	r, _, _ := runListener(t, ListenerOptions{Network: "unix", Addr: path})
+	var c net.Conn
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if c, err = net.Dial("unix", path); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("sock 1 10\n"))
+	if got := readLines(t, r, 1); got[0] != "sock 1 10\n" {
+		t.Errorf("got %q want sock 1 10", got[0])
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
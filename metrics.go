@@ -1,6 +1,7 @@
 package mstreamer
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/sha1"
 	"errors"
@@ -20,12 +21,19 @@ type Feedback func(format string, a ...interface{})
 // Runnable takes a Feedback function and runs whatever logic inside and returns an error if any
 type Runnable func(Feedback) error
 
+// RunnableContext is a Runnable that stops when its context is done.
+// It returns an error wrapping ErrPipelineCanceled if the context was cancelled before the run completed
+type RunnableContext func(context.Context, Feedback) error
+
 // IOPipeline takes an input, a filter and an output
 type IOPipeline func(Input, Filter, Output) (Runnable, error)
 
 // Input takes a Feedback and returns a reader
 type Input func(Feedback) (MeasureReader, error)
 
+// InputContext is an Input that stops producing measures when its context is done
+type InputContext func(context.Context, Feedback) (MeasureReader, error)
+
 // FilteredInput composes a Input and a Filter and returns an Input function
 type FilteredInput func(Input, Filter) (Input, error)
 
@@ -40,6 +48,9 @@ type ComposedInput func(Source, Encoder) (Input, error)
 // Runtime errors should be send to the Feedback function.
 type Source func(Feedback) (io.ReadCloser, error)
 
+// SourceContext is a Source that closes its stream when its context is done
+type SourceContext func(context.Context, Feedback) (io.ReadCloser, error)
+
 // Encoder takes a Feedback function and a stream reader and returns a measure Reader and an error if any
 // Encoder should read from the received stream and write encoded metrics into a Writer function.
 // The data will be available to other systems through the returned Reader
@@ -47,6 +58,9 @@ type Source func(Feedback) (io.ReadCloser, error)
 // Runtime errors should be send to the Feedback function.
 type Encoder func(Feedback, io.ReadCloser) (MeasureReader, error)
 
+// EncoderContext is an Encoder that is aborted when its context drain timeout expires
+type EncoderContext func(context.Context, Feedback, io.ReadCloser) (MeasureReader, error)
+
 // Filter takes a Feedback funtion and a Measure Reader function and returns anoter Measure Reader funtion
 // Filter should read measures from the received Measure Read, apply some kind of filter logic and
 // write the results down to the output Reader
@@ -54,12 +68,18 @@ type Encoder func(Feedback, io.ReadCloser) (MeasureReader, error)
 // Runtime errors should be send to the Feedback function.
 type Filter func(Feedback, MeasureReader) (MeasureReader, error)
 
+// FilterContext is a Filter that is aborted when its context drain timeout expires
+type FilterContext func(context.Context, Feedback, MeasureReader) (MeasureReader, error)
+
 // ComposedFilter composes a set of filters in a chain
 type ComposedFilter func(flts ...Filter) (Filter, error)
 
 // Output takes a Feedback and a Reader and outputs to whatever place
 type Output func(Feedback, MeasureReader) error
 
+// OutputContext is an Output that is aborted when its context drain timeout expires
+type OutputContext func(context.Context, Feedback, MeasureReader) error
+
 // FilteredOutput composes a Input and a Filter and returns an Input function
 type FilteredOutput func(Filter, Output) (Output, error)
 
@@ -76,12 +96,18 @@ type ComposedOutput func(Decoder, Sinker) (Output, error)
 // Runtime errors should be send to the Feedback function.
 type Decoder func(Feedback, MeasureReader) (io.ReadCloser, error)
 
+// DecoderContext is a Decoder that is aborted when its context drain timeout expires
+type DecoderContext func(context.Context, Feedback, MeasureReader) (io.ReadCloser, error)
+
 // Sinker takes a Feedback function and a generic Reader and returns an error if any
 // Sinker should send the received data to other systems
 // Initialization errors should be returned using the error object
 // Runtime errors should be send to the Feedback function.
 type Sinker func(Feedback, io.ReadCloser) error
 
+// SinkerContext is a Sinker that is aborted when its context drain timeout expires
+type SinkerContext func(context.Context, Feedback, io.ReadCloser) error
+
 // MeasureWriter takes a Measure struct, send it to any kind of transportation and return am error if any
 type MeasureWriter interface {
 	Write(Measure) error
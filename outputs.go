@@ -1,9 +1,14 @@
 package mstreamer
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // NewMergedOutput takes a list of outputs and returns a single output function
@@ -11,7 +16,70 @@ func NewMergedOutput(outputs ...Output) (Output, error) {
 	if outputs == nil {
 		return nil, errors.New("Merge outputs with an empty list does nothing")
 	}
-	return newMergedOutput(io.Pipe, NewWriter, NewReader, outputs...)
+	return newMergedOutput(ChanMeasurePipe, outputs...)
+}
+
+// NewMergedOutputContext takes a list of OutputContexts and returns a single OutputContext
+func NewMergedOutputContext(outputs ...OutputContext) (OutputContext, error) {
+	if outputs == nil {
+		return nil, errors.New("Merge outputs with an empty list does nothing")
+	}
+	return newMergedOutputContext(ChanMeasurePipe, outputs...)
+}
+
+// OverflowPolicy tells a fan-out output what to do with a measure when the queue of a branch is full
+type OverflowPolicy int
+
+const (
+	//OverflowBlock waits for the branch to make room, holding back every other branch meanwhile
+	OverflowBlock OverflowPolicy = iota
+	//OverflowDropNewest drops the measure that does not fit
+	OverflowDropNewest
+	//OverflowDropOldest drops the oldest queued measure to make room
+	OverflowDropOldest
+	//OverflowSpill blocks too, but on a disk buffer put in front of the branch, see NewDiskBufferedOutputContext
+	OverflowSpill
+)
+
+// ParseOverflowPolicy parses block, drop_newest, drop_oldest or spill
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "", "block":
+		return OverflowBlock, nil
+	case "drop_newest":
+		return OverflowDropNewest, nil
+	case "drop_oldest":
+		return OverflowDropOldest, nil
+	case "spill":
+		return OverflowSpill, nil
+	default:
+		return 0, fmt.Errorf("unknown overflow policy %q", s)
+	}
+}
+
+// reportInterval is how often the measures dropped, late or rejected by a stage are reported
+const reportInterval = 10 * time.Second
+
+// OutputBranch is an output of a fan-out along with its queue
+type OutputBranch struct {
+	Output OutputContext
+	// QueueSize is the number of measures queued for the branch, zero means PipeBufferSize
+	QueueSize int
+	// Overflow is the policy applied when the queue is full
+	Overflow OverflowPolicy
+	// Spill configures the disk buffer of an OverflowSpill branch
+	Spill DiskBufferOptions
+}
+
+// NewFanOutOutputContext returns an OutputContext copying every measure to the queue of each branch.
+// A branch falling behind fills its own queue and its overflow policy decides whether it holds back
+// the other branches or loses measures. The measures dropped by each branch are reported to the Feedback
+// periodically and at the end of the run. NewMergedOutputContext is a fan-out of blocking branches
+func NewFanOutOutputContext(branches ...OutputBranch) (OutputContext, error) {
+	if len(branches) == 0 {
+		return nil, errors.New("Fan out to an empty list does nothing")
+	}
+	return newFanOutOutputContext(ChanMeasurePipe, branches...)
 }
 
 // NewFilteredOutput composes a Input and a Filter and returns an Input function
@@ -31,6 +99,23 @@ func NewFilteredOutput(flt Filter, out Output) (Output, error) {
 	}, nil
 }
 
+// NewFilteredOutputContext composes a FilterContext and an OutputContext and returns an OutputContext
+func NewFilteredOutputContext(flt FilterContext, out OutputContext) (OutputContext, error) {
+	if flt == nil {
+		return nil, errors.New("filter function is nil")
+	}
+	if out == nil {
+		return nil, errors.New("output function is nil")
+	}
+	return func(ctx context.Context, f Feedback, r MeasureReader) error {
+		rflt, err := flt(ctx, f, r)
+		if err != nil {
+			return err
+		}
+		return out(ctx, f, rflt)
+	}, nil
+}
+
 // NewComposedOutput is
 func NewComposedOutput(dec Decoder, snk Sinker) (Output, error) {
 	if dec == nil {
@@ -48,12 +133,62 @@ func NewComposedOutput(dec Decoder, snk Sinker) (Output, error) {
 	}, nil
 }
 
+// NewComposedOutputContext composes a DecoderContext and a SinkerContext and returns an OutputContext.
+// Behind a disk buffer, the measures of the stream are committed once the sinker accepted it
+func NewComposedOutputContext(dec DecoderContext, snk SinkerContext) (OutputContext, error) {
+	if dec == nil {
+		return nil, errors.New("decoder is nil")
+	}
+	if snk == nil {
+		return nil, errors.New("sinker is nil")
+	}
+	return func(ctx context.Context, f Feedback, r MeasureReader) error {
+		d := deliveryOf(ctx, r)
+		cr := &countingReader{r: r}
+		if d != nil {
+			d.track()
+			r = cr
+		}
+		rdec, err := dec(ctx, f, r)
+		if err != nil {
+			return err
+		}
+		err = snk(ctx, f, rdec)
+		d.report(cr.count(), err)
+		return err
+	}, nil
+}
+
 // NewOutput is
 func NewOutput(handler func(m Measure) error) (Output, error) {
 	if handler == nil {
 		return nil, errors.New("handler is nil")
 	}
+	out, err := NewOutputContext(func(ctx context.Context, m Measure) error {
+		return handler(m)
+	})
+	if err != nil {
+		return nil, err
+	}
 	return func(f Feedback, r MeasureReader) error {
+		return out(context.Background(), f, r)
+	}, nil
+}
+
+// NewOutputContext takes a handler called for every measure and returns an OutputContext.
+// The handler receives the output drain context
+func NewOutputContext(handler func(ctx context.Context, m Measure) error) (OutputContext, error) {
+	if handler == nil {
+		return nil, errors.New("handler is nil")
+	}
+	return func(ctx context.Context, f Feedback, r MeasureReader) error {
+		if ctx == nil {
+			return errors.New("context is nil")
+		}
+		dctx, cancel := drainContext(ctx)
+		defer cancel()
+		stop := onDone(dctx, func() { closeMeasureReader(r) })
+		defer stop()
 		for {
 			var measure Measure
 			err := r.Read(&measure)
@@ -61,10 +196,13 @@ func NewOutput(handler func(m Measure) error) (Output, error) {
 				if err == io.EOF {
 					break
 				}
+				if aborted(dctx, err) {
+					break
+				}
 				f("output read error- %v", err)
 				continue
 			}
-			err = handler(measure)
+			err = handler(dctx, measure)
 			if err != nil {
 				f("handle output error- %v", err)
 				continue
@@ -74,40 +212,134 @@ func NewOutput(handler func(m Measure) error) (Output, error) {
 	}, nil
 }
 
+// NewPrettyPrintOutput returns an Output that writes every measure as indented json to w,
+// prefixed with label
+func NewPrettyPrintOutput(label string, w io.Writer) (Output, error) {
+	out, err := NewPrettyPrintOutputContext(label, w)
+	if err != nil {
+		return nil, err
+	}
+	return func(f Feedback, r MeasureReader) error {
+		return out(context.Background(), f, r)
+	}, nil
+}
+
+// NewPrettyPrintOutputContext is the OutputContext counterpart of NewPrettyPrintOutput
+func NewPrettyPrintOutputContext(label string, w io.Writer) (OutputContext, error) {
+	if w == nil {
+		return nil, errors.New("writer is nil")
+	}
+	var mu sync.Mutex
+	return NewOutputContext(func(ctx context.Context, m Measure) error {
+		b, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_, err = fmt.Fprintf(w, "%v: %s\n", label, b)
+		return err
+	})
+}
+
 // newMergedOutput takes a list of outputs and returns a single output function
 func newMergedOutput(
-	ioPipe func() (*io.PipeReader, *io.PipeWriter),
-	mWriter func(io.Writer) MeasureWriter,
-	mReader func(io.Reader) MeasureReader,
+	pipe MeasurePipe,
 	outputs ...Output) (Output, error) {
-	if ioPipe == nil {
-		return nil, errors.New("iopipe function is nil")
-	}
-	if mWriter == nil {
-		return nil, errors.New("mWriter is nil")
+	var ctxOutputs []OutputContext
+	for _, out := range outputs {
+		ctxOutputs = append(ctxOutputs, ContextOutput(out))
 	}
-	if mReader == nil {
-		return nil, errors.New("mReader is nil")
+	out, err := newMergedOutputContext(pipe, ctxOutputs...)
+	if err != nil {
+		return nil, err
 	}
 	return func(f Feedback, r MeasureReader) error {
+		return out(context.Background(), f, r)
+	}, nil
+}
 
-		var mws []MeasureWriter
-		var pws []*io.PipeWriter
+// newMergedOutputContext takes a list of OutputContexts and returns a single OutputContext
+func newMergedOutputContext(
+	pipe MeasurePipe,
+	outputs ...OutputContext) (OutputContext, error) {
+	var branches []OutputBranch
+	for _, out := range outputs {
+		branches = append(branches, OutputBranch{Output: out})
+	}
+	return newFanOutOutputContext(pipe, branches...)
+}
+
+func newFanOutOutputContext(
+	pipe MeasurePipe,
+	branches ...OutputBranch) (OutputContext, error) {
+	if pipe == nil {
+		return nil, errors.New("pipe function is nil")
+	}
+	branches = append([]OutputBranch(nil), branches...)
+	for i, b := range branches {
+		if b.Output == nil {
+			return nil, fmt.Errorf("output %v function is nil", i)
+		}
+		if b.QueueSize < 0 {
+			return nil, fmt.Errorf("output %v queue size is negative", i)
+		}
+		switch b.Overflow {
+		case OverflowBlock, OverflowDropNewest, OverflowDropOldest:
+		case OverflowSpill:
+			out, err := NewDiskBufferedOutputContext(b.Output, b.Spill)
+			if err != nil {
+				return nil, fmt.Errorf("output %v spill: %w", i, err)
+			}
+			branches[i].Output = out
+		default:
+			return nil, fmt.Errorf("output %v overflow policy %v is unknown", i, b.Overflow)
+		}
+	}
+	return func(ctx context.Context, f Feedback, r MeasureReader) error {
+		if ctx == nil {
+			return errors.New("context is nil")
+		}
+		dctx, cancel := drainContext(ctx)
+		defer cancel()
+
+		s := stageTelemetry(ctx, "merged_output")
+		queues := make([]*fanOutQueue, len(branches))
 		var wg sync.WaitGroup
-		for _, out := range outputs {
-			pr, pw := ioPipe()
-			mr := mReader(pr)
-			mws = append(mws, mWriter(pw))
-			pws = append(pws, pw)
-			go func(o Output, mr MeasureReader) {
+		for i, b := range branches {
+			queues[i] = newFanOutQueue(ctx, pipe, b)
+			queues[i].s = s
+			go func(o OutputContext, mr MeasureReader) {
 				defer wg.Done()
-				err := o(f, mr)
+				err := o(ctx, f, mr)
 				if err != nil {
 					f("sink fail %v", err)
 				}
-			}(out, mr)
+			}(b.Output, queues[i].mr)
 			wg.Add(1)
 		}
+		stop := onDone(dctx, func() {
+			for _, q := range queues {
+				q.mw.CloseWithError(context.Cause(dctx))
+			}
+			closeMeasureReader(r)
+		})
+		defer stop()
+		done := make(chan struct{})
+		reported := make(chan struct{})
+		go func() {
+			defer close(reported)
+			ticker := time.NewTicker(reportInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					reportDrops(f, queues)
+				}
+			}
+		}()
 		for {
 			var m Measure
 			err := r.Read(&m)
@@ -115,21 +347,107 @@ func newMergedOutput(
 				if err == io.EOF {
 					break
 				}
+				if aborted(dctx, err) {
+					break
+				}
+				s.addFailed(1)
 				f("error reading message %v", m)
 				continue
 			}
-			for _, mw := range mws {
-				err = mw.Write(m)
+			s.addRead(1)
+			start := time.Now()
+			for _, q := range queues {
+				err = q.write(m)
 				if err != nil {
+					s.addFailed(1)
 					f("fail pushing metric %v", err)
 				}
 			}
+			s.observe(start)
 		}
-		for _, pw := range pws {
-			pw.Close()
+		for _, q := range queues {
+			q.mw.Close()
 		}
 		wg.Wait()
+		close(done)
+		<-reported
+		reportDrops(f, queues)
 		return nil
 	}, nil
 
 }
+
+// fanOutQueue is the bounded queue of a fan-out branch
+type fanOutQueue struct {
+	overflow OverflowPolicy
+	mr       MeasurePipeReader
+	mw       MeasurePipeWriter
+	// cp is the channel of the dropping queues, nil for the blocking ones
+	cp       *chanPipe
+	s        *stageStats
+	dropped  int64
+	reported int64
+}
+
+func newFanOutQueue(ctx context.Context, pipe MeasurePipe, b OutputBranch) *fanOutQueue {
+	q := &fanOutQueue{overflow: b.Overflow}
+	size := b.QueueSize
+	if size == 0 {
+		size = PipeBufferSize(ctx)
+	}
+	if b.Overflow == OverflowDropNewest || b.Overflow == OverflowDropOldest {
+		if size < 1 {
+			// a dropping queue needs a slot, an unbuffered one would drop everything the branch is not waiting for
+			size = 1
+		}
+		mr, mw := NewChanMeasurePipe(size)
+		q.mr, q.mw, q.cp = mr, mw, mw.(*chanPipeWriter).p
+		return q
+	}
+	q.mr, q.mw = pipe(WithPipeBufferSize(ctx, size))
+	return q
+}
+
+// write queues m, applying the overflow policy when the queue is full
+func (q *fanOutQueue) write(m Measure) error {
+	if q.cp == nil {
+		return q.written(q.mw.Write(m))
+	}
+	for {
+		ok, err := q.cp.offer(m)
+		if ok || err != nil {
+			return q.written(err)
+		}
+		if q.overflow == OverflowDropNewest {
+			q.drop()
+			return nil
+		}
+		if q.cp.evict() {
+			q.drop()
+		}
+	}
+}
+
+func (q *fanOutQueue) written(err error) error {
+	if err == nil {
+		q.s.addWritten(1)
+	}
+	return err
+}
+
+func (q *fanOutQueue) drop() {
+	atomic.AddInt64(&q.dropped, 1)
+	q.s.addDropped(1)
+}
+
+// reportDrops sends the measures dropped by every branch since the last report to the Feedback
+func reportDrops(f Feedback, queues []*fanOutQueue) {
+	for i, q := range queues {
+		dropped := atomic.LoadInt64(&q.dropped)
+		if dropped == q.reported {
+			continue
+		}
+		f("output %v dropped %v measures on a full queue, %v in total", i, dropped-q.reported, dropped)
+		q.reported = dropped
+	}
+}
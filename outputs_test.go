@@ -0,0 +1,106 @@
+package mstreamer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewFanOutOutputContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		overflow OverflowPolicy
+		want     []int64
+		dropped  string
+	}{
+		{name: `when stalled branch drops newest then it keeps the first measures`, overflow: OverflowDropNewest, want: []int64{0, 1}, dropped: "output 1 dropped 8 measures"},
+		{name: `when stalled branch drops oldest then it keeps the last measures`, overflow: OverflowDropOldest, want: []int64{8, 9}, dropped: "output 1 dropped 8 measures"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu      sync.Mutex
+				msgs    []string
+				fast    []int64
+				stalled []int64
+			)
+			fastDone := make(chan struct{})
+			read := func(r MeasureReader) []int64 {
+				var vs []int64
+				for {
+					var m Measure
+					if err := r.Read(&m); err != nil {
+						return vs
+					}
+					vs = append(vs, m.Flds[0].Data.(int64))
+				}
+			}
+			out, err := NewFanOutOutputContext(
+				OutputBranch{Output: func(ctx context.Context, f Feedback, r MeasureReader) error {
+					defer close(fastDone)
+					fast = read(r)
+					return nil
+				}},
+				OutputBranch{Output: func(ctx context.Context, f Feedback, r MeasureReader) error {
+					// the fast branch ends once every measure is queued
+					<-fastDone
+					stalled = read(r)
+					return nil
+				}, QueueSize: 2, Overflow: tt.overflow},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, w := NewChanMeasurePipe(0)
+			go func() {
+				defer w.Close()
+				for i := 0; i < 10; i++ {
+					w.Write(Measure{Name: "m", Flds: []Field{{"v", TInt, int64(i)}}})
+				}
+			}()
+			f := func(format string, a ...interface{}) {
+				mu.Lock()
+				defer mu.Unlock()
+				msgs = append(msgs, fmt.Sprintf(format, a...))
+			}
+			if err := out(context.Background(), f, r); err != nil {
+				t.Fatal(err)
+			}
+			if len(fast) != 10 {
+				t.Errorf("got %v measures on the fast branch want 10", len(fast))
+			}
+			if !reflect.DeepEqual(stalled, tt.want) {
+				t.Errorf("got %v on the stalled branch want %v", stalled, tt.want)
+			}
+			if !strings.Contains(strings.Join(msgs, "\n"), tt.dropped) {
+				t.Errorf("got feedback %q want %q", msgs, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestPrettyPrintOutputContext(t *testing.T) {
+	if _, err := NewPrettyPrintOutputContext("out", nil); err == nil {
+		t.Errorf("got nil error for a nil writer")
+	}
+	var b strings.Builder
+	out, err := NewPrettyPrintOutputContext("out", &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w := NewChanMeasurePipe(2)
+	w.Write(Measure{Name: "cpu", Tags: []Tag{{"host", "a"}}, Time: 1})
+	w.Write(Measure{Name: "mem", Time: 2})
+	w.Close()
+	if err := out(context.Background(), func(string, ...interface{}) {}, r); err != nil {
+		t.Fatal(err)
+	}
+	want := "out: {\n  \"name\": \"cpu\",\n  \"tags\": [\n    {\n      \"name\": \"host\",\n      \"data\": \"a\"\n    }\n  ],\n" +
+		"  \"flds\": null,\n  \"time\": 1\n}\nout: {\n  \"name\": \"mem\",\n  \"flds\": null,\n  \"time\": 2\n}\n"
+	if b.String() != want {
+		t.Errorf("got %q want measures printed as %q", b.String(), want)
+	}
+}
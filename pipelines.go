@@ -1,6 +1,7 @@
 package mstreamer
 
 import (
+	"context"
 	"errors"
 )
 
@@ -30,3 +31,41 @@ func NewIOPipeline(i Input, f Filter, o Output) (Runnable, error) {
 		return o(fb, rf)
 	}, nil
 }
+
+// NewIOPipelineContext takes one component of each context-aware type and returns a RunnableContext.
+// Cancelling the context ends the input streams, lets the filters and output drain up to the
+// drain timeout and makes the runnable return an error wrapping ErrPipelineCanceled
+func NewIOPipelineContext(i InputContext, f FilterContext, o OutputContext) (RunnableContext, error) {
+	if i == nil {
+		return nil, errors.New("input function is nil")
+	}
+	if f == nil {
+		return nil, errors.New("filter function is nil")
+	}
+	if o == nil {
+		return nil, errors.New("output function is nil")
+	}
+	return func(ctx context.Context, fb Feedback) error {
+		if ctx == nil {
+			return errors.New("context is nil")
+		}
+		if fb == nil {
+			return errors.New("feedback function is nil")
+		}
+		dctx, cancel := drainContext(ctx)
+		defer cancel()
+		ri, err := i(ctx, fb)
+		if err != nil {
+			return err
+		}
+		rf, err := f(ctx, fb, ri)
+		if err != nil {
+			return err
+		}
+		err = o(ctx, fb, rf)
+		if ctx.Err() != nil {
+			return canceledError(ctx, dctx)
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,227 @@
+package mstreamer
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// DefaultPipeBufferSize is the number of measures a channel pipe holds before writers block
+const DefaultPipeBufferSize = 64
+
+// MeasurePipeReader is the read half of a measure pipe
+type MeasurePipeReader interface {
+	MeasureReader
+	io.Closer
+}
+
+// MeasurePipeWriter is the write half of a measure pipe.
+// Close ends the stream with io.EOF once buffered measures are read,
+// CloseWithError aborts it and makes the reader return err right away
+type MeasurePipeWriter interface {
+	MeasureWriter
+	io.Closer
+	CloseWithError(err error) error
+}
+
+// MeasurePipe creates an in-memory pipe connecting two pipeline stages. Writes block once the pipe
+// holds as many measures as its buffer, none for the synchronous GobMeasurePipe
+type MeasurePipe func(context.Context) (MeasurePipeReader, MeasurePipeWriter)
+
+type pipeBufferSizeKey struct{}
+
+// WithPipeBufferSize returns a copy of ctx carrying the buffer size of the channel pipes
+// created between stages
+func WithPipeBufferSize(ctx context.Context, size int) context.Context {
+	return context.WithValue(ctx, pipeBufferSizeKey{}, size)
+}
+
+// PipeBufferSize returns the pipe buffer size carried by ctx or DefaultPipeBufferSize
+func PipeBufferSize(ctx context.Context) int {
+	if size, ok := ctx.Value(pipeBufferSizeKey{}).(int); ok && size >= 0 {
+		return size
+	}
+	return DefaultPipeBufferSize
+}
+
+// ChanMeasurePipe is the MeasurePipe used by default between stages. It passes measures
+// over a typed channel sized by PipeBufferSize(ctx), without any serialization
+func ChanMeasurePipe(ctx context.Context) (MeasurePipeReader, MeasurePipeWriter) {
+	return NewChanMeasurePipe(PipeBufferSize(ctx))
+}
+
+// GobMeasurePipe is a MeasurePipe that serializes measures with encoding/gob over an io.Pipe
+func GobMeasurePipe(ctx context.Context) (MeasurePipeReader, MeasurePipeWriter) {
+	pr, pw := io.Pipe()
+	return NewReader(pr).(*decoder), &gobPipeWriter{MeasureWriter: NewWriter(pw), pw: pw}
+}
+
+// NewChanMeasurePipe creates a measure pipe backed by a channel holding up to size measures.
+// Written measures are copied so reader and writer never share tag or field slices
+func NewChanMeasurePipe(size int) (MeasurePipeReader, MeasurePipeWriter) {
+	if size < 0 {
+		size = 0
+	}
+	p := &chanPipe{
+		ch:    make(chan Measure, size),
+		wdone: make(chan struct{}),
+		rdone: make(chan struct{}),
+	}
+	return &chanPipeReader{p}, &chanPipeWriter{p}
+}
+
+type gobPipeWriter struct {
+	MeasureWriter
+	pw *io.PipeWriter
+}
+
+func (w *gobPipeWriter) Close() error {
+	return w.pw.Close()
+}
+
+func (w *gobPipeWriter) CloseWithError(err error) error {
+	return w.pw.CloseWithError(err)
+}
+
+type chanPipe struct {
+	ch    chan Measure
+	wdone chan struct{}
+	rdone chan struct{}
+	wonce sync.Once
+	ronce sync.Once
+	mu    sync.Mutex
+	werr  error
+}
+
+func (p *chanPipe) writeErr() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.werr
+}
+
+func (p *chanPipe) write(m Measure) error {
+	select {
+	case <-p.rdone:
+		return io.ErrClosedPipe
+	case <-p.wdone:
+		return io.ErrClosedPipe
+	default:
+	}
+	if m.Tags != nil {
+		m.Tags = append([]Tag(nil), m.Tags...)
+	}
+	if m.Flds != nil {
+		m.Flds = append([]Field(nil), m.Flds...)
+	}
+	select {
+	case p.ch <- m:
+		return nil
+	case <-p.rdone:
+		return io.ErrClosedPipe
+	case <-p.wdone:
+		return io.ErrClosedPipe
+	}
+}
+
+// offer writes m unless the channel is full, reporting whether it was taken
+func (p *chanPipe) offer(m Measure) (bool, error) {
+	select {
+	case <-p.rdone:
+		return false, io.ErrClosedPipe
+	case <-p.wdone:
+		return false, io.ErrClosedPipe
+	default:
+	}
+	if m.Tags != nil {
+		m.Tags = append([]Tag(nil), m.Tags...)
+	}
+	if m.Flds != nil {
+		m.Flds = append([]Field(nil), m.Flds...)
+	}
+	select {
+	case p.ch <- m:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// evict drops the oldest buffered measure, reporting whether there was one
+func (p *chanPipe) evict() bool {
+	select {
+	case <-p.ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *chanPipe) read(m *Measure) error {
+	select {
+	case <-p.rdone:
+		return io.ErrClosedPipe
+	default:
+	}
+	if err := p.writeErr(); err != nil {
+		return err
+	}
+	select {
+	case *m = <-p.ch:
+		return nil
+	case <-p.rdone:
+		return io.ErrClosedPipe
+	case <-p.wdone:
+		if err := p.writeErr(); err != nil {
+			return err
+		}
+		select {
+		case *m = <-p.ch:
+			return nil
+		default:
+			return io.EOF
+		}
+	}
+}
+
+func (p *chanPipe) closeWrite(err error) error {
+	p.wonce.Do(func() {
+		p.mu.Lock()
+		p.werr = err
+		p.mu.Unlock()
+		close(p.wdone)
+	})
+	return nil
+}
+
+func (p *chanPipe) closeRead() error {
+	p.ronce.Do(func() { close(p.rdone) })
+	return nil
+}
+
+type chanPipeReader struct {
+	p *chanPipe
+}
+
+func (r *chanPipeReader) Read(m *Measure) error {
+	return r.p.read(m)
+}
+
+func (r *chanPipeReader) Close() error {
+	return r.p.closeRead()
+}
+
+type chanPipeWriter struct {
+	p *chanPipe
+}
+
+func (w *chanPipeWriter) Write(m Measure) error {
+	return w.p.write(m)
+}
+
+func (w *chanPipeWriter) Close() error {
+	return w.p.closeWrite(nil)
+}
+
+func (w *chanPipeWriter) CloseWithError(err error) error {
+	return w.p.closeWrite(err)
+}
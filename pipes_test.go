@@ -0,0 +1,155 @@
+package mstreamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestNewChanMeasurePipe(t *testing.T) {
+	sm := Measure{
+		Name: "sample",
+		Tags: []Tag{{"app", "metrics"}},
+		Flds: []Field{{"refactorings", TInt, int64(4)}},
+		Time: 1257894000000000000,
+	}
+	errAbort := errors.New("abort")
+	tests := []struct {
+		name  string
+		size  int
+		write func(MeasurePipeWriter)
+		want  []Measure
+		err   error
+	}{
+		{
+			name:  `when writer closes then buffered measures are read before EOF`,
+			size:  2,
+			write: func(w MeasurePipeWriter) { w.Write(sm); w.Write(sm); w.Close() },
+			want:  []Measure{sm, sm}, err: io.EOF,
+		},
+		{
+			name:  `when pipe is unbuffered then measures are handed over`,
+			size:  0,
+			write: func(w MeasurePipeWriter) { w.Write(sm); w.Close() },
+			want:  []Measure{sm}, err: io.EOF,
+		},
+		{
+			name:  `when writer aborts then reader gets the abort error`,
+			size:  2,
+			write: func(w MeasurePipeWriter) { w.Write(sm); w.CloseWithError(errAbort) },
+			err:   errAbort,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, w := NewChanMeasurePipe(tt.size)
+			go tt.write(w)
+			var got []Measure
+			var err error
+			for {
+				var m Measure
+				if err = r.Read(&m); err != nil {
+					break
+				}
+				got = append(got, m)
+			}
+			if err != tt.err {
+				t.Errorf("got error %v want %v", err, tt.err)
+			}
+			if tt.err == io.EOF && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run(`when reader closes then writer fails`, func(t *testing.T) {
+		r, w := NewChanMeasurePipe(0)
+		r.Close()
+		if err := w.Write(sm); err != io.ErrClosedPipe {
+			t.Errorf("got error %v want %v", err, io.ErrClosedPipe)
+		}
+	})
+	t.Run(`when a measure is written then reader does not share its slices`, func(t *testing.T) {
+		r, w := NewChanMeasurePipe(1)
+		m := Measure{Name: "sample", Tags: []Tag{{"app", "metrics"}}}
+		w.Write(m)
+		m.Tags[0].Data = "changed"
+		var got Measure
+		r.Read(&got)
+		if got.Tags[0].Data != "metrics" {
+			t.Errorf("got tag %v want metrics", got.Tags[0].Data)
+		}
+	})
+}
+
+func benchmarkFilterChain(b *testing.B, pipe MeasurePipe, filters int) {
+	m := Measure{
+		Name: "sample",
+		Tags: []Tag{{"app", "metrics"}, {"host", "localhost"}},
+		Flds: []Field{{"value", TFloat, 1.0}, {"count", TUint, uint64(1)}},
+		Time: 1257894000000000000,
+	}
+	n := b.N
+	inp, err := newInputFromProducerContext(func(ctx context.Context, f Feedback, w MeasureWriter) {
+		for i := 0; i < n; i++ {
+			w.Write(m)
+		}
+	}, pipe)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var flts []FilterContext
+	for i := 0; i < filters; i++ {
+		flt, err := newFilterContext(func(ctx context.Context, f Feedback, m *Measure, mw MeasureWriter) {
+			mw.Write(*m)
+		}, nil, pipe)
+		if err != nil {
+			b.Fatal(err)
+		}
+		flts = append(flts, flt)
+	}
+	flt, err := NewComposedFilterContext(flts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	count := 0
+	out, err := NewOutputContext(func(ctx context.Context, m Measure) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	p, err := NewIOPipelineContext(inp, flt, out)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	if err := p(context.Background(), b.Logf); err != nil {
+		b.Fatal(err)
+	}
+	if count != n {
+		b.Fatalf("got %v measures want %v", count, n)
+	}
+}
+
+func BenchmarkFilterChain(b *testing.B) {
+	pipes := []struct {
+		name string
+		pipe MeasurePipe
+	}{
+		{"chan", ChanMeasurePipe},
+		{"gob", GobMeasurePipe},
+	}
+	for _, p := range pipes {
+		for _, filters := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("%s/filters=%d", p.name, filters), func(b *testing.B) {
+				benchmarkFilterChain(b, p.pipe, filters)
+			})
+		}
+	}
+}
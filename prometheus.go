@@ -0,0 +1,392 @@
+package mstreamer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PromTypeTag is the tag holding the metric type (counter, gauge, histogram, summary, ...) of the
+	// measures built by the Prometheus encoder. Prometheus reserves labels starting with __, so it never
+	// clashes with a scraped label
+	PromTypeTag = "__type__"
+	// PromUnitTag is the tag holding the OpenMetrics unit of the metric family, when one is declared
+	PromUnitTag = "__unit__"
+)
+
+// NewPrometheusEncoder returns an Encoder that parses the Prometheus text exposition format.
+// See NewOpenMetricsEncoder for how samples are grouped into measures
+func NewPrometheusEncoder() (Encoder, error) {
+	return NewEncoder(promEncoderAdapter(false))
+}
+
+// NewOpenMetricsEncoder returns an Encoder that parses the OpenMetrics text format.
+//
+// Samples of the same metric family and label set are grouped in a single measure named after the family,
+// tagged with its labels and PromTypeTag. Fields are named after the role of the sample:
+// counter and created for counters, gauge for gauges, value for unknown and untyped metrics,
+// sum, count, created and bucket_<le> for histograms, sum, count, created and quantile_<q> for summaries,
+// info for info metrics and state for statesets. An exemplar adds a <field>_exemplar field with its value
+// and a <field>_exemplar_<label> field per label. HELP comments and NaN samples are dropped and samples
+// without timestamp get the time the stream started to be read
+func NewOpenMetricsEncoder() (Encoder, error) {
+	return NewEncoder(promEncoderAdapter(true))
+}
+
+func promEncoderAdapter(openMetrics bool) EncoderAdapter {
+	return func(f Feedback, r io.Reader, w MeasureWriter) {
+		p := newPromParser(openMetrics, time.Now().UnixNano())
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		n := 0
+		for sc.Scan() {
+			n++
+			m, eof, err := p.line(bytes.TrimSpace(sc.Bytes()))
+			if err != nil {
+				f("prometheus parse error at line %v: %v", n, err)
+				continue
+			}
+			if m != nil {
+				if err := w.Write(*m); err != nil {
+					f("prometheus write error: %v", err)
+					return
+				}
+			}
+			if eof {
+				break
+			}
+		}
+		if err := sc.Err(); err != nil {
+			f("prometheus read error: %v", err)
+		}
+		if m := p.flush(); m != nil {
+			if err := w.Write(*m); err != nil {
+				f("prometheus write error: %v", err)
+			}
+		}
+	}
+}
+
+type promFamily struct {
+	typ  string
+	unit string
+}
+
+// promParser turns exposition lines into measures, keeping the measure being grouped until a sample of
+// another family or label set arrives
+type promParser struct {
+	openMetrics bool
+	now         int64
+	families    map[string]*promFamily
+	key         string
+	current     *Measure
+}
+
+func newPromParser(openMetrics bool, now int64) *promParser {
+	return &promParser{openMetrics: openMetrics, now: now, families: make(map[string]*promFamily)}
+}
+
+// line parses a single line, returning the measure completed by it, if any, and whether the stream ended
+func (p *promParser) line(line []byte) (*Measure, bool, error) {
+	if len(line) == 0 {
+		return nil, false, nil
+	}
+	if line[0] == '#' {
+		return p.comment(string(line))
+	}
+	s, err := parsePromSample(line, p.openMetrics)
+	if err != nil {
+		return nil, false, err
+	}
+	if math.IsNaN(s.value) {
+		return nil, false, nil
+	}
+	family, typ, suffix := p.family(s.name)
+	field, tags, err := promField(typ, suffix, s.labels)
+	if err != nil {
+		return nil, false, fmt.Errorf("sample %v: %w", s.name, err)
+	}
+	if fam := p.families[family]; fam != nil && fam.unit != "" {
+		tags = append(tags, MakeTag(PromUnitTag, fam.unit))
+	}
+	tags = append(tags, MakeTag(PromTypeTag, typ))
+
+	var done *Measure
+	key := promKey(family, tags)
+	if p.current == nil || key != p.key {
+		done = p.flush()
+		t := p.now
+		if s.hasTime {
+			t = s.time
+		}
+		p.key = key
+		p.current = &Measure{Name: family, Tags: tags, Time: t}
+	}
+	p.current.Flds = append(p.current.Flds, Field{Name: field, Type: TFloat, Data: s.value})
+	if s.exemplar != nil {
+		p.current.Flds = append(p.current.Flds, Field{Name: field + "_exemplar", Type: TFloat, Data: s.exemplar.value})
+		for _, l := range s.exemplar.labels {
+			p.current.Flds = append(p.current.Flds, Field{Name: field + "_exemplar_" + l.Name, Type: TString, Data: l.Data})
+		}
+	}
+	return done, false, nil
+}
+
+// flush returns the measure being grouped, if any
+func (p *promParser) flush() *Measure {
+	m := p.current
+	p.current = nil
+	p.key = ""
+	return m
+}
+
+func (p *promParser) comment(line string) (*Measure, bool, error) {
+	parts := strings.Fields(line[1:])
+	if len(parts) == 1 && parts[0] == "EOF" && p.openMetrics {
+		return p.flush(), true, nil
+	}
+	if len(parts) < 3 {
+		return nil, false, nil
+	}
+	switch parts[0] {
+	case "TYPE":
+		typ := strings.ToLower(parts[2])
+		if !promTypes[typ] {
+			return nil, false, fmt.Errorf("unknown metric type %q", parts[2])
+		}
+		p.familyOf(parts[1]).typ = typ
+	case "UNIT":
+		p.familyOf(parts[1]).unit = parts[2]
+	}
+	// HELP and free comments carry nothing a measure can hold
+	return nil, false, nil
+}
+
+func (p *promParser) familyOf(name string) *promFamily {
+	fam, ok := p.families[name]
+	if !ok {
+		fam = &promFamily{}
+		p.families[name] = fam
+	}
+	return fam
+}
+
+var promTypes = map[string]bool{
+	"counter": true, "gauge": true, "histogram": true, "gaugehistogram": true, "summary": true,
+	"info": true, "stateset": true, "untyped": true, "unknown": true,
+}
+
+var promSuffixes = []string{"_total", "_created", "_bucket", "_sum", "_count", "_gsum", "_gcount", "_info"}
+
+// family returns the family a sample belongs to, its type and the suffix the sample adds to the family name
+func (p *promParser) family(name string) (string, string, string) {
+	if fam, ok := p.families[name]; ok && fam.typ != "" {
+		return name, fam.typ, ""
+	}
+	for _, suffix := range promSuffixes {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		if fam, ok := p.families[strings.TrimSuffix(name, suffix)]; ok && fam.typ != "" {
+			return strings.TrimSuffix(name, suffix), fam.typ, suffix
+		}
+	}
+	return name, "untyped", ""
+}
+
+// promField returns the field name of a sample and the labels that identify its measure
+func promField(typ, suffix string, labels []Tag) (string, []Tag, error) {
+	var (
+		field string
+		pick  string
+	)
+	switch {
+	case suffix == "_created":
+		field = "created"
+	case typ == "counter" && (suffix == "" || suffix == "_total"):
+		field = "counter"
+	case typ == "gauge" && suffix == "":
+		field = "gauge"
+	case (typ == "untyped" || typ == "unknown") && suffix == "":
+		field = "value"
+	case typ == "info" && (suffix == "" || suffix == "_info"):
+		field = "info"
+	case typ == "stateset" && suffix == "":
+		field = "state"
+	case (typ == "histogram" || typ == "gaugehistogram") && suffix == "_bucket":
+		field, pick = "bucket_", "le"
+	case typ == "summary" && suffix == "":
+		field, pick = "quantile_", "quantile"
+	case (typ == "histogram" || typ == "summary") && (suffix == "_sum" || suffix == "_count"),
+		typ == "gaugehistogram" && (suffix == "_gsum" || suffix == "_gcount"):
+		field = strings.TrimPrefix(strings.TrimPrefix(suffix, "_"), "g")
+	default:
+		return "", nil, fmt.Errorf("unexpected sample suffix %q for a %v", suffix, typ)
+	}
+	if pick == "" {
+		return field, labels, nil
+	}
+	tags := make([]Tag, 0, len(labels))
+	found := false
+	for _, l := range labels {
+		if l.Name == pick && !found {
+			field += l.Data
+			found = true
+			continue
+		}
+		tags = append(tags, l)
+	}
+	if !found {
+		return "", nil, fmt.Errorf("missing %v label", pick)
+	}
+	return field, tags, nil
+}
+
+func promKey(family string, tags []Tag) string {
+	var b strings.Builder
+	b.WriteString(family)
+	for _, t := range tags {
+		b.WriteByte(0xff)
+		b.WriteString(t.Name)
+		b.WriteByte(0xfe)
+		b.WriteString(t.Data)
+	}
+	return b.String()
+}
+
+type promExemplar struct {
+	labels []Tag
+	value  float64
+}
+
+type promSample struct {
+	name     string
+	labels   []Tag
+	value    float64
+	time     int64
+	hasTime  bool
+	exemplar *promExemplar
+}
+
+// parsePromSample parses name{labels} value [timestamp] [# {labels} value [timestamp]].
+// Timestamps are milliseconds in the Prometheus format and seconds in OpenMetrics
+func parsePromSample(line []byte, openMetrics bool) (promSample, error) {
+	var s promSample
+	p := &lineParser{buf: line}
+	s.name = p.token(nil, "{ ")
+	if s.name == "" {
+		return s, errors.New("missing metric name")
+	}
+	labels, err := parsePromLabels(p)
+	if err != nil {
+		return s, err
+	}
+	s.labels = labels
+	p.spaces()
+	if s.value, err = parsePromFloat(p.token(nil, " ")); err != nil {
+		return s, err
+	}
+	p.spaces()
+	if p.pos < len(p.buf) && p.buf[p.pos] != '#' {
+		raw := p.token(nil, " ")
+		if openMetrics {
+			sec, err := parsePromFloat(raw)
+			if err != nil {
+				return s, fmt.Errorf("invalid timestamp %q", raw)
+			}
+			s.time = int64(sec * float64(time.Second))
+		} else {
+			ms, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return s, fmt.Errorf("invalid timestamp %q", raw)
+			}
+			s.time = ms * int64(time.Millisecond)
+		}
+		s.hasTime = true
+		p.spaces()
+	}
+	if p.expect('#') {
+		p.spaces()
+		labels, err := parsePromLabels(p)
+		if err != nil {
+			return s, fmt.Errorf("exemplar: %w", err)
+		}
+		p.spaces()
+		value, err := parsePromFloat(p.token(nil, " "))
+		if err != nil {
+			return s, fmt.Errorf("exemplar: %w", err)
+		}
+		s.exemplar = &promExemplar{labels: labels, value: value}
+		// the exemplar timestamp is not kept
+		p.spaces()
+		p.token(nil, " ")
+	}
+	if p.pos < len(p.buf) {
+		return s, fmt.Errorf("unexpected %q at column %v", p.buf[p.pos:], p.pos)
+	}
+	return s, nil
+}
+
+func parsePromLabels(p *lineParser) ([]Tag, error) {
+	if !p.expect('{') {
+		return nil, nil
+	}
+	var labels []Tag
+	for {
+		p.spaces()
+		if p.expect('}') {
+			return labels, nil
+		}
+		name := strings.TrimSpace(p.token(nil, "=}"))
+		if name == "" || !p.expect('=') {
+			return nil, fmt.Errorf("invalid label at column %v", p.pos)
+		}
+		p.spaces()
+		if !p.expect('"') {
+			return nil, fmt.Errorf("label %v value is not quoted", name)
+		}
+		var value []byte
+		for {
+			if p.pos >= len(p.buf) {
+				return nil, fmt.Errorf("unterminated value of label %v", name)
+			}
+			c := p.buf[p.pos]
+			p.pos++
+			if c == '"' {
+				break
+			}
+			if c == '\\' && p.pos < len(p.buf) {
+				c = p.buf[p.pos]
+				p.pos++
+				if c == 'n' {
+					c = '\n'
+				}
+			}
+			value = append(value, c)
+		}
+		labels = append(labels, MakeTag(name, string(value)))
+		p.spaces()
+		if !p.expect(',') {
+			p.spaces()
+			if !p.expect('}') {
+				return nil, fmt.Errorf("invalid label set at column %v", p.pos)
+			}
+			return labels, nil
+		}
+	}
+}
+
+func parsePromFloat(raw string) (float64, error) {
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", raw)
+	}
+	return v, nil
+}
@@ -0,0 +1,129 @@
+package mstreamer
+
+import (
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readPromMeasures(t *testing.T, enc Encoder, in string) ([]Measure, []string) {
+	var errs []string
+	f := func(format string, a ...interface{}) { errs = append(errs, format) }
+	mr, err := enc(f, ioutil.NopCloser(strings.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ms []Measure
+	for {
+		var m Measure
+		if err := mr.Read(&m); err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			return ms, errs
+		}
+		ms = append(ms, m)
+	}
+}
+
+func TestPrometheusEncoder(t *testing.T) {
+	in := `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# A free comment
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9 1
+metric_without_timestamp_and_labels 12.47 5
+
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.05"} 24054 7
+http_request_duration_seconds_bucket{le="+Inf"} 144320 7
+http_request_duration_seconds_sum 53423 7
+http_request_duration_seconds_count 144320 7
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773 8
+rpc_duration_seconds{quantile="0.99"} NaN 8
+rpc_duration_seconds_sum 1.7560473e+07 8
+rpc_duration_seconds_count 2693 8
+bad{label=unquoted} 1
+`
+	enc, err := NewPrometheusEncoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, errs := readPromMeasures(t, enc, in)
+	want := []Measure{
+		{Name: "http_requests_total", Tags: []Tag{{"method", "post"}, {"code", "200"}, {PromTypeTag, "counter"}},
+			Flds: []Field{{"counter", TFloat, 1027.0}}, Time: 1395066363000000000},
+		{Name: "http_requests_total", Tags: []Tag{{"method", "post"}, {"code", "400"}, {PromTypeTag, "counter"}},
+			Flds: []Field{{"counter", TFloat, 3.0}}, Time: 1395066363000000000},
+		{Name: "msdos_file_access_time_seconds", Tags: []Tag{{"path", `C:\DIR\FILE.TXT`}, {"error", "Cannot find file:\n\"FILE.TXT\""}, {PromTypeTag, "untyped"}},
+			Flds: []Field{{"value", TFloat, 1.458255915e9}}, Time: 1000000},
+		{Name: "metric_without_timestamp_and_labels", Tags: []Tag{{PromTypeTag, "untyped"}},
+			Flds: []Field{{"value", TFloat, 12.47}}, Time: 5000000},
+		{Name: "http_request_duration_seconds", Tags: []Tag{{PromTypeTag, "histogram"}},
+			Flds: []Field{{"bucket_0.05", TFloat, 24054.0}, {"bucket_+Inf", TFloat, 144320.0}, {"sum", TFloat, 53423.0}, {"count", TFloat, 144320.0}}, Time: 7000000},
+		{Name: "rpc_duration_seconds", Tags: []Tag{{PromTypeTag, "summary"}},
+			Flds: []Field{{"quantile_0.5", TFloat, 4773.0}, {"sum", TFloat, 1.7560473e+07}, {"count", TFloat, 2693.0}}, Time: 8000000},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", got, want)
+	}
+	if len(errs) != 1 {
+		t.Errorf("got %v feedback messages want 1: %v", len(errs), errs)
+	}
+}
+
+func TestOpenMetricsEncoder(t *testing.T) {
+	in := `# TYPE acme_http_router_request_seconds summary
+# UNIT acme_http_router_request_seconds seconds
+# HELP acme_http_router_request_seconds Latency though all of ACME's HTTP request router.
+acme_http_router_request_seconds_sum{path="/api/v1",method="GET"} 9036.32 1.5
+acme_http_router_request_seconds_count{path="/api/v1",method="GET"} 807283.0 1.5
+acme_http_router_request_seconds_created{path="/api/v1",method="GET"} 1605281325.0 1.5
+# TYPE go_goroutines gauge
+go_goroutines 69 2
+# TYPE process_cpu_seconds counter
+process_cpu_seconds_total 4.20072246e+06 2
+# TYPE build info
+build_info{version="1.0"} 1 2
+# TYPE foo histogram
+foo_bucket{le="0.01"} 0 3
+foo_bucket{le="0.1"} 8 3 # {trace_id="KOO5S4vxi0o"} 0.67
+foo_bucket{le="+Inf"} 17 3
+foo_count 17 3
+foo_sum 324789.3 3
+# EOF
+ignored_after_eof 1
+`
+	enc, err := NewOpenMetricsEncoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, errs := readPromMeasures(t, enc, in)
+	want := []Measure{
+		{Name: "acme_http_router_request_seconds", Tags: []Tag{{"path", "/api/v1"}, {"method", "GET"}, {PromUnitTag, "seconds"}, {PromTypeTag, "summary"}},
+			Flds: []Field{{"sum", TFloat, 9036.32}, {"count", TFloat, 807283.0}, {"created", TFloat, 1605281325.0}}, Time: 1500000000},
+		{Name: "go_goroutines", Tags: []Tag{{PromTypeTag, "gauge"}},
+			Flds: []Field{{"gauge", TFloat, 69.0}}, Time: 2000000000},
+		{Name: "process_cpu_seconds", Tags: []Tag{{PromTypeTag, "counter"}},
+			Flds: []Field{{"counter", TFloat, 4.20072246e+06}}, Time: 2000000000},
+		{Name: "build", Tags: []Tag{{"version", "1.0"}, {PromTypeTag, "info"}},
+			Flds: []Field{{"info", TFloat, 1.0}}, Time: 2000000000},
+		{Name: "foo", Tags: []Tag{{PromTypeTag, "histogram"}},
+			Flds: []Field{
+				{"bucket_0.01", TFloat, 0.0}, {"bucket_0.1", TFloat, 8.0},
+				{"bucket_0.1_exemplar", TFloat, 0.67}, {"bucket_0.1_exemplar_trace_id", TString, "KOO5S4vxi0o"},
+				{"bucket_+Inf", TFloat, 17.0}, {"count", TFloat, 17.0}, {"sum", TFloat, 324789.3},
+			}, Time: 3000000000},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", got, want)
+	}
+	if len(errs) != 0 {
+		t.Errorf("got feedback messages %v", errs)
+	}
+}
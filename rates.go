@@ -0,0 +1,236 @@
+package mstreamer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateMode selects what a rate filter computes out of two consecutive samples of a series
+type RateMode int
+
+const (
+	//RateModeRate is the per-second increase of a counter
+	RateModeRate RateMode = iota
+	//RateModeDelta is the increase of a counter
+	RateModeDelta
+	//RateModeDerivative is the per-second change of a gauge, negative when it decreases
+	RateModeDerivative
+)
+
+// ParseRateMode parses rate, delta or derivative
+func ParseRateMode(s string) (RateMode, error) {
+	switch s {
+	case "", "rate":
+		return RateModeRate, nil
+	case "delta":
+		return RateModeDelta, nil
+	case "derivative":
+		return RateModeDerivative, nil
+	default:
+		return 0, fmt.Errorf("unknown rate mode %q", s)
+	}
+}
+
+func (m RateMode) String() string {
+	switch m {
+	case RateModeDelta:
+		return "delta"
+	case RateModeDerivative:
+		return "derivative"
+	default:
+		return "rate"
+	}
+}
+
+// RateOptions configures a rate filter
+type RateOptions struct {
+	Mode RateMode
+	// Fields are the fields computed. When empty every numeric field is, except for measures typed by
+	// PromTypeTag: in rate and delta modes only counters, histograms and summaries are, without quantiles
+	Fields []string
+	// Suffix is appended to a field name to name its computed field, _<mode> by default
+	Suffix string
+	// Wrap is the width, 32 or 64 bits, of counters that wrap around. A counter decreasing from above half
+	// of its range wrapped around, any other decrease is a reset and the new value counts as the increase.
+	// Without Wrap every decrease is a reset
+	Wrap int
+	// TTL forgets the series not seen for that long, zero keeps them forever
+	TTL time.Duration
+	// EmitFirst passes on the first sample of a series without computed fields instead of dropping it
+	EmitFirst bool
+}
+
+// Validate checks the wrap width and the TTL
+func (o RateOptions) Validate() error {
+	if o.Wrap != 0 && o.Wrap != 32 && o.Wrap != 64 {
+		return fmt.Errorf("wrap must be 32 or 64 bits, got %v", o.Wrap)
+	}
+	if o.TTL < 0 {
+		return errors.New("ttl must not be negative")
+	}
+	return nil
+}
+
+// NewRateFilter returns a Filter remembering the previous sample of every series, a measure name and its
+// tags, and adding the computed field of every field it had a previous value for. Samples that are not
+// newer than the previous one of their series get no computed field.
+// The state outlives runs, so rates of a scheduled pipeline span consecutive scrapes
+func NewRateFilter(opts RateOptions) (Filter, error) {
+	return newRateFilter(opts, time.Now)
+}
+
+type rateSample struct {
+	value Field
+	time  int64
+}
+
+type rateSeries struct {
+	samples map[string]rateSample
+	seen    time.Time
+}
+
+func newRateFilter(opts RateOptions, now func() time.Time) (Filter, error) {
+	return adaptedFilter(rateAdapter(opts, now))
+}
+
+func rateAdapter(opts RateOptions, now func() time.Time) (FilterAdapter, FinalizeAdapter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if opts.Suffix == "" {
+		opts.Suffix = "_" + opts.Mode.String()
+	}
+	wanted := make(map[string]bool)
+	for _, name := range opts.Fields {
+		wanted[name] = true
+	}
+	var (
+		mu     sync.Mutex
+		series = make(map[string]*rateSeries)
+		swept  = now()
+	)
+	return func(f Feedback, m *Measure, mw MeasureWriter) {
+		if !rateApplies(opts.Mode, m) {
+			mw.Write(*m)
+			return
+		}
+		mu.Lock()
+		t := now()
+		if opts.TTL > 0 && t.Sub(swept) >= opts.TTL {
+			for key, s := range series {
+				if t.Sub(s.seen) > opts.TTL {
+					delete(series, key)
+				}
+			}
+			swept = t
+		}
+		key := seriesKey(m.Name, m.Tags)
+		s, known := series[key]
+		if !known {
+			s = &rateSeries{samples: make(map[string]rateSample)}
+			series[key] = s
+		}
+		s.seen = t
+		var computed []Field
+		for _, fld := range m.Flds {
+			if !rateField(opts, wanted, m, fld) {
+				continue
+			}
+			prev, ok := s.samples[fld.Name]
+			if ok && m.Time <= prev.time {
+				f.Debug("rate sample is not newer than the previous one", "measure", m.Name, "field", fld.Name)
+				continue
+			}
+			s.samples[fld.Name] = rateSample{value: fld, time: m.Time}
+			if !ok {
+				continue
+			}
+			v := opts.compute(prev, rateSample{value: fld, time: m.Time})
+			computed = append(computed, Field{Name: fld.Name + opts.Suffix, Type: TFloat, Data: v})
+		}
+		mu.Unlock()
+		if !known && !opts.EmitFirst {
+			return
+		}
+		m.Flds = append(m.Flds, computed...)
+		mw.Write(*m)
+	}, nil, nil
+}
+
+// rateApplies tells whether the type of a Prometheus measure holds counters
+func rateApplies(mode RateMode, m *Measure) bool {
+	typ, err := m.TagValue(PromTypeTag)
+	if err != nil || mode == RateModeDerivative {
+		return true
+	}
+	return typ == "counter" || typ == "histogram" || typ == "summary"
+}
+
+func rateField(opts RateOptions, wanted map[string]bool, m *Measure, fld Field) bool {
+	switch fld.Data.(type) {
+	case float64, int64, uint64:
+	default:
+		return false
+	}
+	if len(wanted) > 0 {
+		return wanted[fld.Name]
+	}
+	if _, err := m.TagValue(PromTypeTag); err == nil {
+		return fld.Name != "created" && !strings.Contains(fld.Name, "_exemplar") &&
+			(opts.Mode == RateModeDerivative || !strings.HasPrefix(fld.Name, "quantile_"))
+	}
+	return true
+}
+
+func (o RateOptions) compute(prev, cur rateSample) float64 {
+	var delta float64
+	if o.Mode == RateModeDerivative {
+		delta = toFloat(valueOf(cur.value)) - toFloat(valueOf(prev.value))
+	} else {
+		delta = o.increase(prev.value, cur.value)
+	}
+	if o.Mode == RateModeDelta {
+		return delta
+	}
+	return delta / (float64(cur.time-prev.time) / float64(time.Second))
+}
+
+// increase returns the increase of a counter between two samples, accounting for resets and wraparounds
+func (o RateOptions) increase(prev, cur Field) float64 {
+	p, pok := prev.Data.(uint64)
+	c, cok := cur.Data.(uint64)
+	if pok && cok {
+		switch {
+		case c >= p:
+			return float64(c - p)
+		case o.Wrap == 64 && p > math.MaxUint64/2:
+			return float64(c - p)
+		case o.Wrap == 32 && p > math.MaxUint32/2 && p <= math.MaxUint32:
+			return float64((c - p) & math.MaxUint32)
+		default:
+			return float64(c)
+		}
+	}
+	pf, cf := toFloat(valueOf(prev)), toFloat(valueOf(cur))
+	if cf >= pf {
+		return cf - pf
+	}
+	if o.Wrap > 0 {
+		if limit := math.Pow(2, float64(o.Wrap)); pf > limit/2 {
+			return limit - pf + cf
+		}
+	}
+	return cf
+}
+
+// seriesKey identifies a series whatever the order of its tags
+func seriesKey(name string, tags []Tag) string {
+	sorted := append([]Tag(nil), tags...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return promKey(name, sorted)
+}
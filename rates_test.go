@@ -0,0 +1,138 @@
+package mstreamer
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewRateFilter(t *testing.T) {
+	sec := int64(time.Second)
+	sample := func(v interface{}, at int64, tags ...Tag) Measure {
+		return Measure{Name: "ifc", Tags: tags, Flds: []Field{{"octets", FieldValueType(v), v}}, Time: at * sec}
+	}
+	tests := []struct {
+		name string
+		opts RateOptions
+		in   []Measure
+		want []float64
+		// passed is the number of measures passed on
+		passed int
+	}{
+		{
+			name: `when counter increases then rate is per second and first sample is dropped`,
+			in:   []Measure{sample(uint64(100), 0), sample(uint64(300), 10), sample(uint64(400), 20)},
+			want: []float64{20, 10}, passed: 2,
+		},
+		{
+			name: `when counter decreases then it is a reset`,
+			opts: RateOptions{Mode: RateModeDelta},
+			in:   []Measure{sample(uint64(100), 0), sample(uint64(30), 10)},
+			want: []float64{30}, passed: 1,
+		},
+		{
+			name: `when 32 bits counter decreases from its top half then it wrapped`,
+			opts: RateOptions{Mode: RateModeDelta, Wrap: 32},
+			in:   []Measure{sample(uint64(math.MaxUint32-9), 0), sample(uint64(10), 10)},
+			want: []float64{20}, passed: 1,
+		},
+		{
+			name: `when 32 bits float counter wraps then increase spans the wrap`,
+			opts: RateOptions{Mode: RateModeDelta, Wrap: 32},
+			in:   []Measure{sample(math.Pow(2, 32)-10, 0), sample(float64(10), 10)},
+			want: []float64{20}, passed: 1,
+		},
+		{
+			name: `when gauge decreases then derivative is negative`,
+			opts: RateOptions{Mode: RateModeDerivative},
+			in:   []Measure{sample(int64(50), 0), sample(int64(30), 10)},
+			want: []float64{-2}, passed: 1,
+		},
+		{
+			name: `when series differ then they keep their own state and emit first`,
+			opts: RateOptions{Mode: RateModeDelta, EmitFirst: true},
+			in:   []Measure{sample(uint64(1), 0, Tag{"if", "a"}), sample(uint64(5), 0, Tag{"if", "b"}), sample(uint64(3), 1, Tag{"if", "a"})},
+			want: []float64{2}, passed: 3,
+		},
+		{
+			name:   `when sample is not newer then it gets no rate`,
+			opts:   RateOptions{Mode: RateModeDelta},
+			in:     []Measure{sample(uint64(1), 5), sample(uint64(3), 5)},
+			passed: 1,
+		},
+		{
+			name: `when measure is a prometheus gauge then rate skips it`,
+			in: []Measure{
+				{Name: "temp", Tags: []Tag{{PromTypeTag, "gauge"}}, Flds: []Field{{"gauge", TFloat, 1.0}}, Time: 0},
+				{Name: "temp", Tags: []Tag{{PromTypeTag, "gauge"}}, Flds: []Field{{"gauge", TFloat, 2.0}}, Time: sec},
+			},
+			passed: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flt, err := NewRateFilter(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, w := NewChanMeasurePipe(len(tt.in))
+			for _, m := range tt.in {
+				w.Write(m)
+			}
+			w.Close()
+			fr, err := flt(func(string, ...interface{}) {}, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []float64
+			passed := 0
+			for {
+				var m Measure
+				if err := fr.Read(&m); err != nil {
+					break
+				}
+				passed++
+				for _, f := range m.Flds[1:] {
+					got = append(got, f.Data.(float64))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got computed %v want %v", got, tt.want)
+			}
+			if passed != tt.passed {
+				t.Errorf("got %v measures passed on want %v", passed, tt.passed)
+			}
+		})
+	}
+}
+
+func TestRateFilterExpiry(t *testing.T) {
+	now := time.Unix(0, 0)
+	flt, err := newRateFilter(RateOptions{Mode: RateModeDelta, TTL: time.Minute}, func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(m Measure) int {
+		r, w := NewChanMeasurePipe(1)
+		w.Write(m)
+		w.Close()
+		fr, _ := flt(func(string, ...interface{}) {}, r)
+		n := 0
+		for fr.Read(&m) == nil {
+			n++
+		}
+		return n
+	}
+	m := Measure{Name: "c", Flds: []Field{{"v", TUint, uint64(1)}}, Time: 1}
+	run(m)
+	m.Time = 2
+	if n := run(m); n != 1 {
+		t.Fatalf("got %v measures want the second sample", n)
+	}
+	now = now.Add(2 * time.Minute)
+	m.Time = 3
+	if n := run(m); n != 0 {
+		t.Errorf("got %v measures want the expired series to start over", n)
+	}
+}
@@ -0,0 +1,267 @@
+package mstreamer
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBodySize bounds the request bodies an HTTP receiver reads by default
+const DefaultMaxBodySize = 32 << 20
+
+// HTTPRoute sends the requests matching its path and content type to its encoder
+type HTTPRoute struct {
+	// Path is the request path matched, empty matches any path
+	Path string
+	// ContentType is the media type matched, without parameters, empty matches any
+	ContentType string
+	Encoder     EncoderContext
+}
+
+// HTTPReceiverOptions configures an HTTP receiver
+type HTTPReceiverOptions struct {
+	// Addr is the address listened on, as in :8080
+	Addr string
+	// Listener is served instead of listening on Addr when set. It is closed at the end of the run
+	Listener net.Listener
+	// Routes are tried in order, the first one matching the path and content type of a request gets it
+	Routes []HTTPRoute
+	// User and Password require basic auth, Token requires it as a bearer token. Either is accepted when
+	// both are set, and every request is when none is
+	User     string
+	Password string
+	Token    string
+	// MaxBodySize bounds request bodies once decompressed, DefaultMaxBodySize when zero
+	MaxBodySize int64
+}
+
+// Validate checks the address and the routes
+func (o HTTPReceiverOptions) Validate() error {
+	if o.Addr == "" && o.Listener == nil {
+		return errors.New("receiver address is empty")
+	}
+	if len(o.Routes) == 0 {
+		return errors.New("receiver has no route")
+	}
+	for i, r := range o.Routes {
+		if r.Encoder == nil {
+			return fmt.Errorf("route %v has no encoder", i)
+		}
+	}
+	if o.MaxBodySize < 0 {
+		return errors.New("max body size must not be negative")
+	}
+	return nil
+}
+
+// NewHTTPReceiverInputContext returns an InputContext running an HTTP server that accepts measures
+// POSTed or PUT by clients. Every request body, gzip compressed or not, goes through the encoder of its
+// route and all requests are merged into one stream.
+//
+// A request whose measures all went down the pipeline gets 204. Requests get 400 when the encoder reports
+// an error, the measures read before it were kept, 401 without valid credentials, 404 without a route for
+// their path, 405 for other methods, 413 for bodies above the max size, 415 without a route for their
+// content type or for unknown encodings, and 503 once the pipeline stops taking measures.
+// The server shuts down once the context is done, waiting up to the drain timeout for the requests in flight
+func NewHTTPReceiverInputContext(opts HTTPReceiverOptions) (InputContext, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+	return func(ctx context.Context, f Feedback) (MeasureReader, error) {
+		if ctx == nil {
+			return nil, errors.New("context is nil")
+		}
+		if f == nil {
+			return nil, errors.New("feedback funcion is nil")
+		}
+		ln := opts.Listener
+		if ln == nil {
+			var err error
+			if ln, err = net.Listen("tcp", opts.Addr); err != nil {
+				return nil, err
+			}
+		}
+		mr, mw := ChanMeasurePipe(ctx)
+		srv := &http.Server{
+			Handler:           &httpReceiver{opts: opts, f: f, w: mw},
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		served := make(chan error, 1)
+		go func() { served <- srv.Serve(ln) }()
+		go func() {
+			select {
+			case err := <-served:
+				f.Error(err, "http receiver stopped", "addr", ln.Addr().String())
+				mw.CloseWithError(err)
+			case <-ctx.Done():
+				sctx, cancel := context.WithTimeout(context.Background(), DrainTimeout(ctx))
+				defer cancel()
+				if err := srv.Shutdown(sctx); err != nil {
+					srv.Close()
+					mw.CloseWithError(fmt.Errorf("%w: %w", ErrPipelineCanceled, ErrDrainTimeout))
+					return
+				}
+				mw.Close()
+			}
+		}()
+		return mr, nil
+	}, nil
+}
+
+type httpReceiver struct {
+	opts HTTPReceiverOptions
+	f    Feedback
+	w    MeasureWriter
+}
+
+func (h *httpReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="mstreamer"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	route, status := h.route(r)
+	if route == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	var rd io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "invalid gzip body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		rd = gz
+	default:
+		http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+	limited := &recordingReader{r: io.LimitReader(rd, h.opts.MaxBodySize+1), limit: h.opts.MaxBodySize}
+	var (
+		mu      sync.Mutex
+		failure *Event
+	)
+	rf, _ := NewEventFeedback(func(e Event) {
+		mu.Lock()
+		if e.Level >= LevelError && failure == nil {
+			failure = &e
+		}
+		mu.Unlock()
+		h.f.Emit(e)
+	})
+	mr, err := route.Encoder(r.Context(), rf, io.NopCloser(limited))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n := 0
+	for {
+		var m Measure
+		err := mr.Read(&m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := h.w.Write(m); err != nil {
+			closeMeasureReader(mr)
+			http.Error(w, "pipeline is not taking measures", http.StatusServiceUnavailable)
+			return
+		}
+		n++
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	switch {
+	case limited.exceeded():
+		http.Error(w, fmt.Sprintf("body exceeds %v bytes, %v measures kept", h.opts.MaxBodySize, n), http.StatusRequestEntityTooLarge)
+	case limited.err != nil:
+		http.Error(w, fmt.Sprintf("%v, %v measures kept", limited.err, n), http.StatusBadRequest)
+	case failure != nil:
+		msg := failure.Msg
+		if failure.Err != nil {
+			msg += ": " + failure.Err.Error()
+		}
+		http.Error(w, fmt.Sprintf("%v, %v measures kept", msg, n), http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *httpReceiver) authorized(r *http.Request) bool {
+	if h.opts.User == "" && h.opts.Token == "" {
+		return true
+	}
+	if user, pwd, ok := r.BasicAuth(); ok && h.opts.User != "" {
+		return subtle.ConstantTimeCompare([]byte(user), []byte(h.opts.User)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pwd), []byte(h.opts.Password)) == 1
+	}
+	const bearer = "Bearer "
+	if auth := r.Header.Get("Authorization"); h.opts.Token != "" && len(auth) > len(bearer) && auth[:len(bearer)] == bearer {
+		return subtle.ConstantTimeCompare([]byte(auth[len(bearer):]), []byte(h.opts.Token)) == 1
+	}
+	return false
+}
+
+// route returns the first route matching r, or the status telling why none does
+func (h *httpReceiver) route(r *http.Request) (*HTTPRoute, int) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	status := http.StatusNotFound
+	for i, route := range h.opts.Routes {
+		if route.Path != "" && route.Path != r.URL.Path {
+			continue
+		}
+		status = http.StatusUnsupportedMediaType
+		if route.ContentType == "" || route.ContentType == mediaType {
+			return &h.opts.Routes[i], 0
+		}
+	}
+	return nil, status
+}
+
+// recordingReader keeps the first read error other than io.EOF, and with a limit tells whether more
+// than limit bytes were read
+type recordingReader struct {
+	r     io.Reader
+	limit int64
+	n     int64
+	err   error
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	if r.limit > 0 && r.n > r.limit {
+		return n, errors.New("body too large")
+	}
+	return n, err
+}
+
+func (r *recordingReader) exceeded() bool {
+	return r.limit > 0 && r.n > r.limit
+}
@@ -0,0 +1,264 @@
+package mstreamer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPReceiverInputContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp, _ := NewLineProtocolEncoder("s")
+	rw, _ := NewRemoteWriteEncoder(256)
+	inp, err := NewHTTPReceiverInputContext(HTTPReceiverOptions{
+		Listener: ln,
+		Routes: []HTTPRoute{
+			{Path: "/write", Encoder: ContextEncoder(lp)},
+			{Path: "/api/v1/write", ContentType: "application/x-protobuf", Encoder: ContextEncoder(rw)},
+		},
+		User: "user", Password: "pwd", Token: "secret",
+		MaxBodySize: 256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := inp(ctx, func(string, ...interface{}) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte("gz usage=2 1\n"))
+	gz.Close()
+	url := "http://" + ln.Addr().String()
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		encoding    string
+		auth        func(*http.Request)
+		body        []byte
+		want        int
+	}{
+		{name: `when line protocol is posted then it is accepted`, path: "/write", body: []byte("lp,host=a usage=1 1\n"), want: 204},
+		{name: `when body is gzipped then it is decompressed`, path: "/write", encoding: "gzip", body: gzipped.Bytes(), want: 204},
+		{name: `when remote write is posted then it is accepted`, path: "/api/v1/write", contentType: "application/x-protobuf", body: remoteWriteBody("rw", "job", "api", 3), want: 204},
+		{name: `when line is invalid then it is a bad request`, path: "/write", body: []byte("bad line\n"), want: 400},
+		{name: `when gzip is invalid then it is a bad request`, path: "/write", encoding: "gzip", body: []byte("plain"), want: 400},
+		{name: `when body is too large then it is rejected`, path: "/write", body: []byte(strings.Repeat("big usage=1 1\n", 20)), want: 413},
+		{name: `when method is get then it is not allowed`, method: "GET", path: "/write", want: 405},
+		{name: `when path is unknown then it is not found`, path: "/nowhere", want: 404},
+		{name: `when content type is unexpected then it is unsupported`, path: "/api/v1/write", contentType: "text/plain", want: 415},
+		{name: `when encoding is unknown then it is unsupported`, path: "/write", encoding: "br", want: 415},
+		{name: `when credentials are wrong then it is unauthorized`, path: "/write", auth: func(r *http.Request) { r.SetBasicAuth("user", "bad") }, want: 401},
+		{name: `when bearer token is valid then it is accepted`, path: "/write", auth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, body: []byte("token usage=1 1\n"), want: 204},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = "POST"
+			}
+			req, _ := http.NewRequest(method, url+tt.path, bytes.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			if tt.auth != nil {
+				tt.auth(req)
+			} else {
+				req.SetBasicAuth("user", "pwd")
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("got status %v want %v", resp.StatusCode, tt.want)
+			}
+		})
+	}
+	cancel()
+	got := make(map[string]int)
+	var m Measure
+	for r.Read(&m) == nil {
+		got[m.Name]++
+	}
+	// the lines of the large body read before its limit are kept
+	want := map[string]int{"big": 18, "gz": 1, "lp": 1, "rw": 1, "token": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got measures %v want %v", got, want)
+	}
+}
+
+func TestHTTPReceiverInputConfig(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	cfg, err := ParseConfig([]byte(`{"inputs":[{"input":{"type":"http_receiver","options":{"address":"`+addr+`",
+		"routes":[{"path":"/write","encoder":{"type":"influx","options":{"precision":"s"}}}]}}}],
+		"outputs":[{"decoder":{"type":"json"},"sinker":{"type":"stdout"}}]}`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, opts, cerr := DefaultRegistry.resolve(KindInput, *cfg.Inputs[0].Input)
+	if cerr != nil {
+		t.Fatal(cerr)
+	}
+	v, err := c.build(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := v.(InputContext)(ctx, func(string, ...interface{}) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post("http://"+addr+"/write", "text/plain", strings.NewReader("cpu,host=a usage=1 10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 204 {
+		t.Errorf("got status %v want 204", resp.StatusCode)
+	}
+	var m Measure
+	if err := r.Read(&m); err != nil {
+		t.Fatal(err)
+	}
+	want := Measure{Name: "cpu", Tags: []Tag{{"host", "a"}}, Flds: []Field{{"usage", TFloat, 1.0}}, Time: 10 * int64(time.Second)}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %v want %v", m, want)
+	}
+}
+
+func TestRemoteWriteEncoder(t *testing.T) {
+	enc, _ := NewRemoteWriteEncoder(0)
+	r, err := enc(func(string, ...interface{}) {}, nopReadCloser{bytes.NewReader(remoteWriteBody("up", "job", "node", 1.5))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Measure
+	if err := r.Read(&m); err != nil {
+		t.Fatal(err)
+	}
+	want := Measure{Name: "up", Tags: []Tag{{"job", "node"}}, Flds: []Field{{"value", TFloat, 1.5}}, Time: 1000 * 1e6}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %v want %v", m, want)
+	}
+}
+
+func TestSnappyDecode(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	tests := []struct {
+		name    string
+		src     []byte
+		maxSize int64
+		want    string
+		wantErr bool
+	}{
+		{
+			// literal "abcd" then a copy of length 8 at offset 4, overlapping its own output
+			name: `when a copy has a 1 byte offset then it repeats the output`,
+			src:  []byte{12, 3 << 2, 'a', 'b', 'c', 'd', 1 | 4<<2, 4},
+			want: "abcdabcdabcd",
+		},
+		{
+			name: `when a copy has a 2 byte offset then it repeats the output`,
+			src:  []byte{10, 1 << 2, 'a', 'b', 2 | 7<<2, 2, 0},
+			want: "ababababab",
+		},
+		{
+			name: `when a copy has a 4 byte offset then it repeats the output`,
+			src:  []byte{9, 2 << 2, 'a', 'b', 'c', 3 | 5<<2, 3, 0, 0, 0},
+			want: "abcabcabc",
+		},
+		{
+			name: `when a literal length takes 1 byte then it is read`,
+			src:  append([]byte{100, 60 << 2, 99}, long[:100]...),
+			want: long[:100],
+		},
+		{
+			name: `when a literal length takes 2 bytes then it is read`,
+			src:  append([]byte{0xac, 0x02, 61 << 2, 0x2b, 0x01}, long...),
+			want: long,
+		},
+		{name: `when a literal is truncated then it fails`, src: []byte{12, 3 << 2, 'a'}, wantErr: true},
+		{name: `when the length exceeds the max size then it fails`, src: []byte{0x80, 0x80, 0x80, 0x80, 0x04}, wantErr: true},
+		{name: `when a copy goes past the length then it fails`, src: []byte{6, 1 << 2, 'a', 'b', 1 | 4<<2, 2}, wantErr: true},
+		{name: `when a literal goes past the length then it fails`, src: []byte{1, 1 << 2, 'a', 'b'}, wantErr: true},
+		{name: `when a copy offset is before the output then it fails`, src: []byte{8, 0, 'a', 1 | 4<<2, 2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxSize := tt.maxSize
+			if maxSize == 0 {
+				maxSize = 1024
+			}
+			got, err := snappyDecode(tt.src, maxSize)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v want error %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type nopReadCloser struct{ *bytes.Reader }
+
+func (nopReadCloser) Close() error { return nil }
+
+// remoteWriteBody builds a snappy compressed WriteRequest holding one sample at 1s
+func remoteWriteBody(name, label, value string, v float64) []byte {
+	field := func(b []byte, num int, data []byte) []byte {
+		b = binary.AppendUvarint(b, uint64(num<<3|2))
+		b = binary.AppendUvarint(b, uint64(len(data)))
+		return append(b, data...)
+	}
+	labelOf := func(n, v string) []byte {
+		return field(field(nil, 1, []byte(n)), 2, []byte(v))
+	}
+	sample := binary.AppendUvarint(nil, 1<<3|1)
+	sample = binary.LittleEndian.AppendUint64(sample, math.Float64bits(v))
+	sample = binary.AppendUvarint(sample, 2<<3)
+	sample = binary.AppendUvarint(sample, 1000)
+	series := field(nil, 1, labelOf("__name__", name))
+	series = field(series, 1, labelOf(label, value))
+	series = field(series, 2, sample)
+	req := field(nil, 1, series)
+	// a snappy block made of literals of up to 60 bytes
+	out := binary.AppendUvarint(nil, uint64(len(req)))
+	for len(req) > 0 {
+		n := len(req)
+		if n > 60 {
+			n = 60
+		}
+		out = append(out, byte(n-1)<<2)
+		out = append(out, req[:n]...)
+		req = req[n:]
+	}
+	return out
+}
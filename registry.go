@@ -0,0 +1,336 @@
+package mstreamer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ComponentKind identifies the role of a registered component in a pipeline
+type ComponentKind string
+
+const (
+	//KindSource is a Source component
+	KindSource ComponentKind = "source"
+	//KindEncoder is an Encoder component
+	KindEncoder ComponentKind = "encoder"
+	//KindInput is an Input component used in place of a source and an encoder
+	KindInput ComponentKind = "input"
+	//KindFilter is a Filter component
+	KindFilter ComponentKind = "filter"
+	//KindDecoder is a Decoder component
+	KindDecoder ComponentKind = "decoder"
+	//KindSinker is a Sinker component
+	KindSinker ComponentKind = "sinker"
+	//KindOutput is an Output component used in place of a decoder and a sinker
+	KindOutput ComponentKind = "output"
+)
+
+// Component describes a component registered by name
+// Options is a pointer to the options struct the component is configured with, or nil if it takes none.
+// Options fields are named after their json tag, tagged with `mstreamer:"required"` when mandatory
+// and described by a `doc` tag
+type Component struct {
+	Kind    ComponentKind
+	Name    string
+	Doc     string
+	Options interface{}
+	build   func(options interface{}) (interface{}, error)
+}
+
+// OptionField describes a single option of a component
+type OptionField struct {
+	Name     string
+	Type     string
+	Required bool
+	Doc      string
+}
+
+// OptionsValidator is implemented by options structs that check their values after being decoded
+type OptionsValidator interface {
+	Validate() error
+}
+
+// registryOptions is implemented by options structs referring to other components, which are resolved
+// in the registry the options are decoded from
+type registryOptions interface {
+	useRegistry(r *Registry)
+}
+
+// Duration is a time.Duration that decodes from strings such as "10s" in configuration options
+type Duration time.Duration
+
+// UnmarshalJSON decodes a duration string or a number of nanoseconds
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(value)
+	case string:
+		dur, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(dur)
+	default:
+		return fmt.Errorf("invalid duration %v", v)
+	}
+	return nil
+}
+
+// MarshalJSON encodes a duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Registry holds the components a pipeline configuration can refer to by name
+type Registry struct {
+	mu         sync.RWMutex
+	components map[ComponentKind]map[string]Component
+}
+
+// DefaultRegistry is the registry holding every component shipped with mstreamer
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{components: make(map[ComponentKind]map[string]Component)}
+}
+
+// RegisterSource registers a source under name. build receives a new options value of the same type as options
+func (r *Registry) RegisterSource(name, doc string, options interface{}, build func(options interface{}) (SourceContext, error)) error {
+	if build == nil {
+		return errors.New("build function is nil")
+	}
+	return r.register(KindSource, name, doc, options, func(o interface{}) (interface{}, error) {
+		return build(o)
+	})
+}
+
+// RegisterEncoder registers an encoder under name. build receives a new options value of the same type as options
+func (r *Registry) RegisterEncoder(name, doc string, options interface{}, build func(options interface{}) (EncoderContext, error)) error {
+	if build == nil {
+		return errors.New("build function is nil")
+	}
+	return r.register(KindEncoder, name, doc, options, func(o interface{}) (interface{}, error) {
+		return build(o)
+	})
+}
+
+// RegisterInput registers an input under name. build receives a new options value of the same type as options
+func (r *Registry) RegisterInput(name, doc string, options interface{}, build func(options interface{}) (InputContext, error)) error {
+	if build == nil {
+		return errors.New("build function is nil")
+	}
+	return r.register(KindInput, name, doc, options, func(o interface{}) (interface{}, error) {
+		return build(o)
+	})
+}
+
+// RegisterFilter registers a filter under name. build receives a new options value of the same type as options
+func (r *Registry) RegisterFilter(name, doc string, options interface{}, build func(options interface{}) (FilterContext, error)) error {
+	if build == nil {
+		return errors.New("build function is nil")
+	}
+	return r.register(KindFilter, name, doc, options, func(o interface{}) (interface{}, error) {
+		return build(o)
+	})
+}
+
+// RegisterDecoder registers a decoder under name. build receives a new options value of the same type as options
+func (r *Registry) RegisterDecoder(name, doc string, options interface{}, build func(options interface{}) (DecoderContext, error)) error {
+	if build == nil {
+		return errors.New("build function is nil")
+	}
+	return r.register(KindDecoder, name, doc, options, func(o interface{}) (interface{}, error) {
+		return build(o)
+	})
+}
+
+// RegisterSinker registers a sinker under name. build receives a new options value of the same type as options
+func (r *Registry) RegisterSinker(name, doc string, options interface{}, build func(options interface{}) (SinkerContext, error)) error {
+	if build == nil {
+		return errors.New("build function is nil")
+	}
+	return r.register(KindSinker, name, doc, options, func(o interface{}) (interface{}, error) {
+		return build(o)
+	})
+}
+
+// RegisterOutput registers an output under name. build receives a new options value of the same type as options
+func (r *Registry) RegisterOutput(name, doc string, options interface{}, build func(options interface{}) (OutputContext, error)) error {
+	if build == nil {
+		return errors.New("build function is nil")
+	}
+	return r.register(KindOutput, name, doc, options, func(o interface{}) (interface{}, error) {
+		return build(o)
+	})
+}
+
+func (r *Registry) register(kind ComponentKind, name, doc string, options interface{}, build func(interface{}) (interface{}, error)) error {
+	if name == "" {
+		return errors.New("component name is empty")
+	}
+	if options != nil {
+		t := reflect.TypeOf(options)
+		if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+			return fmt.Errorf("%v %q options must be a pointer to a struct", kind, name)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.components[kind] == nil {
+		r.components[kind] = make(map[string]Component)
+	}
+	if _, ok := r.components[kind][name]; ok {
+		return fmt.Errorf("%v %q is already registered", kind, name)
+	}
+	r.components[kind][name] = Component{Kind: kind, Name: name, Doc: doc, Options: options, build: build}
+	return nil
+}
+
+// Lookup returns the component of the given kind registered under name
+func (r *Registry) Lookup(kind ComponentKind, name string) (Component, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.components[kind][name]
+	return c, ok
+}
+
+// Components returns every registered component sorted by kind and name
+func (r *Registry) Components() []Component {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var cs []Component
+	for _, byName := range r.components {
+		for _, c := range byName {
+			cs = append(cs, c)
+		}
+	}
+	order := map[ComponentKind]int{KindSource: 0, KindEncoder: 1, KindInput: 2, KindFilter: 3, KindDecoder: 4, KindSinker: 5, KindOutput: 6}
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].Kind != cs[j].Kind {
+			return order[cs[i].Kind] < order[cs[j].Kind]
+		}
+		return cs[i].Name < cs[j].Name
+	})
+	return cs
+}
+
+// OptionFields describes the options accepted by the component
+func (c Component) OptionFields() []OptionField {
+	if c.Options == nil {
+		return nil
+	}
+	var fields []OptionField
+	t := reflect.TypeOf(c.Options).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := optionName(sf)
+		if name == "" {
+			continue
+		}
+		fields = append(fields, OptionField{
+			Name:     name,
+			Type:     optionType(sf.Type),
+			Required: sf.Tag.Get("mstreamer") == "required",
+			Doc:      sf.Tag.Get("doc"),
+		})
+	}
+	return fields
+}
+
+// decodeOptions decodes raw options into a new value of the component options type
+// and checks required options, the components they refer to being resolved in r
+func (c Component) decodeOptions(r *Registry, raw map[string]interface{}) (interface{}, error) {
+	if c.Options == nil {
+		if len(raw) > 0 {
+			return nil, fmt.Errorf("%v %q takes no options", c.Kind, c.Name)
+		}
+		return nil, nil
+	}
+	t := reflect.TypeOf(c.Options).Elem()
+	v := reflect.New(t)
+	if raw != nil {
+		b, err := json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v.Interface()); err != nil {
+			var terr *json.UnmarshalTypeError
+			if errors.As(err, &terr) && terr.Field != "" {
+				return nil, &ConfigError{Path: terr.Field, Err: fmt.Errorf("expected %v, got %v", optionType(terr.Type), terr.Value)}
+			}
+			return nil, errors.New(strings.TrimPrefix(err.Error(), "json: "))
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := optionName(sf)
+		if name == "" || sf.Tag.Get("mstreamer") != "required" {
+			continue
+		}
+		if _, ok := raw[name]; !ok {
+			return nil, &ConfigError{Path: name, Err: errors.New("required option is missing")}
+		}
+	}
+	if ro, ok := v.Interface().(registryOptions); ok {
+		ro.useRegistry(r)
+	}
+	if vl, ok := v.Interface().(OptionsValidator); ok {
+		if err := vl.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return v.Interface(), nil
+}
+
+func optionName(sf reflect.StructField) string {
+	if sf.PkgPath != "" {
+		return ""
+	}
+	name := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return sf.Name
+	}
+	return name
+}
+
+func optionType(t reflect.Type) string {
+	if t == reflect.TypeOf(Duration(0)) {
+		return "duration"
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return optionType(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return "list of " + optionType(t.Elem())
+	case reflect.Map:
+		return "map of " + optionType(t.Elem())
+	case reflect.Struct:
+		return "object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Interface:
+		return "any"
+	default:
+		return t.Kind().String()
+	}
+}
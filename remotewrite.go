@@ -0,0 +1,258 @@
+package mstreamer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// NewRemoteWriteEncoder returns an Encoder that parses a Prometheus remote write request, a snappy
+// compressed protobuf WriteRequest. Every sample is a measure named after the __name__ label, tagged with
+// the other labels and holding a value field, as the Prometheus encoder does for untyped metrics.
+// Exemplars, native histograms and metadata are skipped.
+// maxSize bounds the request, compressed and decompressed, DefaultMaxBodySize when zero
+func NewRemoteWriteEncoder(maxSize int64) (Encoder, error) {
+	adapter, err := remoteWriteEncoderAdapter(maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncoder(adapter)
+}
+
+func remoteWriteEncoderAdapter(maxSize int64) (EncoderAdapter, error) {
+	if maxSize < 0 {
+		return nil, errors.New("max size must not be negative")
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxBodySize
+	}
+	return func(f Feedback, r io.Reader, w MeasureWriter) {
+		remoteWrite(f, r, w, maxSize)
+	}, nil
+}
+
+func remoteWrite(f Feedback, r io.Reader, w MeasureWriter, maxSize int64) {
+	compressed, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		f("remote write read error: %v", err)
+		return
+	}
+	if int64(len(compressed)) > maxSize {
+		f("remote write read error: request exceeds %v bytes", maxSize)
+		return
+	}
+	b, err := snappyDecode(compressed, maxSize)
+	if err != nil {
+		f("remote write decompression error: %v", err)
+		return
+	}
+	err = protoFields(b, func(num int, v uint64, data []byte) error {
+		if num != 1 {
+			return nil
+		}
+		ms, err := parseRemoteWriteSeries(data)
+		if err != nil {
+			return err
+		}
+		for _, m := range ms {
+			if err := w.Write(m); err != nil {
+				return fmt.Errorf("%w: %v", errRemoteWriteStopped, err)
+			}
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errRemoteWriteStopped):
+		f("remote write write error: %v", err)
+	case err != nil:
+		f("remote write parse error: %v", err)
+	}
+}
+
+var errRemoteWriteStopped = errors.New("stream stopped")
+
+// parseRemoteWriteSeries parses a TimeSeries message into a measure per sample
+func parseRemoteWriteSeries(b []byte) ([]Measure, error) {
+	var (
+		name    string
+		tags    []Tag
+		samples []Measure
+	)
+	err := protoFields(b, func(num int, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			var l Tag
+			err := protoFields(data, func(num int, v uint64, data []byte) error {
+				switch num {
+				case 1:
+					l.Name = string(data)
+				case 2:
+					l.Data = string(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if l.Name == "__name__" {
+				name = l.Data
+			} else {
+				tags = append(tags, l)
+			}
+		case 2:
+			var s Measure
+			err := protoFields(data, func(num int, v uint64, data []byte) error {
+				switch num {
+				case 1:
+					s.Flds = []Field{{Name: "value", Type: TFloat, Data: math.Float64frombits(v)}}
+				case 2:
+					s.Time = int64(v) * 1e6
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if s.Flds == nil {
+				s.Flds = []Field{{Name: "value", Type: TFloat, Data: 0.0}}
+			}
+			samples = append(samples, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("time series without __name__ label")
+	}
+	for i := range samples {
+		samples[i].Name, samples[i].Tags = name, tags
+	}
+	return samples, nil
+}
+
+// protoFields calls fn with every field of a protobuf message: the value of varint and fixed fields,
+// the data of length delimited ones
+func protoFields(b []byte, fn func(num int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("invalid protobuf field key")
+		}
+		b = b[n:]
+		var (
+			v    uint64
+			data []byte
+		)
+		switch key & 7 {
+		case 0:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return errors.New("invalid protobuf varint")
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return errors.New("truncated protobuf fixed64")
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errors.New("truncated protobuf length delimited field")
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+		case 5:
+			if len(b) < 4 {
+				return errors.New("truncated protobuf fixed32")
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %v", key&7)
+		}
+		if err := fn(int(key>>3), v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snappyDecode decompresses a snappy block, the format of remote write bodies, of up to maxSize bytes
+func snappyDecode(src []byte, maxSize int64) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errors.New("invalid snappy length")
+	}
+	if size > uint64(maxSize) {
+		return nil, fmt.Errorf("snappy length %v exceeds %v bytes", size, maxSize)
+	}
+	src = src[n:]
+	// dst grows with the data actually decoded rather than with the length announced
+	var dst []byte
+	for len(src) > 0 {
+		tag := src[0]
+		src = src[1:]
+		var length, offset int
+		switch tag & 3 {
+		case 0:
+			length = int(tag >> 2)
+			if length >= 60 {
+				k := length - 59
+				if len(src) < k {
+					return nil, errors.New("truncated snappy literal")
+				}
+				length = 0
+				for i := k - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[k:]
+			}
+			length++
+			if length > len(src) || length <= 0 {
+				return nil, errors.New("truncated snappy literal")
+			}
+			if uint64(len(dst)+length) > size {
+				return nil, errors.New("snappy literal exceeds the decoded length")
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1:
+			if len(src) < 1 {
+				return nil, errors.New("truncated snappy copy")
+			}
+			length = 4 + int(tag>>2)&7
+			offset = int(tag&0xe0)<<3 | int(src[0])
+			src = src[1:]
+		case 2:
+			if len(src) < 2 {
+				return nil, errors.New("truncated snappy copy")
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src))
+			src = src[2:]
+		case 3:
+			if len(src) < 4 {
+				return nil, errors.New("truncated snappy copy")
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src))
+			src = src[4:]
+		}
+		if offset <= 0 || offset > len(dst) {
+			return nil, errors.New("invalid snappy copy offset")
+		}
+		if uint64(len(dst)+length) > size {
+			return nil, errors.New("snappy copy exceeds the decoded length")
+		}
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != size {
+		return nil, errors.New("snappy length mismatch")
+	}
+	return dst, nil
+}
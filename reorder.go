@@ -0,0 +1,128 @@
+package mstreamer
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// ReorderOptions configures a reorder filter
+type ReorderOptions struct {
+	// Lateness is how long, in measure time, a measure may arrive after newer ones and still be put in order
+	Lateness time.Duration
+	// Late receives the measures arriving after their turn, nil drops them
+	Late OutputContext
+}
+
+// NewReorderFilterContext returns a FilterContext buffering measures and passing them on in Measure.Time order.
+// The watermark trails the newest measure time by opts.Lateness: measures up to the watermark are passed on,
+// measures older than it are late and go to opts.Late or are dropped. Late measures are counted through
+// the Feedback every reportInterval and at the end of the stream, which flushes the buffer
+func NewReorderFilterContext(opts ReorderOptions) (FilterContext, error) {
+	if opts.Lateness < 0 {
+		return nil, errors.New("lateness must not be negative")
+	}
+	return func(ctx context.Context, f Feedback, r MeasureReader) (MeasureReader, error) {
+		var (
+			buf       reorderHeap
+			seq       uint64
+			watermark = int64(math.MinInt64)
+			late      int64
+			reported  int64
+			last      = time.Now()
+			lw        MeasurePipeWriter
+			done      chan error
+			stop      = func() {}
+			cancel    = func() {}
+		)
+		report := func(f Feedback) {
+			if late > reported {
+				f.Warn("late measures", "count", late-reported, "total", late, "routed", opts.Late != nil)
+				reported = late
+			}
+			last = time.Now()
+		}
+		adapter := func(f Feedback, m *Measure, mw MeasureWriter) {
+			if m.Time < watermark {
+				late++
+				if lw != nil {
+					lw.Write(*m)
+				}
+				if time.Since(last) >= reportInterval {
+					report(f)
+				}
+				return
+			}
+			seq++
+			heap.Push(&buf, reorderItem{m: *m, seq: seq})
+			if t := m.Time - int64(opts.Lateness); t > watermark {
+				watermark = t
+			}
+			for len(buf) > 0 && buf[0].m.Time <= watermark {
+				mw.Write(heap.Pop(&buf).(reorderItem).m)
+			}
+		}
+		finalizer := func(f Feedback, mw MeasureWriter) {
+			for len(buf) > 0 {
+				mw.Write(heap.Pop(&buf).(reorderItem).m)
+			}
+			report(f)
+			if lw != nil {
+				stop()
+				lw.Close()
+				if err := <-done; err != nil {
+					f.Error(err, "late output failed")
+				}
+				cancel()
+			}
+		}
+		flt, err := NewFilterContext(func(ctx context.Context, f Feedback, m *Measure, mw MeasureWriter) {
+			adapter(f, m, mw)
+		}, finalizer)
+		if err != nil {
+			return nil, err
+		}
+		if ctx != nil && f != nil && r != nil && opts.Late != nil {
+			var lr MeasurePipeReader
+			lr, lw = ChanMeasurePipe(ctx)
+			done = make(chan error, 1)
+			go func() {
+				err := opts.Late(ctx, f, lr)
+				lr.Close()
+				done <- err
+			}()
+			var dctx context.Context
+			dctx, cancel = drainContext(ctx)
+			stop = onDone(dctx, func() {
+				lw.CloseWithError(context.Cause(dctx))
+			})
+		}
+		return flt(ctx, f, r)
+	}, nil
+}
+
+type reorderItem struct {
+	m   Measure
+	seq uint64
+}
+
+// reorderHeap orders measures by time, then by arrival
+type reorderHeap []reorderItem
+
+func (h reorderHeap) Len() int { return len(h) }
+func (h reorderHeap) Less(i, j int) bool {
+	if h[i].m.Time != h[j].m.Time {
+		return h[i].m.Time < h[j].m.Time
+	}
+	return h[i].seq < h[j].seq
+}
+func (h reorderHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *reorderHeap) Push(x interface{}) { *h = append(*h, x.(reorderItem)) }
+func (h *reorderHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
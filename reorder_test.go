@@ -0,0 +1,133 @@
+package mstreamer
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewReorderFilterContext(t *testing.T) {
+	sec := int64(time.Second)
+	tests := []struct {
+		name     string
+		lateness time.Duration
+		in       []int64
+		want     []int64
+		wantLate []int64
+	}{
+		{
+			name:     `when measures are out of order within lateness then they are sorted`,
+			lateness: 10 * time.Second,
+			in:       []int64{5, 3, 8, 1, 20, 12},
+			want:     []int64{1, 3, 5, 8, 12, 20},
+		},
+		{
+			name:     `when measures are older than the watermark then they are late`,
+			lateness: 2 * time.Second,
+			in:       []int64{5, 10, 7, 9, 1},
+			want:     []int64{5, 9, 10},
+			wantLate: []int64{7, 1},
+		},
+		{
+			name: `when lateness is zero then every older measure is late`,
+			in:   []int64{1, 2, 2, 1, 3},
+			want: []int64{1, 2, 2, 3}, wantLate: []int64{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, routed := range []bool{false, true} {
+				var late []int64
+				opts := ReorderOptions{Lateness: tt.lateness}
+				if routed {
+					opts.Late = func(ctx context.Context, f Feedback, r MeasureReader) error {
+						var m Measure
+						for r.Read(&m) == nil {
+							late = append(late, m.Time/sec)
+						}
+						return nil
+					}
+				}
+				flt, err := NewReorderFilterContext(opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r, w := NewChanMeasurePipe(len(tt.in))
+				for _, at := range tt.in {
+					w.Write(Measure{Name: "m", Time: at * sec})
+				}
+				w.Close()
+				var events []Event
+				f, _ := NewEventFeedback(func(e Event) { events = append(events, e) })
+				fr, err := flt(context.Background(), f, r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []int64
+				var m Measure
+				for fr.Read(&m) == nil {
+					got = append(got, m.Time/sec)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got times %v want %v", got, tt.want)
+				}
+				if routed && !reflect.DeepEqual(late, tt.wantLate) {
+					t.Errorf("got late times %v want %v", late, tt.wantLate)
+				}
+				if len(tt.wantLate) > 0 && (len(events) != 1 || events[0].Level != LevelWarn) {
+					t.Errorf("got events %v want a late measures warning", events)
+				}
+			}
+		})
+	}
+}
+
+func TestReorderFilterLateConfig(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		b, _ := io.ReadAll(c)
+		received <- string(b)
+	}()
+	c, ok := DefaultRegistry.Lookup(KindFilter, "reorder")
+	if !ok {
+		t.Fatal("reorder filter is not registered")
+	}
+	opts, err := c.decodeOptions(DefaultRegistry, map[string]interface{}{"lateness": "1s", "late": map[string]interface{}{
+		"decoder": map[string]interface{}{"type": "influx"},
+		"sinker":  map[string]interface{}{"type": "tcp", "options": map[string]interface{}{"address": ln.Addr().String()}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := c.build(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w := NewChanMeasurePipe(2)
+	w.Write(Measure{Name: "m", Flds: []Field{{"v", TFloat, 1.0}}, Time: 10 * int64(time.Second)})
+	w.Write(Measure{Name: "m", Flds: []Field{{"v", TFloat, 2.0}}, Time: 5 * int64(time.Second)})
+	w.Close()
+	fr, err := v.(FilterContext)(context.Background(), func(string, ...interface{}) {}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Measure
+	for fr.Read(&m) == nil {
+	}
+	if got, want := <-received, "m v=2 5000000000\n"; got != want {
+		t.Errorf("got late output %q want %q", got, want)
+	}
+}
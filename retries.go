@@ -0,0 +1,259 @@
+package mstreamer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultRetryBaseBackoff is the wait before the first retry when a RetryPolicy does not set one
+	DefaultRetryBaseBackoff = 500 * time.Millisecond
+	// DefaultRetryMaxBackoff caps the wait between retries when a RetryPolicy does not set it
+	DefaultRetryMaxBackoff = 30 * time.Second
+)
+
+// DefaultRetryableStatus are the HTTP status codes retried when a RetryPolicy does not list its own
+var DefaultRetryableStatus = []int{
+	http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+	http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+}
+
+// RetryPolicy tells the HTTP sources and sinkers how to retry a request that failed on a network error
+// or on a retryable status. The zero value does not retry
+type RetryPolicy struct {
+	// MaxAttempts is the number of requests made, the first one included
+	MaxAttempts int
+	// BaseBackoff is the wait before the first retry, doubled on every following retry
+	BaseBackoff time.Duration
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration
+	// Jitter, between 0 and 1, is the fraction of every wait that is randomized
+	Jitter float64
+	// RetryableStatus are the status codes worth another attempt. A Retry-After header sent along
+	// one of them is honoured, up to MaxBackoff, when it asks for a longer wait than the backoff
+	RetryableStatus []int
+}
+
+// Validate checks the bounds of the policy
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 || p.BaseBackoff < 0 || p.MaxBackoff < 0 {
+		return errors.New("retry attempts and backoffs must not be negative")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry jitter %v is not between 0 and 1", p.Jitter)
+	}
+	return nil
+}
+
+// NewRetryHTTPSource is a basic HTTP Source retrying its GET request as told by policy
+func NewRetryHTTPSource(url, user, pwd string, policy RetryPolicy) (Source, error) {
+	src, err := NewRetryHTTPSourceContext(url, user, pwd, policy)
+	if err != nil {
+		return nil, err
+	}
+	return func(f Feedback) (io.ReadCloser, error) {
+		return src(context.Background(), f)
+	}, nil
+}
+
+// NewRetryHTTPSourceContext is the SourceContext counterpart of NewRetryHTTPSource
+func NewRetryHTTPSourceContext(url, user, pwd string, policy RetryPolicy) (SourceContext, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return newHTTPSourceContext(url, user, pwd, newRetrier(policy))
+}
+
+// NewRetryHTTPSinker is a basic HTTP Sinker retrying its POST request as told by policy.
+// When retries are enabled the whole stream is buffered before it is posted, so every attempt
+// resends the same payload. Put it behind a batched output to keep the requests bounded
+func NewRetryHTTPSinker(url, user, pwd string, policy RetryPolicy) (Sinker, error) {
+	snk, err := NewRetryHTTPSinkerContext(url, user, pwd, policy)
+	if err != nil {
+		return nil, err
+	}
+	return func(f Feedback, r io.ReadCloser) error {
+		return snk(context.Background(), f, r)
+	}, nil
+}
+
+// NewRetryHTTPSinkerContext is the SinkerContext counterpart of NewRetryHTTPSinker
+func NewRetryHTTPSinkerContext(url, user, pwd string, policy RetryPolicy) (SinkerContext, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return newHTTPSinkerContext(url, user, pwd, newRetrier(policy))
+}
+
+func newHTTPSourceContext(url, user, pwd string, rt *retrier) (SourceContext, error) {
+	adapter := func(ctx context.Context, f Feedback, w io.Writer) {
+		resp, err := rt.do(ctx, f, func() (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.SetBasicAuth(user, pwd)
+			return req, nil
+		})
+		if err != nil {
+			f("error on retrieving reader %v", err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			body, _ := ioutil.ReadAll(resp.Body) // consumes all body before leaves
+			f("error on retrieving reader %v", errors.New(resp.Status+":"+string(body)))
+			return
+		}
+		stop := onDone(ctx, func() { resp.Body.Close() })
+		defer stop()
+		if _, err := io.Copy(w, resp.Body); err != nil && ctx.Err() == nil {
+			f("error on writing %v", err)
+		}
+	}
+	return NewSourceContext(adapter)
+}
+
+func newHTTPSinkerContext(url, user, pwd string, rt *retrier) (SinkerContext, error) {
+	push := func(ctx context.Context, f Feedback, r io.ReadCloser) error {
+		var payload []byte
+		if rt.policy.MaxAttempts > 1 {
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			payload = b
+		}
+		resp, err := rt.do(ctx, f, func() (*http.Request, error) {
+			body := io.Reader(r)
+			if payload != nil {
+				body = bytes.NewReader(payload)
+			}
+			req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+			if err != nil {
+				return nil, err
+			}
+			req.SetBasicAuth(user, pwd)
+			return req, nil
+		})
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
+			body, _ := ioutil.ReadAll(resp.Body) // consumes all body before leaves
+			return fmt.Errorf("status: %v, body: %v ", resp.Status, string(body))
+		}
+		return nil
+	}
+	return NewSinkerContext(push)
+}
+
+// retrier runs requests under a RetryPolicy
+type retrier struct {
+	policy    RetryPolicy
+	retryable map[int]bool
+	client    *http.Client
+	sleep     func(ctx context.Context, d time.Duration) error
+	random    func() float64
+}
+
+func newRetrier(policy RetryPolicy) *retrier {
+	if policy.BaseBackoff == 0 {
+		policy.BaseBackoff = DefaultRetryBaseBackoff
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	status := policy.RetryableStatus
+	if status == nil {
+		status = DefaultRetryableStatus
+	}
+	retryable := make(map[int]bool)
+	for _, code := range status {
+		retryable[code] = true
+	}
+	return &retrier{policy: policy, retryable: retryable, client: &http.Client{}, sleep: sleepContext, random: rand.Float64}
+}
+
+// do sends the requests built by newRequest until one gets a response that is not retryable
+// or the attempts are exhausted. The last response is returned whatever its status
+func (rt *retrier) do(ctx context.Context, f Feedback, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := rt.client.Do(req)
+		last := attempt >= rt.policy.MaxAttempts || ctx.Err() != nil
+		if err == nil && (!rt.retryable[resp.StatusCode] || last) {
+			return resp, nil
+		}
+		if err != nil && last {
+			return nil, err
+		}
+		wait := rt.backoff(attempt)
+		if err == nil {
+			if after := retryAfter(resp.Header.Get("Retry-After"), time.Now()); after > wait {
+				wait = after
+				if wait > rt.policy.MaxBackoff {
+					wait = rt.policy.MaxBackoff
+				}
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			err = errors.New(resp.Status)
+		}
+		f("http %v %v attempt %v of %v failed: %v, retrying in %v", req.Method, req.URL.Redacted(), attempt, rt.policy.MaxAttempts, err, wait)
+		if serr := rt.sleep(ctx, wait); serr != nil {
+			return nil, fmt.Errorf("%w, gave up retrying: %v", err, serr)
+		}
+	}
+}
+
+// backoff returns the wait after the given failed attempt
+func (rt *retrier) backoff(attempt int) time.Duration {
+	wait := rt.policy.MaxBackoff
+	if attempt < 32 {
+		if d := rt.policy.BaseBackoff << uint(attempt-1); d > 0 && d < wait {
+			wait = d
+		}
+	}
+	if rt.policy.Jitter > 0 {
+		wait -= time.Duration(rt.policy.Jitter * rt.random() * float64(wait))
+	}
+	return wait
+}
+
+// retryAfter parses a Retry-After header holding either seconds or an HTTP date
+func retryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+package mstreamer
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryHTTPSinker(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    RetryPolicy
+		responses []int
+		header    string
+		wantCalls int
+		wantWaits []time.Duration
+		wantErr   bool
+	}{
+		{
+			name:      `when server recovers then the same payload is resent with growing backoff`,
+			policy:    RetryPolicy{MaxAttempts: 4, BaseBackoff: time.Second},
+			responses: []int{503, 502, 204}, wantCalls: 3,
+			wantWaits: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:      `when server asks to retry later then Retry-After is honoured`,
+			policy:    RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Second},
+			responses: []int{429, 200}, header: "7", wantCalls: 2,
+			wantWaits: []time.Duration{7 * time.Second},
+		},
+		{
+			name:      `when Retry-After is past the max backoff then the wait is capped`,
+			policy:    RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Second, MaxBackoff: 3 * time.Second},
+			responses: []int{503, 200}, header: "60", wantCalls: 2,
+			wantWaits: []time.Duration{3 * time.Second},
+		},
+		{
+			name:      `when backoff grows past its max then it is capped`,
+			policy:    RetryPolicy{MaxAttempts: 4, BaseBackoff: time.Second, MaxBackoff: 3 * time.Second},
+			responses: []int{500, 500, 500, 500}, wantCalls: 4, wantErr: true,
+			wantWaits: []time.Duration{time.Second, 2 * time.Second, 3 * time.Second},
+		},
+		{
+			name:      `when status is not retryable then should fail at once`,
+			policy:    RetryPolicy{MaxAttempts: 3},
+			responses: []int{400}, wantCalls: 1, wantErr: true,
+		},
+		{
+			name:      `when policy is zero then should not retry`,
+			responses: []int{503}, wantCalls: 1, wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				calls  int
+				bodies []string
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				mu.Lock()
+				status := tt.responses[calls]
+				calls++
+				bodies = append(bodies, string(b))
+				mu.Unlock()
+				if tt.header != "" {
+					w.Header().Set("Retry-After", tt.header)
+				}
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+			var waits []time.Duration
+			rt := newRetrier(tt.policy)
+			rt.sleep = func(ctx context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+			snk, err := newHTTPSinkerContext(srv.URL, "", "", rt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = snk(context.Background(), t.Logf, ioutil.NopCloser(strings.NewReader("payload")))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("got %v calls want %v", calls, tt.wantCalls)
+			}
+			for _, b := range bodies {
+				if b != "payload" {
+					t.Errorf("got body %q want payload", b)
+				}
+			}
+			if !reflect.DeepEqual(waits, tt.wantWaits) {
+				t.Errorf("got waits %v want %v", waits, tt.wantWaits)
+			}
+		})
+	}
+}
+
+func TestRetryHTTPSource(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "metrics")
+	}))
+	defer srv.Close()
+	rt := newRetrier(RetryPolicy{MaxAttempts: 2})
+	rt.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	src, err := newHTTPSourceContext(srv.URL, "", "", rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := src(context.Background(), t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	if string(b) != "metrics" || calls != 2 {
+		t.Errorf("got %q after %v calls want metrics after 2", b, calls)
+	}
+}
+
+func TestRetryBackoffJitter(t *testing.T) {
+	rt := newRetrier(RetryPolicy{BaseBackoff: time.Second, Jitter: 0.5})
+	rt.random = func() float64 { return 1 }
+	if got := rt.backoff(2); got != time.Second {
+		t.Errorf("got %v want 1s", got)
+	}
+	if got := retryAfter(time.Unix(100, 0).UTC().Format(http.TimeFormat), time.Unix(90, 0)); got != 10*time.Second {
+		t.Errorf("got %v want 10s", got)
+	}
+	if err := (RetryPolicy{Jitter: 2}).Validate(); err == nil {
+		t.Errorf("want error on jitter out of range")
+	}
+}
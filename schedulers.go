@@ -0,0 +1,279 @@
+package mstreamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// OverlapPolicy tells a scheduler what to do when a tick arrives while the previous run is still going
+type OverlapPolicy int
+
+const (
+	//OverlapSkip drops the new tick
+	OverlapSkip OverlapPolicy = iota
+	//OverlapQueue runs the new tick once the previous runs have ended
+	OverlapQueue
+	//OverlapCancel cancels the previous run and starts the new one
+	OverlapCancel
+)
+
+// ParseOverlapPolicy parses skip, queue or cancel
+func ParseOverlapPolicy(s string) (OverlapPolicy, error) {
+	switch s {
+	case "", "skip":
+		return OverlapSkip, nil
+	case "queue":
+		return OverlapQueue, nil
+	case "cancel":
+		return OverlapCancel, nil
+	default:
+		return 0, fmt.Errorf("unknown overlap policy %q", s)
+	}
+}
+
+// maxQueuedRuns bounds the ticks waiting for a run under OverlapQueue
+const maxQueuedRuns = 64
+
+// SchedulerOptions tunes how scheduled runs are started
+type SchedulerOptions struct {
+	// Jitter delays every run by a random duration in [0, Jitter). The tick time is not affected
+	Jitter time.Duration
+	// Overlap is the policy applied when a tick arrives while a run is in progress
+	Overlap OverlapPolicy
+	// Timeout cancels a run that lasts longer, zero means no timeout
+	Timeout time.Duration
+}
+
+type tickTimeKey struct{}
+
+// WithTickTime returns a copy of ctx carrying the scheduled time of the current run
+func WithTickTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, tickTimeKey{}, t)
+}
+
+// TickTime returns the scheduled time of the run ctx belongs to, if any
+func TickTime(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(tickTimeKey{}).(time.Time)
+	return t, ok
+}
+
+type scheduleKey struct{}
+
+// scheduleContext returns the context of the schedule the run ctx belongs to, ctx itself when not scheduled
+func scheduleContext(ctx context.Context) context.Context {
+	if sctx, ok := ctx.Value(scheduleKey{}).(context.Context); ok {
+		return sctx
+	}
+	return ctx
+}
+
+// NewTickTimeInjectorFilterContext returns a FilterContext that sets the time of every measure
+// to the scheduled tick time of the run, so all measures of a run share the aligned collection time.
+// Measures are left untouched when the filter does not run under a scheduler
+func NewTickTimeInjectorFilterContext() (FilterContext, error) {
+	return NewFilterContext(
+		func(ctx context.Context, f Feedback, m *Measure, mw MeasureWriter) {
+			if t, ok := TickTime(ctx); ok {
+				m.Time = t.UnixNano()
+			}
+			mw.Write(*m)
+		}, nil)
+}
+
+// NewScheduledRunnable returns a RunnableContext that runs r at every activation of sch until its
+// context is done. Every run receives a context carrying its tick time and run errors are sent to the Feedback
+func NewScheduledRunnable(sch Schedule, r RunnableContext, opts SchedulerOptions) (RunnableContext, error) {
+	if sch == nil {
+		return nil, errors.New("schedule is nil")
+	}
+	if r == nil {
+		return nil, errors.New("runnable function is nil")
+	}
+	return func(ctx context.Context, f Feedback) error {
+		if ctx == nil {
+			return errors.New("context is nil")
+		}
+		if f == nil {
+			return errors.New("feedback function is nil")
+		}
+		runSchedule(ctx, f, sch, opts, func(ctx context.Context) error {
+			return r(ctx, f)
+		})
+		if ctx.Err() == nil {
+			return nil
+		}
+		return fmt.Errorf("%w: %w", ErrPipelineCanceled, context.Cause(ctx))
+	}, nil
+}
+
+// NewScheduledInput returns an InputContext that calls inp at every activation of sch and streams
+// the measures of every run into a single reader, until its context is done
+func NewScheduledInput(sch Schedule, inp InputContext, opts SchedulerOptions) (InputContext, error) {
+	return newScheduledInput(sch, inp, opts, ChanMeasurePipe)
+}
+
+func newScheduledInput(sch Schedule, inp InputContext, opts SchedulerOptions, pipe MeasurePipe) (InputContext, error) {
+	if sch == nil {
+		return nil, errors.New("schedule is nil")
+	}
+	if inp == nil {
+		return nil, errors.New("input function is nil")
+	}
+	if pipe == nil {
+		return nil, errors.New("pipe is nil")
+	}
+	return func(ctx context.Context, f Feedback) (MeasureReader, error) {
+		if ctx == nil {
+			return nil, errors.New("context is nil")
+		}
+		if f == nil {
+			return nil, errors.New("feedback funcion is nil")
+		}
+		mr, mw := pipe(ctx)
+		go func() {
+			defer mw.Close()
+			runSchedule(ctx, f, sch, opts, func(ctx context.Context) error {
+				r, err := inp(ctx, f)
+				if err != nil {
+					return err
+				}
+				for {
+					var m Measure
+					err := r.Read(&m)
+					if err != nil {
+						if err == io.EOF {
+							return nil
+						}
+						if aborted(ctx, err) {
+							return err
+						}
+						f("scheduled input read error: %v", err)
+						continue
+					}
+					if err := mw.Write(m); err != nil {
+						closeMeasureReader(r)
+						return err
+					}
+				}
+			})
+		}()
+		return mr, nil
+	}, nil
+}
+
+// runSchedule calls run at every activation of sch until ctx is done and waits for the runs in progress
+func runSchedule(ctx context.Context, f Feedback, sch Schedule, opts SchedulerOptions, run func(context.Context) error) {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		running int
+		cancels = make(map[int]context.CancelFunc)
+		nextID  int
+		queue   = make(chan time.Time, maxQueuedRuns)
+	)
+	start := func(tick time.Time) {
+		rctx, cancel := runContext(ctx, tick, opts.Timeout)
+		mu.Lock()
+		id := nextID
+		nextID++
+		running++
+		cancels[id] = cancel
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				running--
+				delete(cancels, id)
+				mu.Unlock()
+				cancel()
+			}()
+			if err := run(rctx); err != nil {
+				f("scheduled run at %v failed: %v", tick.Format(time.RFC3339), err)
+			}
+		}()
+	}
+	if opts.Overlap == OverlapQueue {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tick := range queue {
+				if ctx.Err() != nil {
+					return
+				}
+				rctx, cancel := runContext(ctx, tick, opts.Timeout)
+				if err := run(rctx); err != nil {
+					f("scheduled run at %v failed: %v", tick.Format(time.RFC3339), err)
+				}
+				cancel()
+			}
+		}()
+	}
+
+	now := time.Now()
+	next := sch.Next(now)
+	for !next.IsZero() {
+		delay := next.Sub(now)
+		if opts.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(opts.Jitter)))
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			close(queue)
+			wg.Wait()
+			return
+		case <-timer.C:
+		}
+		tick := next
+		switch opts.Overlap {
+		case OverlapQueue:
+			select {
+			case queue <- tick:
+			default:
+				f("scheduled run at %v dropped, %v runs already queued", tick.Format(time.RFC3339), maxQueuedRuns)
+			}
+		case OverlapCancel:
+			mu.Lock()
+			for _, cancel := range cancels {
+				cancel()
+			}
+			mu.Unlock()
+			start(tick)
+		default:
+			mu.Lock()
+			busy := running > 0
+			mu.Unlock()
+			if busy {
+				f("scheduled run at %v skipped, previous run still in progress", tick.Format(time.RFC3339))
+			} else {
+				start(tick)
+			}
+		}
+		now = time.Now()
+		next = sch.Next(tick)
+		// ticks missed while waiting are not caught up
+		for !next.IsZero() && !next.After(now) {
+			next = sch.Next(next)
+		}
+	}
+	f("schedule has no further activation")
+	close(queue)
+	wg.Wait()
+}
+
+// runContext returns the context of the run scheduled at tick
+func runContext(ctx context.Context, tick time.Time, timeout time.Duration) (context.Context, context.CancelFunc) {
+	rctx := WithTickTime(context.WithValue(ctx, scheduleKey{}, ctx), tick)
+	if timeout > 0 {
+		return context.WithTimeout(rctx, timeout)
+	}
+	return context.WithCancel(rctx)
+}
@@ -0,0 +1,231 @@
+package mstreamer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	base := time.Date(2021, time.March, 10, 10, 7, 30, 0, time.UTC) // a wednesday
+	tests := []struct {
+		name    string
+		expr    string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: `every minute`, expr: "* * * * *", want: time.Date(2021, 3, 10, 10, 8, 0, 0, time.UTC)},
+		{name: `every 15 minutes`, expr: "*/15 * * * *", want: time.Date(2021, 3, 10, 10, 15, 0, 0, time.UTC)},
+		{name: `list of hours`, expr: "0 9,18 * * *", want: time.Date(2021, 3, 10, 18, 0, 0, 0, time.UTC)},
+		{name: `weekday names`, expr: "30 8 * * mon-fri", want: time.Date(2021, 3, 11, 8, 30, 0, 0, time.UTC)},
+		{name: `month name`, expr: "0 0 1 jun *", want: time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{name: `both day fields match either`, expr: "0 0 13 * 5", want: time.Date(2021, 3, 12, 0, 0, 0, 0, time.UTC)},
+		{name: `sunday as 7`, expr: "0 0 * * 7", want: time.Date(2021, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{name: `macro`, expr: "@monthly", want: time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{name: `every macro`, expr: "@every 10s", want: time.Date(2021, 3, 10, 10, 7, 40, 0, time.UTC)},
+		{name: `out of range`, expr: "61 * * * *", wantErr: true},
+		{name: `missing fields`, expr: "* * *", wantErr: true},
+		{name: `bad step`, expr: "*/0 * * * *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sch, err := ParseCron(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCron() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := sch.Next(base); !got.Equal(tt.want) {
+				t.Errorf("Next() got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewScheduledRunnable(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	tests := []struct {
+		name     string
+		overlap  OverlapPolicy
+		runFor   time.Duration
+		minRuns  int
+		maxRuns  int
+		canceled bool
+		// serial runs never overlap and run consecutive ticks
+		serial bool
+	}{
+		{name: `when runs are short then every tick runs`, overlap: OverlapSkip, runFor: 0, minRuns: 3, maxRuns: 7},
+		{name: `when runs overlap and policy is skip then ticks are skipped`, overlap: OverlapSkip, runFor: 10 * interval, minRuns: 1, maxRuns: 1},
+		{name: `when runs overlap and policy is cancel then previous runs are cancelled`, overlap: OverlapCancel, runFor: 3 * interval, minRuns: 3, maxRuns: 7, canceled: true},
+		{name: `when runs overlap and policy is queue then ticks wait for the previous runs`, overlap: OverlapQueue, runFor: 2 * interval, minRuns: 1, maxRuns: 3, serial: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sch, err := Every(interval)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var mu sync.Mutex
+			var ticks []time.Time
+			var canceled, running, overlapped int
+			r := func(ctx context.Context, f Feedback) error {
+				tick, ok := TickTime(ctx)
+				if !ok {
+					return errors.New("tick time is missing")
+				}
+				mu.Lock()
+				ticks = append(ticks, tick)
+				running++
+				if running > 1 {
+					overlapped++
+				}
+				mu.Unlock()
+				defer func() {
+					mu.Lock()
+					running--
+					mu.Unlock()
+				}()
+				select {
+				case <-time.After(tt.runFor):
+				case <-ctx.Done():
+					mu.Lock()
+					canceled++
+					mu.Unlock()
+				}
+				return nil
+			}
+			p, err := NewScheduledRunnable(sch, r, SchedulerOptions{Overlap: tt.overlap})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*interval+interval/2)
+			defer cancel()
+			err = p(ctx, func(string, ...interface{}) {})
+			if !errors.Is(err, ErrPipelineCanceled) {
+				t.Errorf("got error %v want %v", err, ErrPipelineCanceled)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(ticks) < tt.minRuns || len(ticks) > tt.maxRuns {
+				t.Errorf("got %v runs want between %v and %v", len(ticks), tt.minRuns, tt.maxRuns)
+			}
+			for _, tick := range ticks {
+				if tick.Truncate(interval) != tick {
+					t.Errorf("tick %v is not aligned on %v", tick, interval)
+				}
+			}
+			if tt.canceled && canceled == 0 {
+				t.Errorf("no run was cancelled")
+			}
+			if tt.serial && overlapped > 0 {
+				t.Errorf("got %v overlapping runs want none", overlapped)
+			}
+			for i := 1; tt.serial && i < len(ticks); i++ {
+				if d := ticks[i].Sub(ticks[i-1]); d != interval {
+					t.Errorf("got ticks %v apart want the queued tick %v after the previous one", d, interval)
+				}
+			}
+		})
+	}
+}
+
+// onceSchedule activates once, at the given time
+type onceSchedule time.Time
+
+func (s onceSchedule) Next(t time.Time) time.Time {
+	if !t.Before(time.Time(s)) {
+		return time.Time{}
+	}
+	return time.Time(s)
+}
+
+func TestNewScheduledRunnableFiniteSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		overlap OverlapPolicy
+	}{
+		{name: `when the schedule ends and policy is skip then the runnable returns`, overlap: OverlapSkip},
+		{name: `when the schedule ends and policy is cancel then the runnable returns`, overlap: OverlapCancel},
+		{name: `when the schedule ends and policy is queue then the runnable returns`, overlap: OverlapQueue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var runs int32
+			r := func(ctx context.Context, f Feedback) error {
+				atomic.AddInt32(&runs, 1)
+				return nil
+			}
+			p, err := NewScheduledRunnable(onceSchedule(time.Now().Add(10*time.Millisecond)), r, SchedulerOptions{Overlap: tt.overlap})
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error, 1)
+			go func() { done <- p(context.Background(), func(string, ...interface{}) {}) }()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Errorf("got error %v want nil", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("runnable did not return once the schedule ended")
+			}
+			if got := atomic.LoadInt32(&runs); got != 1 {
+				t.Errorf("got %v runs want 1", got)
+			}
+		})
+	}
+}
+
+func TestNewScheduledInput(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	sch, err := Every(interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inp, err := NewInputFromProducerContext(func(ctx context.Context, f Feedback, w MeasureWriter) {
+		tick, _ := TickTime(ctx)
+		w.Write(Measure{Name: "run", Time: tick.UnixNano()})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinp, err := NewScheduledInput(sch, inp, SchedulerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := sinp(ctx, func(string, ...interface{}) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var times []int64
+	for len(times) < 3 {
+		var m Measure
+		if err := r.Read(&m); err != nil {
+			t.Fatalf("got %v after %v runs want a measure per run", err, len(times))
+		}
+		times = append(times, m.Time)
+	}
+	for i := 1; i < len(times); i++ {
+		if d := time.Duration(times[i] - times[i-1]); d <= 0 || d%interval != 0 {
+			t.Errorf("got runs %v apart want a multiple of %v", d, interval)
+		}
+	}
+	cancel()
+	// the stream ends once the runs in progress are over
+	for {
+		var m Measure
+		if err := r.Read(&m); err != nil {
+			if err != io.EOF {
+				t.Errorf("got %v once cancelled want EOF", err)
+			}
+			break
+		}
+	}
+}
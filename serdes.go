@@ -11,8 +11,10 @@ func NewWriter(w io.Writer) MeasureWriter {
 }
 
 // NewReader read measures from io.Reader
+// If r is also an io.Closer the returned reader can be closed to release it
 func NewReader(r io.Reader) MeasureReader {
-	return &decoder{decoder: gob.NewDecoder(r)}
+	c, _ := r.(io.Closer)
+	return &decoder{decoder: gob.NewDecoder(r), closer: c}
 }
 
 type encoder struct {
@@ -25,8 +27,16 @@ func (e *encoder) Write(d Measure) error {
 
 type decoder struct {
 	decoder *gob.Decoder
+	closer  io.Closer
 }
 
 func (e *decoder) Read(d *Measure) error {
 	return e.decoder.Decode(d)
 }
+
+func (e *decoder) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
@@ -1,18 +1,20 @@
 package mstreamer
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
-	"net/http"
 	"os"
+	"time"
 )
 
 // SinkerAdapter takes
 type SinkerAdapter func(Feedback, io.ReadCloser) error
 
+// SinkerAdapterContext is a SinkerAdapter that receives the sinker drain context
+type SinkerAdapterContext func(context.Context, Feedback, io.ReadCloser) error
+
 // PushSinker takes
 type PushSinker func(Feedback, io.ReadCloser) error
 
@@ -21,18 +23,47 @@ func NewBasicHTTPSinker(url, user, pwd string) (Sinker, error) {
 	return newBasicHTTPSinker(url, user, pwd)
 }
 
+// NewBasicHTTPSinkerContext is a basic HTTP Sinker whose request is aborted once the drain timeout expires
+func NewBasicHTTPSinkerContext(url, user, pwd string) (SinkerContext, error) {
+	return newBasicHTTPSinkerContext(url, user, pwd)
+}
+
 // NewStdoutSinker is
 func NewStdoutSinker() (Sinker, error) {
 	return NewWriterSinker(os.Stdout)
 }
 
-// NewTCPSinker takes an address
+// NewTCPSinker takes an address and writes every stream to a new connection to it
 func NewTCPSinker(addr string) (Sinker, error) {
-	conn, err := net.Dial("tcp", addr)
+	snk, err := NewTCPSinkerContext(addr)
 	if err != nil {
 		return nil, err
 	}
-	return NewWriteCloserSinker(conn)
+	return func(f Feedback, r io.ReadCloser) error {
+		return snk(context.Background(), f, r)
+	}, nil
+}
+
+// NewTCPSinkerContext takes an address and writes every stream to a new connection to it, the dial and
+// the writes being aborted once the drain timeout expires
+func NewTCPSinkerContext(addr string) (SinkerContext, error) {
+	if addr == "" {
+		return nil, errors.New("address is empty")
+	}
+	return NewSinkerContext(func(ctx context.Context, f Feedback, r io.ReadCloser) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		stop := onDone(ctx, func() { conn.Close() })
+		defer stop()
+		if _, err := io.Copy(conn, r); err != nil {
+			return err
+		}
+		return nil
+	})
 }
 
 // NewWriterSinker takes
@@ -68,6 +99,12 @@ func NewSinker(adapter SinkerAdapter) (Sinker, error) {
 	return newSinker(adapter)
 }
 
+// NewSinkerContext takes an adapter and returns a SinkerContext whose stream is closed
+// once the drain timeout expires
+func NewSinkerContext(adapter SinkerAdapterContext) (SinkerContext, error) {
+	return newSinkerContext(adapter)
+}
+
 func newSinker(
 	adapter SinkerAdapter,
 ) (Sinker, error) {
@@ -85,6 +122,37 @@ func newSinker(
 	}, nil
 }
 
+func newSinkerContext(
+	adapter SinkerAdapterContext,
+) (SinkerContext, error) {
+	if adapter == nil {
+		return nil, errors.New("adapter is nil")
+	}
+	return func(ctx context.Context, f Feedback, r io.ReadCloser) error {
+		if ctx == nil {
+			return errors.New("context is nil")
+		}
+		if f == nil {
+			return errors.New("feedback funcion is nil")
+		}
+		if r == nil {
+			return errors.New("reader stream is nil")
+		}
+		dctx, cancel := drainContext(ctx)
+		defer cancel()
+		stop := onDone(dctx, func() { r.Close() })
+		defer stop()
+		s := stageTelemetry(ctx, "sinker")
+		s.addRead(1)
+		defer s.observe(time.Now())
+		if err := adapter(dctx, f, r); err != nil {
+			s.addFailed(1)
+			return err
+		}
+		return nil
+	}, nil
+}
+
 func newPushSinker(push PushSinker) (Sinker, error) {
 	if push == nil {
 		return nil, errors.New("push function is nil")
@@ -96,23 +164,15 @@ func newPushSinker(push PushSinker) (Sinker, error) {
 }
 
 func newBasicHTTPSinker(url, user, pwd string) (Sinker, error) {
-	push := func(f Feedback, r io.ReadCloser) error {
-		client := &http.Client{}
-		req, err := http.NewRequest("POST", url, r)
-		if err != nil {
-			f("error %v", err)
-			return err
-		}
-		req.SetBasicAuth(user, pwd)
-		resp, err := client.Do(req)
-		if err != nil {
-			return err
-		}
-		if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-			body, _ := ioutil.ReadAll(resp.Body) // consumes all body before leaves
-			return fmt.Errorf("error %v, status: %v, body: %v ", err, resp.Status, string(body))
-		}
-		return nil
+	snk, err := newBasicHTTPSinkerContext(url, user, pwd)
+	if err != nil {
+		return nil, err
 	}
-	return NewPushSinker(push)
+	return func(f Feedback, r io.ReadCloser) error {
+		return snk(context.Background(), f, r)
+	}, nil
+}
+
+func newBasicHTTPSinkerContext(url, user, pwd string) (SinkerContext, error) {
+	return newHTTPSinkerContext(url, user, pwd, newRetrier(RetryPolicy{}))
 }
@@ -0,0 +1,42 @@
+package mstreamer
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestTCPSinkerContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b, _ := io.ReadAll(c)
+			c.Close()
+			received <- string(b)
+		}
+	}()
+	snk, err := NewTCPSinkerContext(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every stream gets its own connection
+	for _, want := range []string{"first 1\n", "second 2\n"} {
+		if err := snk(context.Background(), func(string, ...interface{}) {}, io.NopCloser(strings.NewReader(want))); err != nil {
+			t.Fatalf("got error %v sinking %q", err, want)
+		}
+		if got := <-received; got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package mstreamer
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DDSketch estimates quantiles of a stream of values within a relative accuracy, keeping counts of
+// logarithmically sized buckets. Sketches of the same accuracy merge without losing accuracy
+type DDSketch struct {
+	gamma    float64
+	logGamma float64
+	pos      map[int32]uint64
+	neg      map[int32]uint64
+	zero     uint64
+	count    uint64
+	min      float64
+	max      float64
+}
+
+// NewDDSketch returns an empty DDSketch whose quantiles are within accuracy of the exact ones, relatively
+func NewDDSketch(accuracy float64) (*DDSketch, error) {
+	if accuracy <= 0 || accuracy >= 1 {
+		return nil, fmt.Errorf("sketch accuracy must be between 0 and 1, got %v", accuracy)
+	}
+	return newDDSketch((1 + accuracy) / (1 - accuracy)), nil
+}
+
+func newDDSketch(gamma float64) *DDSketch {
+	return &DDSketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		pos:      make(map[int32]uint64),
+		neg:      make(map[int32]uint64),
+		min:      math.Inf(1),
+		max:      math.Inf(-1),
+	}
+}
+
+// Add adds a value, failing on NaN and infinities
+func (s *DDSketch) Add(v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("cannot add %v to a sketch", v)
+	}
+	switch {
+	case v > 0:
+		s.pos[s.index(v)]++
+	case v < 0:
+		s.neg[s.index(-v)]++
+	default:
+		s.zero++
+	}
+	s.count++
+	s.min = math.Min(s.min, v)
+	s.max = math.Max(s.max, v)
+	return nil
+}
+
+// Merge adds the values of o, which must have the same accuracy
+func (s *DDSketch) Merge(o *DDSketch) error {
+	if o.gamma != s.gamma {
+		return errors.New("cannot merge sketches of different accuracies")
+	}
+	for i, n := range o.pos {
+		s.pos[i] += n
+	}
+	for i, n := range o.neg {
+		s.neg[i] += n
+	}
+	s.zero += o.zero
+	s.count += o.count
+	s.min = math.Min(s.min, o.min)
+	s.max = math.Max(s.max, o.max)
+	return nil
+}
+
+// Count returns the number of values added
+func (s *DDSketch) Count() uint64 {
+	return s.count
+}
+
+// Quantile returns the estimated q quantile, NaN when the sketch is empty or q is out of [0, 1]
+func (s *DDSketch) Quantile(q float64) float64 {
+	if s.count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+	rank := uint64(q * float64(s.count-1))
+	var seen uint64
+	for _, i := range sortedBuckets(s.neg, true) {
+		if seen += s.neg[i]; seen > rank {
+			return math.Max(-s.value(i), s.min)
+		}
+	}
+	if seen += s.zero; seen > rank {
+		return 0
+	}
+	for _, i := range sortedBuckets(s.pos, false) {
+		if seen += s.pos[i]; seen > rank {
+			return math.Min(s.value(i), s.max)
+		}
+	}
+	return s.max
+}
+
+func (s *DDSketch) index(v float64) int32 {
+	return int32(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value is the value of bucket i, within the relative accuracy of every value it counts
+func (s *DDSketch) value(i int32) float64 {
+	return 2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1)
+}
+
+func sortedBuckets(buckets map[int32]uint64, desc bool) []int32 {
+	keys := make([]int32, 0, len(buckets))
+	for i := range buckets {
+		keys = append(keys, i)
+	}
+	sort.Slice(keys, func(a, b int) bool { return keys[a] < keys[b] != desc })
+	return keys
+}
+
+// MarshalBinary encodes the sketch as its gamma, counts, bounds and buckets
+func (s *DDSketch) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 48+10*(len(s.pos)+len(s.neg)))
+	b = binary.BigEndian.AppendUint64(b, math.Float64bits(s.gamma))
+	b = binary.BigEndian.AppendUint64(b, math.Float64bits(s.min))
+	b = binary.BigEndian.AppendUint64(b, math.Float64bits(s.max))
+	b = binary.AppendUvarint(b, s.zero)
+	for _, buckets := range []map[int32]uint64{s.pos, s.neg} {
+		b = binary.AppendUvarint(b, uint64(len(buckets)))
+		for _, i := range sortedBuckets(buckets, false) {
+			b = binary.AppendVarint(b, int64(i))
+			b = binary.AppendUvarint(b, buckets[i])
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary
+func (s *DDSketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 24 {
+		return errors.New("sketch is truncated")
+	}
+	gamma := math.Float64frombits(binary.BigEndian.Uint64(data))
+	if !(gamma > 1) || math.IsInf(gamma, 0) {
+		return fmt.Errorf("sketch gamma %v is invalid", gamma)
+	}
+	d := newDDSketch(gamma)
+	d.min = math.Float64frombits(binary.BigEndian.Uint64(data[8:]))
+	d.max = math.Float64frombits(binary.BigEndian.Uint64(data[16:]))
+	data = data[24:]
+	uvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, errors.New("sketch is truncated")
+		}
+		data = data[n:]
+		return v, nil
+	}
+	var err error
+	if d.zero, err = uvarint(); err != nil {
+		return err
+	}
+	d.count = d.zero
+	for _, buckets := range []map[int32]uint64{d.pos, d.neg} {
+		n, err := uvarint()
+		if err != nil {
+			return err
+		}
+		for ; n > 0; n-- {
+			i, k := binary.Varint(data)
+			if k <= 0 || i < math.MinInt32 || i > math.MaxInt32 {
+				return errors.New("sketch bucket is invalid")
+			}
+			data = data[k:]
+			c, err := uvarint()
+			if err != nil {
+				return err
+			}
+			buckets[int32(i)] += c
+			d.count += c
+		}
+	}
+	if len(data) > 0 {
+		return errors.New("sketch has trailing bytes")
+	}
+	*s = *d
+	return nil
+}
+
+// String encodes the sketch in base64, as stored in the serialized sketch fields
+func (s *DDSketch) String() string {
+	b, _ := s.MarshalBinary()
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// ParseDDSketch decodes a sketch encoded by String
+func ParseDDSketch(src string) (*DDSketch, error) {
+	b, err := base64.StdEncoding.DecodeString(src)
+	if err != nil {
+		return nil, err
+	}
+	var s DDSketch
+	if err := s.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// SketchQuantiles are the quantiles a sketch filter emits by default
+var SketchQuantiles = []float64{0.5, 0.95, 0.99}
+
+// SketchSuffix ends the name of the fields holding serialized sketches
+const SketchSuffix = "_sketch"
+
+// SketchOptions configures a sketch filter
+type SketchOptions struct {
+	// Size, Slide and GroupBy window and group measures as in WindowOptions
+	Size    time.Duration
+	Slide   time.Duration
+	GroupBy []string
+	// Fields are the fields sketched, every numeric field by default
+	Fields []string
+	// Quantiles are the quantiles emitted, SketchQuantiles by default
+	Quantiles []float64
+	// Accuracy is the relative accuracy of the quantiles, 0.01 by default
+	Accuracy float64
+	// Serialize adds the sketch of every field, so sketch filters downstream can merge it
+	Serialize bool
+}
+
+// Validate checks the window bounds, the quantiles and the accuracy
+func (o SketchOptions) Validate() error {
+	if err := (WindowOptions{Size: o.Size, Slide: o.Slide}).Validate(); err != nil {
+		return err
+	}
+	for _, q := range o.Quantiles {
+		if q < 0 || q > 1 {
+			return fmt.Errorf("quantile %v is out of [0, 1]", q)
+		}
+	}
+	if o.Accuracy < 0 || o.Accuracy >= 1 {
+		return fmt.Errorf("sketch accuracy must be between 0 and 1, got %v", o.Accuracy)
+	}
+	return nil
+}
+
+// NewSketchFilter returns a Filter keeping a DDSketch per field, group and window, grouped and windowed as
+// NewWindowFilter does. Every window emits a <field>_p<quantile> float field per quantile, as in
+// usage_p99 or usage_p99_9, a <field>_count uint field and, when serializing, a <field>_sketch string field.
+//
+// String fields ending in SketchSuffix hold sketches serialized upstream and merge into the sketch of their
+// field, so windows of several instances add up to the sketch of all their values
+func NewSketchFilter(opts SketchOptions) (Filter, error) {
+	return adaptedFilter(sketchAdapter(opts))
+}
+
+func sketchAdapter(opts SketchOptions) (FilterAdapter, FinalizeAdapter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if len(opts.Quantiles) == 0 {
+		opts.Quantiles = SketchQuantiles
+	}
+	if opts.Accuracy == 0 {
+		opts.Accuracy = 0.01
+	}
+	names := make([]string, len(opts.Quantiles))
+	for i, q := range opts.Quantiles {
+		names[i] = quantileName(q)
+	}
+	return windowedAdapter(windowSpec{
+		size:    opts.Size,
+		slide:   opts.Slide,
+		groupBy: opts.GroupBy,
+		fields:  opts.Fields,
+		field: func(fld Field) (string, bool) {
+			if s, ok := fld.Data.(string); ok && strings.HasSuffix(fld.Name, SketchSuffix) && s != "" {
+				return strings.TrimSuffix(fld.Name, SketchSuffix), true
+			}
+			return numericField(fld)
+		},
+		accumulator: func() windowAccumulator {
+			s, _ := NewDDSketch(opts.Accuracy)
+			return &sketchAcc{s: s, opts: opts, names: names}
+		},
+	})
+}
+
+// quantileName names the field of quantile q, p99_9 for 0.999
+func quantileName(q float64) string {
+	p := strconv.FormatFloat(math.Round(q*100*1e6)/1e6, 'f', -1, 64)
+	return "p" + strings.ReplaceAll(p, ".", "_")
+}
+
+type sketchAcc struct {
+	s     *DDSketch
+	opts  SketchOptions
+	names []string
+}
+
+func (a *sketchAcc) add(fld Field, t int64) error {
+	if src, ok := fld.Data.(string); ok {
+		s, err := ParseDDSketch(src)
+		if err != nil {
+			return err
+		}
+		return a.s.Merge(s)
+	}
+	return a.s.Add(toFloat(fld))
+}
+
+func (a *sketchAcc) appendFields(flds []Field, name string) []Field {
+	for i, q := range a.opts.Quantiles {
+		flds = append(flds, Field{Name: name + "_" + a.names[i], Type: TFloat, Data: a.s.Quantile(q)})
+	}
+	flds = append(flds, Field{Name: name + "_count", Type: TUint, Data: a.s.Count()})
+	if a.opts.Serialize {
+		flds = append(flds, Field{Name: name + SketchSuffix, Type: TString, Data: a.s.String()})
+	}
+	return flds
+}
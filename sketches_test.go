@@ -0,0 +1,132 @@
+package mstreamer
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDDSketch(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		q      float64
+		want   float64
+	}{
+		{name: `when values are 1 to 1000 then median is within accuracy`, values: valueRange(1, 1000), q: 0.5, want: 500},
+		{name: `when values are 1 to 1000 then p99 is within accuracy`, values: valueRange(1, 1000), q: 0.99, want: 990},
+		{name: `when values are negative then quantiles are too`, values: valueRange(-1000, -1), q: 0.1, want: -900},
+		{name: `when values hold zeros then zero is a quantile`, values: []float64{-1, 0, 0, 0, 1}, q: 0.5, want: 0},
+		{name: `when q is one then it is the max`, values: []float64{3, 7, 5}, q: 1, want: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewDDSketch(0.01)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range tt.values {
+				if err := s.Add(v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := s.Quantile(tt.q); math.Abs(got-tt.want) > math.Abs(tt.want)*0.01 {
+				t.Errorf("got quantile %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDDSketchMergeAndParse(t *testing.T) {
+	a, _ := NewDDSketch(0.01)
+	b, _ := NewDDSketch(0.01)
+	for _, v := range valueRange(1, 500) {
+		a.Add(v)
+	}
+	for _, v := range valueRange(501, 1000) {
+		b.Add(v)
+	}
+	parsed, err := ParseDDSketch(b.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Merge(parsed); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count() != 1000 {
+		t.Errorf("got count %v want 1000", a.Count())
+	}
+	if got := a.Quantile(0.9); math.Abs(got-900) > 9 {
+		t.Errorf("got p90 %v want 900", got)
+	}
+	other, _ := NewDDSketch(0.05)
+	if err := a.Merge(other); err == nil {
+		t.Error("want an error merging sketches of different accuracies")
+	}
+	if _, err := ParseDDSketch("AAAA"); err == nil {
+		t.Error("want an error parsing a truncated sketch")
+	}
+}
+
+func TestNewSketchFilter(t *testing.T) {
+	sec := int64(time.Second)
+	opts := SketchOptions{Size: time.Minute, Quantiles: []float64{0.5, 0.999}, Serialize: true}
+	var in []Measure
+	for i, v := range valueRange(1, 100) {
+		in = append(in, Measure{Name: "http", Flds: []Field{{"latency", TFloat, v}}, Time: int64(i%60) * sec})
+	}
+	first := runSketchFilter(t, opts, in)
+	if len(first) != 1 {
+		t.Fatalf("got %v windows want 1", len(first))
+	}
+	flds := make(map[string]interface{})
+	for _, f := range first[0].Flds {
+		flds[f.Name] = f.Data
+	}
+	if p := flds["latency_p50"].(float64); math.Abs(p-50) > 0.5 {
+		t.Errorf("got p50 %v want 50", p)
+	}
+	if _, ok := flds["latency_p99_9"]; !ok {
+		t.Errorf("got fields %v want latency_p99_9", flds)
+	}
+	if n := flds["latency_count"]; n != uint64(100) {
+		t.Errorf("got count %v want 100", n)
+	}
+	sketch := Measure{Name: "http", Flds: []Field{{"latency_sketch", TString, flds["latency_sketch"]}}}
+	merged := runSketchFilter(t, opts, []Measure{sketch, sketch})
+	if n := merged[0].Flds[2].Data; n != uint64(200) {
+		t.Errorf("got merged count %v want 200", n)
+	}
+}
+
+func runSketchFilter(t *testing.T, opts SketchOptions, in []Measure) []Measure {
+	flt, err := NewSketchFilter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w := NewChanMeasurePipe(len(in))
+	for _, m := range in {
+		w.Write(m)
+	}
+	w.Close()
+	fr, err := flt(func(string, ...interface{}) {}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []Measure
+	for {
+		var m Measure
+		if err := fr.Read(&m); err != nil {
+			return out
+		}
+		out = append(out, m)
+	}
+}
+
+func valueRange(from, to float64) []float64 {
+	var vs []float64
+	for v := from; v <= to; v++ {
+		vs = append(vs, v)
+	}
+	return vs
+}
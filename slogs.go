@@ -0,0 +1,137 @@
+//go:build go1.21
+
+package mstreamer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// NewSlogFeedback returns a Feedback logging every event to l, with the component, the path and the error
+// as attributes along the event ones
+func NewSlogFeedback(l *slog.Logger) (Feedback, error) {
+	if l == nil {
+		return nil, errors.New("logger is nil")
+	}
+	h := l.Handler()
+	return NewEventFeedback(func(e Event) {
+		ctx := context.Background()
+		level := slog.Level(e.Level)
+		if !h.Enabled(ctx, level) {
+			return
+		}
+		r := slog.NewRecord(e.Time, level, e.Msg, 0)
+		if e.Component != "" {
+			r.AddAttrs(slog.String("component", e.Component))
+		}
+		if e.Path != "" {
+			r.AddAttrs(slog.String("path", e.Path))
+		}
+		if e.Err != nil {
+			r.AddAttrs(slog.Any("err", e.Err))
+		}
+		for _, a := range e.Attrs {
+			r.AddAttrs(slog.Any(a.Key, a.Value))
+		}
+		h.Handle(ctx, r)
+	})
+}
+
+// NewFeedbackSlogHandler returns a slog.Handler turning records of level or above into events sent to f,
+// so code logging through slog reports to the pipeline Feedback. The component, path and err attributes
+// fill the matching event fields, the others become event attributes named after their groups
+func NewFeedbackSlogHandler(f Feedback, level slog.Leveler) (slog.Handler, error) {
+	if f == nil {
+		return nil, errors.New("feedback function is nil")
+	}
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return &feedbackHandler{f: f, level: level}, nil
+}
+
+type feedbackHandler struct {
+	f      Feedback
+	level  slog.Leveler
+	attrs  []slog.Attr
+	prefix string
+}
+
+func (h *feedbackHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *feedbackHandler) Handle(ctx context.Context, r slog.Record) error {
+	e := Event{Time: r.Time, Level: Level(r.Level), Msg: r.Message}
+	for _, a := range h.attrs {
+		h.add(&e, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		h.add(&e, h.prefix, a)
+		return true
+	})
+	h.f.Emit(e)
+	return nil
+}
+
+// add sets the event field matching a top level attribute or appends the attribute, flattening groups
+func (h *feedbackHandler) add(e *Event, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			h.add(e, prefix, ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	if prefix == "" {
+		switch a.Key {
+		case "component":
+			e.Component = v.String()
+			return
+		case "path":
+			e.Path = v.String()
+			return
+		case "err", "error":
+			if err, ok := v.Any().(error); ok {
+				e.Err = err
+				return
+			}
+		}
+	}
+	e.Attrs = append(e.Attrs, Attr{Key: prefix + a.Key, Value: v.Any()})
+}
+
+func (h *feedbackHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append(h.attrs[:len(h.attrs):len(h.attrs)], prefixAttrs(h.prefix, attrs)...)
+	return &c
+}
+
+func (h *feedbackHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix += name + "."
+	return &c
+}
+
+// prefixAttrs nests attrs under the groups opened so far, which are closed by the time Handle runs
+func prefixAttrs(prefix string, attrs []slog.Attr) []slog.Attr {
+	if prefix == "" {
+		return attrs
+	}
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		a.Key = prefix + a.Key
+		out[i] = a
+	}
+	return out
+}
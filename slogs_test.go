@@ -0,0 +1,53 @@
+//go:build go1.21
+
+package mstreamer
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewSlogFeedback(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	f, err := NewSlogFeedback(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = WithComponent(f, "http", "outputs[0].sinker")
+	f.Debug("hidden")
+	f.Error(errors.New("refused"), "post failed", "attempt", 2)
+	got := strings.TrimSpace(buf.String())
+	for _, want := range []string{`level=ERROR`, `msg="post failed"`, `component=http`, `path=outputs[0].sinker`, `err=refused`, `attempt=2`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q want it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "hidden") {
+		t.Errorf("got %q want debug events filtered", got)
+	}
+}
+
+func TestNewFeedbackSlogHandler(t *testing.T) {
+	var got []Event
+	f, _ := NewEventFeedback(func(e Event) { got = append(got, e) })
+	h, err := NewFeedbackSlogHandler(f, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errBoom := errors.New("boom")
+	l := slog.New(h).With("component", "exec").WithGroup("run")
+	l.Debug("hidden")
+	l.Warn("slow", "err", errBoom, "took", 3)
+	if len(got) != 1 {
+		t.Fatalf("got %v events want 1", len(got))
+	}
+	want := Event{Time: got[0].Time, Level: LevelWarn, Component: "exec", Msg: "slow", Attrs: []Attr{{"run.err", errBoom}, {"run.took", int64(3)}}}
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("got %+v want %+v", got[0], want)
+	}
+}
@@ -1,51 +1,96 @@
 package mstreamer
 
 import (
+	"context"
 	"errors"
 	"io"
-	"io/ioutil"
-	"net/http"
 )
 
 // SourceAdapter takes
 type SourceAdapter func(Feedback, io.Writer)
 
+// SourceAdapterContext is a SourceAdapter that should return once its context is done
+type SourceAdapterContext func(context.Context, Feedback, io.Writer)
+
 // SourceGetter takes
 type SourceGetter func() (io.ReadCloser, error)
 
+// SourceGetterContext is a SourceGetter that should abort its request once its context is done
+type SourceGetterContext func(context.Context) (io.ReadCloser, error)
+
 // NewBasicHTTPSource is a basic HTTP Source
 func NewBasicHTTPSource(url, user, pwd string) (Source, error) {
 	return newBasicHTTPSource(url, user, pwd)
 }
 
+// NewBasicHTTPSourceContext is a basic HTTP Source whose request is bound to the pipeline context
+func NewBasicHTTPSourceContext(url, user, pwd string) (SourceContext, error) {
+	return newBasicHTTPSourceContext(url, user, pwd)
+}
+
 // NewGetterSource takes
 func NewGetterSource(get SourceGetter) (Source, error) {
 	return newGetterSource(get, io.Copy)
 }
 
+// NewGetterSourceContext returns a SourceContext that copies the reader returned by get
+// and closes it when the context is done
+func NewGetterSourceContext(get SourceGetterContext) (SourceContext, error) {
+	return newGetterSourceContext(get, io.Copy)
+}
+
 // NewSource returns a NewSource component using a getter function as its input stream
 func NewSource(adapter SourceAdapter) (Source, error) {
 	return newSource(adapter, io.Pipe)
 }
 
+// NewSourceContext returns a SourceContext whose stream is closed, ending it gracefully,
+// when the context is done
+func NewSourceContext(adapter SourceAdapterContext) (SourceContext, error) {
+	return newSourceContext(adapter, io.Pipe)
+}
+
 func newSource(
 	adapter SourceAdapter,
 	ioPipe func() (*io.PipeReader, *io.PipeWriter),
 ) (Source, error) {
+	if adapter == nil {
+		return nil, errors.New("adapter is nil")
+	}
+	src, err := newSourceContext(func(ctx context.Context, f Feedback, w io.Writer) {
+		adapter(f, w)
+	}, ioPipe)
+	if err != nil {
+		return nil, err
+	}
+	return func(f Feedback) (io.ReadCloser, error) {
+		return src(context.Background(), f)
+	}, nil
+}
+
+func newSourceContext(
+	adapter SourceAdapterContext,
+	ioPipe func() (*io.PipeReader, *io.PipeWriter),
+) (SourceContext, error) {
 	if adapter == nil {
 		return nil, errors.New("adapter is nil")
 	}
 	if ioPipe == nil {
 		return nil, errors.New("ioPipe is nil")
 	}
-	return func(f Feedback) (io.ReadCloser, error) {
+	return func(ctx context.Context, f Feedback) (io.ReadCloser, error) {
+		if ctx == nil {
+			return nil, errors.New("context is nil")
+		}
 		if f == nil {
 			return nil, errors.New("feedback funcion is nil")
 		}
 		pr, pw := ioPipe()
 		go func() {
+			stop := onDone(ctx, func() { pw.Close() })
+			defer stop()
 			defer pw.Close()
-			adapter(f, pw)
+			adapter(ctx, f, pw)
 		}()
 		return pr, nil
 	}, nil
@@ -55,42 +100,56 @@ func newGetterSource(
 	get SourceGetter,
 	iocopy func(io.Writer, io.Reader) (int64, error),
 ) (Source, error) {
+	if get == nil {
+		return nil, errors.New("get function is nil")
+	}
+	src, err := newGetterSourceContext(func(context.Context) (io.ReadCloser, error) {
+		return get()
+	}, iocopy)
+	if err != nil {
+		return nil, err
+	}
+	return func(f Feedback) (io.ReadCloser, error) {
+		return src(context.Background(), f)
+	}, nil
+}
+
+func newGetterSourceContext(
+	get SourceGetterContext,
+	iocopy func(io.Writer, io.Reader) (int64, error),
+) (SourceContext, error) {
 	if get == nil {
 		return nil, errors.New("get function is nil")
 	}
 	if iocopy == nil {
 		return nil, errors.New("iocopy function is nil")
 	}
-	adapter := func(f Feedback, w io.Writer) {
-		r, err := get()
+	adapter := func(ctx context.Context, f Feedback, w io.Writer) {
+		r, err := get(ctx)
 		if err != nil {
 			f("error on retrieving reader %v", err)
 			return
 		}
-		if _, err := iocopy(w, r); err != nil {
+		defer r.Close()
+		stop := onDone(ctx, func() { r.Close() })
+		defer stop()
+		if _, err := iocopy(w, r); err != nil && ctx.Err() == nil {
 			f("error on writing %v", err)
 		}
 	}
-	return NewSource(adapter)
+	return NewSourceContext(adapter)
 }
 
 func newBasicHTTPSource(url, user, pwd string) (Source, error) {
-	get := func() (io.ReadCloser, error) {
-		client := &http.Client{}
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return nil, err
-		}
-		req.SetBasicAuth(user, pwd)
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		if resp.StatusCode != 200 {
-			body, _ := ioutil.ReadAll(resp.Body) // consumes all body before leaves
-			return nil, errors.New(resp.Status + ":" + string(body))
-		}
-		return resp.Body, nil
+	src, err := newBasicHTTPSourceContext(url, user, pwd)
+	if err != nil {
+		return nil, err
 	}
-	return NewGetterSource(get)
+	return func(f Feedback) (io.ReadCloser, error) {
+		return src(context.Background(), f)
+	}, nil
+}
+
+func newBasicHTTPSourceContext(url, user, pwd string) (SourceContext, error) {
+	return newHTTPSourceContext(url, user, pwd, newRetrier(RetryPolicy{}))
 }
@@ -0,0 +1,425 @@
+package mstreamer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// StatsDTypeTag is the tag holding the metric type (counter, gauge, timer, histogram, distribution
+	// or set) of the measures parsed by the StatsD encoder
+	StatsDTypeTag = "__statsd_type__"
+	// DefaultStatsDFlushInterval is the flush interval of StatsD aggregation by default
+	DefaultStatsDFlushInterval = 10 * time.Second
+)
+
+// StatsDPercentiles are the timer percentiles StatsD aggregation emits by default
+var StatsDPercentiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+var statsdTypes = map[string]string{
+	"c":  "counter",
+	"g":  "gauge",
+	"ms": "timer",
+	"h":  "histogram",
+	"d":  "distribution",
+	"s":  "set",
+}
+
+// NewStatsDEncoder returns an Encoder that parses StatsD lines, with the DogStatsD extensions, into a
+// measure per sample. See ParseStatsD. Events and service checks are skipped
+func NewStatsDEncoder() (Encoder, error) {
+	return NewEncoder(statsdEncoderAdapter)
+}
+
+// ParseStatsD parses a StatsD line as in name:value|type|@rate|#tag:value,tag into a measure per value,
+// DogStatsD packing several values as in name:1:2|ms. Measures are tagged with the DogStatsD tags, a
+// tag without value being "true", and with StatsDTypeTag. They hold a value float field, a string one for
+// sets, or a delta float field for gauges given as +n or -n, and a sample_rate float field when the line
+// is sampled. Their time is the T unix timestamp of the line, or now
+func ParseStatsD(line []byte, now int64) ([]Measure, error) {
+	colon := bytes.IndexByte(line, ':')
+	pipe := bytes.IndexByte(line, '|')
+	if colon <= 0 || pipe < colon {
+		return nil, errors.New("expected name:value|type")
+	}
+	name := string(line[:colon])
+	sections := strings.Split(string(line[pipe+1:]), "|")
+	typ, ok := statsdTypes[sections[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric type %q", sections[0])
+	}
+	var (
+		tags []Tag
+		rate = 1.0
+		err  error
+	)
+	for _, s := range sections[1:] {
+		switch {
+		case strings.HasPrefix(s, "@"):
+			if rate, err = strconv.ParseFloat(s[1:], 64); err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid sample rate %q", s[1:])
+			}
+		case strings.HasPrefix(s, "#"):
+			for _, t := range strings.Split(s[1:], ",") {
+				if t == "" {
+					continue
+				}
+				k, v, found := strings.Cut(t, ":")
+				if !found {
+					v = "true"
+				}
+				tags = append(tags, MakeTag(k, v))
+			}
+		case strings.HasPrefix(s, "T"):
+			ts, err := strconv.ParseInt(s[1:], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %q", s[1:])
+			}
+			now = ts * int64(time.Second)
+		}
+	}
+	tags = append(tags, MakeTag(StatsDTypeTag, typ))
+	var ms []Measure
+	for _, value := range strings.Split(string(line[colon+1:pipe]), ":") {
+		m := Measure{Name: name, Tags: append([]Tag(nil), tags...), Time: now}
+		switch {
+		case typ == "set":
+			m.Flds = []Field{{Name: "value", Type: TString, Data: value}}
+		case value == "":
+			return nil, errors.New("value is empty")
+		default:
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", value)
+			}
+			fld := "value"
+			if typ == "gauge" && (value[0] == '+' || value[0] == '-') {
+				fld = "delta"
+			}
+			m.Flds = []Field{{Name: fld, Type: TFloat, Data: v}}
+		}
+		if rate != 1 {
+			m.Flds = append(m.Flds, Field{Name: "sample_rate", Type: TFloat, Data: rate})
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+func statsdEncoderAdapter(f Feedback, r io.Reader, w MeasureWriter) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 || bytes.HasPrefix(line, []byte("_e{")) || bytes.HasPrefix(line, []byte("_sc|")) {
+			continue
+		}
+		ms, err := ParseStatsD(line, time.Now().UnixNano())
+		if err != nil {
+			f("statsd error at line %v: %v", n, err)
+			continue
+		}
+		for _, m := range ms {
+			if err := w.Write(m); err != nil {
+				f("statsd write error: %v", err)
+				return
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f("statsd read error: %v", err)
+	}
+}
+
+// StatsDAggregateOptions configures a StatsD aggregate filter
+type StatsDAggregateOptions struct {
+	// FlushInterval is the time between flushes, DefaultStatsDFlushInterval when zero
+	FlushInterval time.Duration
+	// Percentiles are the timer percentiles emitted, StatsDPercentiles by default
+	Percentiles []float64
+	// DeleteGauges forgets the gauges not updated since the last flush instead of emitting their value again
+	DeleteGauges bool
+}
+
+// Validate checks the flush interval and the percentiles
+func (o StatsDAggregateOptions) Validate() error {
+	if o.FlushInterval < 0 {
+		return errors.New("flush interval must not be negative")
+	}
+	for _, p := range o.Percentiles {
+		if p <= 0 || p > 1 {
+			return fmt.Errorf("percentile %v is out of (0, 1]", p)
+		}
+	}
+	return nil
+}
+
+// NewStatsDAggregateFilterContext returns a FilterContext aggregating the measures of the StatsD encoder
+// per series, their name and tags, and emitting them every flush interval and at the end of the stream,
+// stamped with the flush time and without StatsDTypeTag:
+//
+//   - counters get a count float field, the sum of their values scaled by their sample rates, and a rate
+//     float field, the count per second
+//   - gauges get a value float field, the last value set plus the deltas since. They are emitted at every
+//     flush until deleted
+//   - timers, histograms and distributions get count, sum, mean, min and max float fields and a
+//     p<percentile> float field per percentile, as in p90 or p99_9, the count being scaled by sample rates
+//   - sets get a count uint field, the number of distinct values
+//
+// Series without sample since the last flush are not emitted, but for gauges. Measures without
+// StatsDTypeTag are passed on unchanged
+func NewStatsDAggregateFilterContext(opts StatsDAggregateOptions) (FilterContext, error) {
+	return newStatsDAggregateFilterContext(opts, time.Now)
+}
+
+func newStatsDAggregateFilterContext(opts StatsDAggregateOptions, now func() time.Time) (FilterContext, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = DefaultStatsDFlushInterval
+	}
+	if len(opts.Percentiles) == 0 {
+		opts.Percentiles = StatsDPercentiles
+	}
+	names := make([]string, len(opts.Percentiles))
+	for i, p := range opts.Percentiles {
+		names[i] = quantileName(p)
+	}
+	return func(ctx context.Context, f Feedback, r MeasureReader) (MeasureReader, error) {
+		if ctx == nil {
+			return nil, errors.New("context is nil")
+		}
+		if f == nil {
+			return nil, errors.New("feedback funcion is nil")
+		}
+		if r == nil {
+			return nil, errors.New("reader stream is nil")
+		}
+		mr, mw := ChanMeasurePipe(ctx)
+		s := stageTelemetry(ctx, "filter")
+		go func() {
+			dctx, cancel := drainContext(ctx)
+			defer cancel()
+			stop := onDone(dctx, func() {
+				mw.CloseWithError(context.Cause(dctx))
+				closeMeasureReader(r)
+			})
+			defer stop()
+			defer mw.Close()
+			w := s.writer(mw)
+
+			measures := make(chan Measure)
+			go func() {
+				defer close(measures)
+				for {
+					var m Measure
+					err := r.Read(&m)
+					if err != nil {
+						if err == io.EOF || aborted(dctx, err) {
+							return
+						}
+						s.addFailed(1)
+						f("statsd aggregate read error: %v", err)
+						continue
+					}
+					measures <- m
+				}
+			}()
+
+			agg := &statsdAggregator{opts: opts, names: names, series: make(map[string]*statsdSeries), last: now()}
+			ticker := time.NewTicker(opts.FlushInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case m, ok := <-measures:
+					if !ok {
+						agg.flush(w, now())
+						return
+					}
+					s.addRead(1)
+					aggregated, err := agg.add(m)
+					switch {
+					case err != nil:
+						f.Warn("statsd measure dropped", "measure", m.Name, "err", err)
+						s.addDropped(1)
+					case !aggregated:
+						w.Write(m)
+					}
+				case <-ticker.C:
+					agg.flush(w, now())
+				}
+			}
+		}()
+		return mr, nil
+	}, nil
+}
+
+type statsdSeries struct {
+	name    string
+	tags    []Tag
+	typ     string
+	updated bool
+	count   float64
+	value   float64
+	values  []float64
+	set     map[string]struct{}
+}
+
+// statsdAggregator keeps the series sampled since the last flush, and the gauges
+type statsdAggregator struct {
+	opts   StatsDAggregateOptions
+	names  []string
+	series map[string]*statsdSeries
+	last   time.Time
+}
+
+// add aggregates m, telling false for measures without StatsDTypeTag
+func (a *statsdAggregator) add(m Measure) (bool, error) {
+	var (
+		typ  string
+		tags []Tag
+	)
+	for _, t := range m.Tags {
+		if t.Name == StatsDTypeTag {
+			typ = t.Data
+		} else {
+			tags = append(tags, t)
+		}
+	}
+	if typ == "" {
+		return false, nil
+	}
+	var (
+		value, delta *Field
+		rate         = 1.0
+	)
+	for i, fld := range m.Flds {
+		switch fld.Name {
+		case "value":
+			value = &m.Flds[i]
+		case "delta":
+			delta = &m.Flds[i]
+		case "sample_rate":
+			if rate = toFloat(fld); rate <= 0 || rate > 1 {
+				return true, fmt.Errorf("invalid sample rate %v", fld.Data)
+			}
+		}
+	}
+	if value == nil && (delta == nil || typ != "gauge") {
+		return true, errors.New("value field is missing")
+	}
+	key := typ + " " + seriesKey(m.Name, tags)
+	ss, ok := a.series[key]
+	if !ok {
+		switch typ {
+		case "counter", "gauge", "timer", "histogram", "distribution", "set":
+		default:
+			return true, fmt.Errorf("unknown metric type %q", typ)
+		}
+		ss = &statsdSeries{name: m.Name, tags: tags, typ: typ}
+	}
+	switch typ {
+	case "set":
+		if ss.set == nil {
+			ss.set = make(map[string]struct{})
+		}
+		if s, ok := value.Data.(string); ok {
+			ss.set[s] = struct{}{}
+		} else {
+			ss.set[fmt.Sprint(value.Data)] = struct{}{}
+		}
+	case "gauge":
+		if value != nil {
+			ss.value = toFloat(*value)
+		} else {
+			ss.value += toFloat(*delta)
+		}
+	default:
+		v := toFloat(*value)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return true, fmt.Errorf("invalid value %v", value.Data)
+		}
+		if typ == "counter" {
+			ss.count += v / rate
+		} else {
+			ss.count += 1 / rate
+			ss.values = append(ss.values, v)
+		}
+	}
+	ss.updated = true
+	a.series[key] = ss
+	return true, nil
+}
+
+// flush writes the series sampled since the last flush and the gauges, forgetting all but the gauges
+func (a *statsdAggregator) flush(w MeasureWriter, now time.Time) {
+	elapsed := now.Sub(a.last).Seconds()
+	if elapsed <= 0 {
+		elapsed = a.opts.FlushInterval.Seconds()
+	}
+	a.last = now
+	for _, key := range sortedKeys(a.series) {
+		ss := a.series[key]
+		if !ss.updated && (ss.typ != "gauge" || a.opts.DeleteGauges) {
+			delete(a.series, key)
+			continue
+		}
+		m := Measure{Name: ss.name, Tags: ss.tags, Time: now.UnixNano()}
+		switch ss.typ {
+		case "counter":
+			m.Flds = []Field{
+				{Name: "count", Type: TFloat, Data: ss.count},
+				{Name: "rate", Type: TFloat, Data: ss.count / elapsed},
+			}
+		case "gauge":
+			m.Flds = []Field{{Name: "value", Type: TFloat, Data: ss.value}}
+		case "set":
+			m.Flds = []Field{{Name: "count", Type: TUint, Data: uint64(len(ss.set))}}
+		default:
+			m.Flds = a.timerFields(ss)
+		}
+		w.Write(m)
+		if ss.typ == "gauge" {
+			ss.updated = false
+		} else {
+			delete(a.series, key)
+		}
+	}
+}
+
+func (a *statsdAggregator) timerFields(ss *statsdSeries) []Field {
+	values := ss.values
+	sort.Float64s(values)
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	flds := []Field{
+		{Name: "count", Type: TFloat, Data: ss.count},
+		{Name: "sum", Type: TFloat, Data: sum},
+		{Name: "mean", Type: TFloat, Data: sum / float64(len(values))},
+		{Name: "min", Type: TFloat, Data: values[0]},
+		{Name: "max", Type: TFloat, Data: values[len(values)-1]},
+	}
+	for i, p := range a.opts.Percentiles {
+		// nearest rank
+		rank := int(math.Ceil(p*float64(len(values)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		flds = append(flds, Field{Name: a.names[i], Type: TFloat, Data: values[rank]})
+	}
+	return flds
+}
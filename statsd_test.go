@@ -0,0 +1,198 @@
+package mstreamer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStatsD(t *testing.T) {
+	typ := func(v string) Tag { return Tag{StatsDTypeTag, v} }
+	tests := []struct {
+		name    string
+		line    string
+		want    []Measure
+		wantErr bool
+	}{
+		{
+			name: `when counter is sampled and tagged then rate and tags are kept`,
+			line: "hits:2|c|@0.5|#env:prod,canary",
+			want: []Measure{{Name: "hits", Tags: []Tag{{"env", "prod"}, {"canary", "true"}, typ("counter")},
+				Flds: []Field{{"value", TFloat, 2.0}, {"sample_rate", TFloat, 0.5}}, Time: 7}},
+		},
+		{
+			name: `when gauge is signed then it is a delta`,
+			line: "load:-1.5|g",
+			want: []Measure{{Name: "load", Tags: []Tag{typ("gauge")}, Flds: []Field{{"delta", TFloat, -1.5}}, Time: 7}},
+		},
+		{
+			name: `when timer packs values then every value is a measure`,
+			line: "latency:10:20|ms|T1700000000",
+			want: []Measure{
+				{Name: "latency", Tags: []Tag{typ("timer")}, Flds: []Field{{"value", TFloat, 10.0}}, Time: 1700000000 * int64(time.Second)},
+				{Name: "latency", Tags: []Tag{typ("timer")}, Flds: []Field{{"value", TFloat, 20.0}}, Time: 1700000000 * int64(time.Second)},
+			},
+		},
+		{
+			name: `when metric is a set then its value is a string`,
+			line: "users:alice|s",
+			want: []Measure{{Name: "users", Tags: []Tag{typ("set")}, Flds: []Field{{"value", TString, "alice"}}, Time: 7}},
+		},
+		{name: `when type is missing then it fails`, line: "hits:1", wantErr: true},
+		{name: `when type is unknown then it fails`, line: "hits:1|x", wantErr: true},
+		{name: `when value is not a number then it fails`, line: "hits:one|c", wantErr: true},
+		{name: `when sample rate is out of range then it fails`, line: "hits:1|c|@2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStatsD([]byte(tt.line), 7)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsDAggregateFilter(t *testing.T) {
+	start := time.Unix(1000, 0)
+	parse := func(lines ...string) []Measure {
+		var ms []Measure
+		for _, l := range lines {
+			m, err := ParseStatsD([]byte(l), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ms = append(ms, m...)
+		}
+		return ms
+	}
+	tests := []struct {
+		name string
+		opts StatsDAggregateOptions
+		in   []Measure
+		want []Measure
+	}{
+		{
+			name: `when counters are sampled then counts are scaled`,
+			in:   parse("hits:1|c|#env:prod", "hits:2|c|@0.5|#env:prod", "hits:1|c|#env:dev"),
+			want: []Measure{
+				{Name: "hits", Tags: []Tag{{"env", "dev"}}, Flds: []Field{{"count", TFloat, 1.0}, {"rate", TFloat, 0.1}}},
+				{Name: "hits", Tags: []Tag{{"env", "prod"}}, Flds: []Field{{"count", TFloat, 5.0}, {"rate", TFloat, 0.5}}},
+			},
+		},
+		{
+			name: `when gauge gets deltas then they apply to its value`,
+			in:   parse("load:3|g", "load:+2|g", "load:-1|g"),
+			want: []Measure{{Name: "load", Flds: []Field{{"value", TFloat, 4.0}}}},
+		},
+		{
+			name: `when timer gets values then percentiles are emitted`,
+			opts: StatsDAggregateOptions{Percentiles: []float64{0.5, 0.9}},
+			in:   parse("latency:4:1:3:2|ms", "latency:5|ms|@0.5"),
+			want: []Measure{{Name: "latency", Flds: []Field{
+				{"count", TFloat, 6.0}, {"sum", TFloat, 15.0}, {"mean", TFloat, 3.0}, {"min", TFloat, 1.0}, {"max", TFloat, 5.0},
+				{"p50", TFloat, 3.0}, {"p90", TFloat, 5.0}}}},
+		},
+		{
+			name: `when set gets repeated values then distinct ones are counted`,
+			in:   parse("users:alice|s", "users:bob|s", "users:alice|s"),
+			want: []Measure{{Name: "users", Flds: []Field{{"count", TUint, uint64(2)}}}},
+		},
+		{
+			name: `when measure is not statsd then it is passed on`,
+			in:   []Measure{{Name: "cpu", Flds: []Field{{"usage", TFloat, 1.0}}}},
+			want: []Measure{{Name: "cpu", Flds: []Field{{"usage", TFloat, 1.0}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := start
+			flt, err := newStatsDAggregateFilterContext(tt.opts, func() time.Time {
+				now := clock
+				clock = clock.Add(10 * time.Second)
+				return now
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, w := NewChanMeasurePipe(len(tt.in))
+			for _, m := range tt.in {
+				w.Write(m)
+			}
+			w.Close()
+			fr, err := flt(context.Background(), func(string, ...interface{}) {}, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []Measure
+			for {
+				var m Measure
+				if err := fr.Read(&m); err != nil {
+					break
+				}
+				if m.Name != "cpu" {
+					if m.Time != start.Add(10*time.Second).UnixNano() {
+						t.Errorf("got time %v want the flush time", m.Time)
+					}
+					m.Time = 0
+				}
+				got = append(got, m)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsDAggregateFilterFlushes(t *testing.T) {
+	flt, err := NewStatsDAggregateFilterContext(StatsDAggregateOptions{FlushInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w := NewChanMeasurePipe(0)
+	defer w.Close()
+	fr, err := flt(context.Background(), func(string, ...interface{}) {}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, _ := ParseStatsD([]byte("load:3|g"), 0)
+	hits, _ := ParseStatsD([]byte("hits:1|c"), 0)
+	w.Write(ms[0])
+	w.Write(hits[0])
+	seen := map[string]int{}
+	// the gauge is emitted again by the flushes without samples, the counter is not
+	for seen["load"] < 3 {
+		var m Measure
+		if err := fr.Read(&m); err != nil {
+			t.Fatal(err)
+		}
+		seen[m.Name]++
+	}
+	if seen["hits"] != 1 {
+		t.Errorf("got counter %v times want once", seen["hits"])
+	}
+}
+
+func TestStatsDAggregateOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    StatsDAggregateOptions
+		wantErr bool
+	}{
+		{name: `when flush interval is negative then it fails`, opts: StatsDAggregateOptions{FlushInterval: -time.Second}, wantErr: true},
+		{name: `when percentile is out of range then it fails`, opts: StatsDAggregateOptions{Percentiles: []float64{0}}, wantErr: true},
+		{name: `when options are default then they pass`, opts: StatsDAggregateOptions{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
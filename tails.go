@@ -0,0 +1,264 @@
+package mstreamer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DefaultTailPollInterval is how often a tail source checks its files by default
+const DefaultTailPollInterval = time.Second
+
+// TailOptions configures a tail source
+type TailOptions struct {
+	// Path is the file followed, or a glob of files
+	Path string
+	// FromStart reads the files found on start from their beginning instead of their end.
+	// Files showing up later are always read from their beginning
+	FromStart bool
+	// StateFile keeps the read offset of every file across runs, empty starts over on every run
+	StateFile string
+	// PollInterval is how often files are checked for new lines, rotation and truncation
+	PollInterval time.Duration
+}
+
+// Validate checks the path and the poll interval
+func (o TailOptions) Validate() error {
+	if o.Path == "" {
+		return errors.New("tail path is empty")
+	}
+	if _, err := filepath.Match(o.Path, ""); err != nil {
+		return err
+	}
+	if o.PollInterval < 0 {
+		return errors.New("poll interval must not be negative")
+	}
+	return nil
+}
+
+// NewTailSourceContext returns a SourceContext following files like tail -F until its context is done.
+// Only whole lines are streamed. A file replaced under its path is read to its end before the new one
+// is read from its beginning, and a file shrinking below the read offset was truncated and is read again
+// from its beginning. With a state file the offsets of the lines streamed are saved after every poll,
+// so a restart resumes each file where it stopped unless the file changed in between
+func NewTailSourceContext(opts TailOptions) (SourceContext, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = DefaultTailPollInterval
+	}
+	return NewSourceContext(func(ctx context.Context, f Feedback, w io.Writer) {
+		t := &tailer{opts: opts, f: f, w: w, files: make(map[string]*tailFile), state: make(map[string]tailState)}
+		if err := t.load(); err != nil {
+			f.Error(err, "cannot load tail state", "state_file", opts.StateFile)
+		}
+		defer t.close()
+		defer t.save()
+		ticker := time.NewTicker(opts.PollInterval)
+		defer ticker.Stop()
+		for first := true; ; first = false {
+			if err := t.poll(first); err != nil {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// tailState is the persisted offset of a file
+type tailState struct {
+	ID     uint64 `json:"id"`
+	Offset int64  `json:"offset"`
+}
+
+type tailFile struct {
+	path    string
+	f       *os.File
+	fi      os.FileInfo
+	offset  int64
+	partial []byte
+}
+
+type tailer struct {
+	opts  TailOptions
+	f     Feedback
+	w     io.Writer
+	files map[string]*tailFile
+	state map[string]tailState
+	saved []byte
+}
+
+// poll streams the new lines of every file matching the path, returning write errors only
+func (t *tailer) poll(first bool) error {
+	paths, _ := filepath.Glob(t.opts.Path)
+	sort.Strings(paths)
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		seen[path] = true
+		tf, rotated := t.files[path], false
+		if tf != nil && !os.SameFile(tf.fi, fi) {
+			t.f.Info("file rotated", "path", path)
+			if err := t.drop(tf); err != nil {
+				return err
+			}
+			tf, rotated = nil, true
+		}
+		if tf == nil {
+			if tf, err = t.open(path, first && !rotated && !t.opts.FromStart); err != nil {
+				t.f.Error(err, "cannot open file", "path", path)
+				continue
+			}
+			t.files[path] = tf
+		} else if fi.Size() < tf.offset+int64(len(tf.partial)) {
+			t.f.Info("file truncated", "path", path)
+			if _, err := tf.f.Seek(0, io.SeekStart); err != nil {
+				t.f.Error(err, "cannot rewind file", "path", path)
+				continue
+			}
+			tf.offset, tf.partial = 0, nil
+		}
+		if err := t.read(tf); err != nil {
+			return err
+		}
+	}
+	for path, tf := range t.files {
+		if !seen[path] {
+			if err := t.drop(tf); err != nil {
+				return err
+			}
+		}
+	}
+	t.save()
+	return nil
+}
+
+// open opens a file at its saved offset, or at its end when atEnd, or at its beginning
+func (t *tailer) open(path string, atEnd bool) (*tailFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tf := &tailFile{path: path, f: f, fi: fi}
+	if st, ok := t.state[path]; ok {
+		if st.ID == fileID(fi) && st.Offset <= fi.Size() {
+			tf.offset = st.Offset
+		}
+	} else if atEnd {
+		tf.offset = fi.Size()
+	}
+	if _, err := f.Seek(tf.offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return tf, nil
+}
+
+// read streams the whole lines appended to a file, keeping the last partial line for the next read
+func (t *tailer) read(tf *tailFile) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := tf.f.Read(buf)
+		if n > 0 {
+			data := append(tf.partial, buf[:n]...)
+			if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+				if _, err := t.w.Write(data[:i+1]); err != nil {
+					return err
+				}
+				tf.offset += int64(i + 1)
+				data = append([]byte(nil), data[i+1:]...)
+			}
+			tf.partial = data
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			t.f.Error(err, "cannot read file", "path", tf.path)
+			return nil
+		}
+	}
+}
+
+// drop reads a file gone from its path to its end, streaming its last partial line, and closes it
+func (t *tailer) drop(tf *tailFile) error {
+	defer delete(t.files, tf.path)
+	defer tf.f.Close()
+	if err := t.read(tf); err != nil {
+		return err
+	}
+	if len(tf.partial) > 0 {
+		if _, err := t.w.Write(append(tf.partial, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tailer) close() {
+	for _, tf := range t.files {
+		tf.f.Close()
+	}
+}
+
+func (t *tailer) load() error {
+	if t.opts.StateFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(t.opts.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &t.state)
+}
+
+// save writes the offsets of the files followed to the state file when they changed, through a rename
+// so the file is never partial
+func (t *tailer) save() {
+	state := make(map[string]tailState, len(t.files))
+	for path, tf := range t.files {
+		state[path] = tailState{ID: fileID(tf.fi), Offset: tf.offset}
+	}
+	t.state = state
+	if t.opts.StateFile == "" {
+		return
+	}
+	b, err := json.Marshal(state)
+	if bytes.Equal(b, t.saved) {
+		return
+	}
+	if err == nil {
+		tmp := t.opts.StateFile + ".tmp"
+		if err = os.WriteFile(tmp, b, 0o644); err == nil {
+			err = os.Rename(tmp, t.opts.StateFile)
+		}
+	}
+	if err == nil {
+		t.saved = b
+	}
+	if err != nil {
+		t.f.Error(err, "cannot save tail state", "state_file", t.opts.StateFile)
+	}
+}
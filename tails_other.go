@@ -0,0 +1,10 @@
+//go:build !unix
+
+package mstreamer
+
+import "os"
+
+// fileID returns zero where inodes are not available, so saved offsets are only checked against file sizes
+func fileID(fi os.FileInfo) uint64 {
+	return 0
+}
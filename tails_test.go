@@ -0,0 +1,103 @@
+package mstreamer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewTailSourceContext(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	state := filepath.Join(dir, "state.json")
+	appendTo := func(path, s string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		f.WriteString(s)
+	}
+	start := func() (func(), chan string) {
+		src, err := NewTailSourceContext(TailOptions{Path: filepath.Join(dir, "*.log"), StateFile: state, PollInterval: 5 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		r, err := src(ctx, func(string, ...interface{}) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := make(chan string, 16)
+		go func() {
+			defer close(lines)
+			s := bufio.NewScanner(r)
+			for s.Scan() {
+				lines <- s.Text()
+			}
+		}()
+		return func() {
+			cancel()
+			for range lines {
+			}
+		}, lines
+	}
+	expect := func(lines chan string, want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-lines:
+				if got != w {
+					t.Fatalf("got line %q want %q", got, w)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for line %q", w)
+			}
+		}
+	}
+	waitSaved := func(offset int) {
+		t.Helper()
+		want := fmt.Sprintf(`"offset":%v}`, offset)
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+			if b, _ := os.ReadFile(state); strings.Contains(string(b), want) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for offset %v to be saved", offset)
+			}
+		}
+	}
+
+	appendTo(path, "before start\n")
+	stop, lines := start()
+	waitSaved(13)
+	appendTo(path, "a\nb")
+	expect(lines, "a")
+	appendTo(path, "c\n")
+	expect(lines, "bc")
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendTo(path+".1", "last of rotated\n")
+	appendTo(path, "dddd\n")
+	expect(lines, "last of rotated", "dddd")
+
+	if err := os.WriteFile(path, []byte("e\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expect(lines, "e")
+	waitSaved(2)
+	stop()
+
+	appendTo(path, "while stopped\n")
+	stop, lines = start()
+	expect(lines, "while stopped")
+	waitSaved(16)
+	stop()
+}
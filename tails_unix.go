@@ -0,0 +1,16 @@
+//go:build unix
+
+package mstreamer
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID returns the inode of a file, which a rotated file does not share with its replacement
+func fileID(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,316 @@
+package mstreamer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TelemetryBuckets are the upper bounds, in seconds, of the stage latency histograms
+var TelemetryBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5, 10}
+
+// Telemetry collects the counters and latencies of the stages run with a context carrying it.
+//
+// Filters count the measures they read, write and drop, and time every adapter call.
+// Encoders and decoders count the measures they write or read and time the whole stream.
+// Merged inputs count what they read and forward, merged outputs count what they read, queue and
+// drop and time the fan-out of every measure. Sinkers count the streams they read and time every one.
+// Read and write errors, and sinker errors, are counted as failures
+type Telemetry struct {
+	mu     sync.Mutex
+	stages map[stageKey]*stageStats
+}
+
+type stageKey struct {
+	kind string
+	name string
+}
+
+// NewTelemetry returns an empty Telemetry
+func NewTelemetry() *Telemetry {
+	return &Telemetry{stages: make(map[stageKey]*stageStats)}
+}
+
+type telemetryKey struct{}
+
+type stageNameKey struct{}
+
+// WithTelemetry returns a copy of ctx making the stages run with it record into t
+func WithTelemetry(ctx context.Context, t *Telemetry) context.Context {
+	return context.WithValue(ctx, telemetryKey{}, t)
+}
+
+// WithStageName returns a copy of ctx naming the stages run with it in the telemetry.
+// Unnamed stages are recorded under their kind
+func WithStageName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, stageNameKey{}, name)
+}
+
+// StageStats is a snapshot of the telemetry of a stage
+type StageStats struct {
+	Kind    string
+	Name    string
+	Read    uint64
+	Written uint64
+	Dropped uint64
+	Failed  uint64
+	// Buckets holds the cumulative count of latencies up to each of TelemetryBuckets
+	Buckets []uint64
+	// Count is the number of latencies observed and Sum their total
+	Count uint64
+	Sum   time.Duration
+}
+
+// Stats returns a snapshot of every stage sorted by kind and name
+func (t *Telemetry) Stats() []StageStats {
+	t.mu.Lock()
+	keys := make([]stageKey, 0, len(t.stages))
+	for k := range t.stages {
+		keys = append(keys, k)
+	}
+	stages := make([]*stageStats, 0, len(keys))
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].kind != keys[j].kind {
+			return keys[i].kind < keys[j].kind
+		}
+		return keys[i].name < keys[j].name
+	})
+	for _, k := range keys {
+		stages = append(stages, t.stages[k])
+	}
+	t.mu.Unlock()
+	stats := make([]StageStats, len(keys))
+	for i, s := range stages {
+		stats[i] = s.snapshot(keys[i])
+	}
+	return stats
+}
+
+// Measures returns the stats of every stage as measures stamped with now, tagged with the stage kind
+// and name. Counters are named mstreamer_stage_<counter> and latencies mstreamer_stage_latency_seconds,
+// typed through PromTypeTag so the Prometheus output exposes them as counters and histograms
+func (t *Telemetry) Measures(now int64) []Measure {
+	var ms []Measure
+	for _, s := range t.Stats() {
+		counter := func(name string, v uint64) Measure {
+			return Measure{
+				Name: "mstreamer_stage_" + name,
+				Tags: []Tag{MakeTag("kind", s.Kind), MakeTag("stage", s.Name), MakeTag(PromTypeTag, "counter")},
+				Flds: []Field{{Name: "counter", Type: TUint, Data: v}},
+				Time: now,
+			}
+		}
+		ms = append(ms, counter("read", s.Read), counter("written", s.Written),
+			counter("dropped", s.Dropped), counter("failed", s.Failed))
+		flds := make([]Field, 0, len(s.Buckets)+3)
+		for i, le := range TelemetryBuckets {
+			flds = append(flds, Field{Name: "bucket_" + strconv.FormatFloat(le, 'g', -1, 64), Type: TUint, Data: s.Buckets[i]})
+		}
+		flds = append(flds,
+			Field{Name: "bucket_+Inf", Type: TUint, Data: s.Count},
+			Field{Name: "sum", Type: TFloat, Data: s.Sum.Seconds()},
+			Field{Name: "count", Type: TUint, Data: s.Count})
+		ms = append(ms, Measure{
+			Name: "mstreamer_stage_latency_seconds",
+			Tags: []Tag{MakeTag("kind", s.Kind), MakeTag("stage", s.Name), MakeTag(PromTypeTag, "histogram")},
+			Flds: flds,
+			Time: now,
+		})
+	}
+	return ms
+}
+
+// NewTelemetryInputContext returns an InputContext emitting the measures of t every interval, so the
+// internal stats go through filters and outputs like any other measure. It ends once its context is done
+func NewTelemetryInputContext(t *Telemetry, interval time.Duration) (InputContext, error) {
+	if t == nil {
+		return nil, errors.New("telemetry is nil")
+	}
+	if interval <= 0 {
+		return nil, errors.New("telemetry interval must be positive")
+	}
+	return NewInputFromProducerContext(func(ctx context.Context, f Feedback, w MeasureWriter) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				for _, m := range t.Measures(now.UnixNano()) {
+					if err := w.Write(m); err != nil {
+						return
+					}
+				}
+			}
+		}
+	})
+}
+
+// stage returns the stats of a stage, creating them on first use
+func (t *Telemetry) stage(kind, name string) *stageStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := stageKey{kind: kind, name: name}
+	s, ok := t.stages[k]
+	if !ok {
+		s = &stageStats{buckets: make([]uint64, len(TelemetryBuckets))}
+		t.stages[k] = s
+	}
+	return s
+}
+
+// stageTelemetry returns the stats of the stage of kind run with ctx, or nil when ctx carries no Telemetry.
+// Every stageStats method is a no-op on nil
+func stageTelemetry(ctx context.Context, kind string) *stageStats {
+	t, ok := ctx.Value(telemetryKey{}).(*Telemetry)
+	if !ok || t == nil {
+		return nil
+	}
+	name, _ := ctx.Value(stageNameKey{}).(string)
+	if name == "" {
+		name = kind
+	}
+	return t.stage(kind, name)
+}
+
+type stageStats struct {
+	read    uint64
+	written uint64
+	dropped uint64
+	failed  uint64
+	mu      sync.Mutex
+	buckets []uint64
+	count   uint64
+	sum     time.Duration
+}
+
+func (s *stageStats) addRead(n uint64) {
+	if s != nil {
+		atomic.AddUint64(&s.read, n)
+	}
+}
+
+func (s *stageStats) addWritten(n uint64) {
+	if s != nil {
+		atomic.AddUint64(&s.written, n)
+	}
+}
+
+func (s *stageStats) addDropped(n uint64) {
+	if s != nil {
+		atomic.AddUint64(&s.dropped, n)
+	}
+}
+
+func (s *stageStats) addFailed(n uint64) {
+	if s != nil {
+		atomic.AddUint64(&s.failed, n)
+	}
+}
+
+// observe records the time elapsed since start
+func (s *stageStats) observe(start time.Time) {
+	if s == nil {
+		return
+	}
+	d := time.Since(start)
+	i := sort.SearchFloat64s(TelemetryBuckets, d.Seconds())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i < len(s.buckets) {
+		s.buckets[i]++
+	}
+	s.count++
+	s.sum += d
+}
+
+func (s *stageStats) snapshot(k stageKey) StageStats {
+	st := StageStats{
+		Kind:    k.kind,
+		Name:    k.name,
+		Read:    atomic.LoadUint64(&s.read),
+		Written: atomic.LoadUint64(&s.written),
+		Dropped: atomic.LoadUint64(&s.dropped),
+		Failed:  atomic.LoadUint64(&s.failed),
+		Buckets: make([]uint64, len(s.buckets)),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cum uint64
+	for i, n := range s.buckets {
+		cum += n
+		st.Buckets[i] = cum
+	}
+	st.Count, st.Sum = s.count, s.sum
+	return st
+}
+
+// writer counts the measures written to w, or returns w when s is nil
+func (s *stageStats) writer(w MeasureWriter) MeasureWriter {
+	if s == nil {
+		return w
+	}
+	return &telemetryWriter{w: w, s: s}
+}
+
+// reader counts the measures read from r, or returns r when s is nil
+func (s *stageStats) reader(r MeasureReader) MeasureReader {
+	if s == nil {
+		return r
+	}
+	return &telemetryReader{r: r, s: s}
+}
+
+type telemetryWriter struct {
+	w MeasureWriter
+	s *stageStats
+	n uint64
+}
+
+func (w *telemetryWriter) Write(m Measure) error {
+	if err := w.w.Write(m); err != nil {
+		w.s.addFailed(1)
+		return err
+	}
+	w.n++
+	w.s.addWritten(1)
+	return nil
+}
+
+// writtenBy returns the measures written so far through a writer returned by stageStats.writer
+func writtenBy(w MeasureWriter) uint64 {
+	if tw, ok := w.(*telemetryWriter); ok {
+		return tw.n
+	}
+	return 0
+}
+
+type telemetryReader struct {
+	r MeasureReader
+	s *stageStats
+}
+
+func (r *telemetryReader) Read(m *Measure) error {
+	err := r.r.Read(m)
+	switch {
+	case err == nil:
+		r.s.addRead(1)
+	case err != io.EOF && err != io.ErrClosedPipe:
+		r.s.addFailed(1)
+	}
+	return err
+}
+
+// Close lets closeMeasureReader reach the wrapped reader
+func (r *telemetryReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package mstreamer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTelemetry(t *testing.T) {
+	tm := NewTelemetry()
+	ctx := WithStageName(WithTelemetry(context.Background(), tm), "evens")
+	flt, err := NewFilterContext(func(ctx context.Context, f Feedback, m *Measure, mw MeasureWriter) {
+		if m.Flds[0].Data.(int64)%2 == 0 {
+			mw.Write(*m)
+		}
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w := NewChanMeasurePipe(0)
+	go func() {
+		defer w.Close()
+		for i := 0; i < 4; i++ {
+			w.Write(Measure{Name: "m", Flds: []Field{{"v", TInt, int64(i)}}})
+		}
+	}()
+	fr, err := flt(ctx, func(string, ...interface{}) {}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		var m Measure
+		if err := fr.Read(&m); err != nil {
+			break
+		}
+	}
+	stats := tm.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("got %v stages want 1", len(stats))
+	}
+	s := stats[0]
+	if s.Kind != "filter" || s.Name != "evens" || s.Read != 4 || s.Written != 2 || s.Dropped != 2 || s.Failed != 0 || s.Count != 4 {
+		t.Errorf("got %+v want 4 read, 2 written, 2 dropped and 4 latencies of filter evens", s)
+	}
+	if last := s.Buckets[len(s.Buckets)-1]; last != 4 {
+		t.Errorf("got %v latencies under the last bucket want 4", last)
+	}
+
+	ms := tm.Measures(42)
+	if len(ms) != 5 {
+		t.Fatalf("got %v measures want 5", len(ms))
+	}
+	if ms[0].Name != "mstreamer_stage_read" || ms[0].Flds[0].Data != uint64(4) || ms[0].Time != 42 {
+		t.Errorf("got %+v want the read counter", ms[0])
+	}
+	if typ, _ := ms[4].TagValue(PromTypeTag); ms[4].Name != "mstreamer_stage_latency_seconds" || typ != "histogram" {
+		t.Errorf("got %+v want the latency histogram", ms[4])
+	}
+}
+
+func TestNewTelemetryInputContext(t *testing.T) {
+	tm := NewTelemetry()
+	stageTelemetry(WithTelemetry(context.Background(), tm), "sinker").addRead(1)
+	inp, err := NewTelemetryInputContext(tm, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := inp(ctx, func(string, ...interface{}) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Measure
+	if err := r.Read(&m); err != nil {
+		t.Fatal(err)
+	}
+	if kind, _ := m.TagValue("kind"); m.Name != "mstreamer_stage_read" || kind != "sinker" {
+		t.Errorf("got %+v want the sinker read counter", m)
+	}
+	cancel()
+	for r.Read(&m) == nil {
+	}
+}
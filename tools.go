@@ -8,4 +8,5 @@ type Helpers struct {
 	IOcopy    func(io.Writer, io.Reader)
 	NewWriter func(io.Writer) MeasureWriter
 	NewReader func(io.Reader) MeasureReader
+	NewPipe   MeasurePipe
 }
@@ -0,0 +1,305 @@
+package mstreamer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WindowAggregates are the aggregates a window filter computes by default
+var WindowAggregates = []string{"min", "max", "sum", "count", "mean", "first", "last", "stddev"}
+
+// WindowOptions configures a window filter
+type WindowOptions struct {
+	// Size is the length of every window
+	Size time.Duration
+	// Slide is the time between the starts of two sliding windows. Zero, or Size, makes tumbling windows
+	Slide time.Duration
+	// GroupBy are the tags that, along the measure name, group measures. Other tags are dropped
+	GroupBy []string
+	// Fields are the fields aggregated, every numeric field by default
+	Fields []string
+	// Aggregates are the aggregates computed per field, WindowAggregates by default
+	Aggregates []string
+}
+
+// Validate checks the window bounds and the aggregate names
+func (o WindowOptions) Validate() error {
+	if o.Size <= 0 {
+		return errors.New("window size must be positive")
+	}
+	if o.Slide < 0 || o.Slide > o.Size {
+		return errors.New("window slide must be between zero and the window size")
+	}
+	for _, a := range o.Aggregates {
+		found := false
+		for _, known := range WindowAggregates {
+			found = found || a == known
+		}
+		if !found {
+			return fmt.Errorf("unknown aggregate %q", a)
+		}
+	}
+	return nil
+}
+
+// NewWindowFilter returns a Filter aggregating measures into time windows aligned on the epoch.
+// Every window emits a measure per group, stamped with the window start and holding a <field>_<aggregate>
+// field per aggregate, where count is a uint and the others are floats. stddev is the sample standard
+// deviation and first and last go by measure time.
+//
+// Windows close once a measure of any group is past their end, measures arriving for a closed window are
+// dropped. The windows still open at the end of the stream are flushed as they are
+func NewWindowFilter(opts WindowOptions) (Filter, error) {
+	return adaptedFilter(windowAdapter(opts))
+}
+
+func windowAdapter(opts WindowOptions) (FilterAdapter, FinalizeAdapter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, nil, err
+	}
+	aggregates := opts.Aggregates
+	if len(aggregates) == 0 {
+		aggregates = WindowAggregates
+	}
+	return windowedAdapter(windowSpec{
+		size:    opts.Size,
+		slide:   opts.Slide,
+		groupBy: opts.GroupBy,
+		fields:  opts.Fields,
+		field:   numericField,
+		accumulator: func() windowAccumulator {
+			return &windowAgg{aggregates: aggregates}
+		},
+	})
+}
+
+// windowAccumulator accumulates the values of a field within a window
+type windowAccumulator interface {
+	add(fld Field, t int64) error
+	// appendFields appends the fields computed out of the values of the field named name
+	appendFields(flds []Field, name string) []Field
+}
+
+// windowSpec tells a windowed filter how to group measures and accumulate their fields
+type windowSpec struct {
+	size    time.Duration
+	slide   time.Duration
+	groupBy []string
+	fields  []string
+	// field names the accumulator a field goes to, false skips the field
+	field       func(fld Field) (string, bool)
+	accumulator func() windowAccumulator
+}
+
+// windowedAdapter returns the adapter and finalizer accumulating the fields of measures grouped by name and
+// the tags of spec.groupBy into windows aligned on the epoch, and emitting every window once closed
+func windowedAdapter(spec windowSpec) (FilterAdapter, FinalizeAdapter, error) {
+	if spec.slide == 0 {
+		spec.slide = spec.size
+	}
+	wanted := make(map[string]bool)
+	for _, name := range spec.fields {
+		wanted[name] = true
+	}
+	var (
+		mu        sync.Mutex
+		windows   = make(map[windowKey]*window)
+		watermark = int64(math.MinInt64)
+	)
+	size, slide := int64(spec.size), int64(spec.slide)
+	flush := func(mw MeasureWriter, all bool) {
+		var closed []*window
+		for k, w := range windows {
+			if all || w.start+size <= watermark {
+				closed = append(closed, w)
+				delete(windows, k)
+			}
+		}
+		sort.Slice(closed, func(i, j int) bool {
+			if closed[i].start != closed[j].start {
+				return closed[i].start < closed[j].start
+			}
+			return closed[i].key < closed[j].key
+		})
+		for _, w := range closed {
+			mw.Write(w.measure())
+		}
+	}
+	adapter := func(f Feedback, m *Measure, mw MeasureWriter) {
+		mu.Lock()
+		defer mu.Unlock()
+		tags := groupTags(m.Tags, spec.groupBy)
+		key := seriesKey(m.Name, tags)
+		late := false
+		for start := m.Time - floorMod(m.Time, slide); start > m.Time-size; start -= slide {
+			if start+size <= watermark {
+				late = true
+				continue
+			}
+			k := windowKey{key: key, start: start}
+			w, ok := windows[k]
+			if !ok {
+				w = &window{key: key, start: start, name: m.Name, tags: tags, accs: make(map[string]windowAccumulator)}
+			}
+			for _, fld := range m.Flds {
+				name, ok := spec.field(fld)
+				if !ok || len(wanted) > 0 && !wanted[name] {
+					continue
+				}
+				acc, ok := w.accs[name]
+				if !ok {
+					acc = spec.accumulator()
+				}
+				if err := acc.add(fld, m.Time); err != nil {
+					f.Warn("field skipped", "measure", m.Name, "field", fld.Name, "err", err)
+					continue
+				}
+				if !ok {
+					w.accs[name] = acc
+					w.order = append(w.order, name)
+				}
+			}
+			// a window opens with its first accumulated field
+			if len(w.accs) > 0 {
+				windows[k] = w
+			}
+		}
+		if late {
+			f.Debug("measure arrived after its window closed", "measure", m.Name, "time", m.Time)
+		}
+		if m.Time > watermark {
+			watermark = m.Time
+			flush(mw, false)
+		}
+	}
+	finalizer := func(f Feedback, mw MeasureWriter) {
+		mu.Lock()
+		defer mu.Unlock()
+		flush(mw, true)
+		watermark = math.MinInt64
+	}
+	return adapter, finalizer, nil
+}
+
+// numericField accumulates the numeric fields under their own name
+func numericField(fld Field) (string, bool) {
+	switch fld.Data.(type) {
+	case float64, int64, uint64:
+		return fld.Name, true
+	}
+	return "", false
+}
+
+// floorMod is the modulo of t by d, positive even for times before the epoch
+func floorMod(t, d int64) int64 {
+	r := t % d
+	if r < 0 {
+		r += d
+	}
+	return r
+}
+
+// groupTags keeps the tags named in groupBy, in that order
+func groupTags(tags []Tag, groupBy []string) []Tag {
+	var kept []Tag
+	for _, name := range groupBy {
+		for _, t := range tags {
+			if t.Name == name {
+				kept = append(kept, t)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+type windowKey struct {
+	key   string
+	start int64
+}
+
+type window struct {
+	key   string
+	start int64
+	name  string
+	tags  []Tag
+	accs  map[string]windowAccumulator
+	order []string
+}
+
+func (w *window) measure() Measure {
+	m := Measure{Name: w.name, Tags: append([]Tag(nil), w.tags...), Time: w.start}
+	for _, name := range w.order {
+		m.Flds = w.accs[name].appendFields(m.Flds, name)
+	}
+	return m
+}
+
+// windowAgg keeps the running aggregates of a field, mean and m2 as in Welford's algorithm
+type windowAgg struct {
+	aggregates []string
+	count      uint64
+	sum        float64
+	min        float64
+	max        float64
+	mean       float64
+	m2         float64
+	first      float64
+	firstTime  int64
+	last       float64
+	lastTime   int64
+}
+
+func (a *windowAgg) add(fld Field, t int64) error {
+	v := toFloat(fld)
+	if a.count == 0 {
+		a.min, a.max, a.first, a.firstTime, a.last, a.lastTime = v, v, v, t, v, t
+	}
+	a.count++
+	a.sum += v
+	a.min = math.Min(a.min, v)
+	a.max = math.Max(a.max, v)
+	d := v - a.mean
+	a.mean += d / float64(a.count)
+	a.m2 += d * (v - a.mean)
+	if t < a.firstTime {
+		a.first, a.firstTime = v, t
+	}
+	if t >= a.lastTime {
+		a.last, a.lastTime = v, t
+	}
+	return nil
+}
+
+func (a *windowAgg) appendFields(flds []Field, name string) []Field {
+	for _, agg := range a.aggregates {
+		fld := Field{Name: name + "_" + agg, Type: TFloat}
+		switch agg {
+		case "min":
+			fld.Data = a.min
+		case "max":
+			fld.Data = a.max
+		case "sum":
+			fld.Data = a.sum
+		case "count":
+			fld.Type, fld.Data = TUint, a.count
+		case "mean":
+			fld.Data = a.mean
+		case "first":
+			fld.Data = a.first
+		case "last":
+			fld.Data = a.last
+		case "stddev":
+			fld.Data = 0.0
+			if a.count > 1 {
+				fld.Data = math.Sqrt(a.m2 / float64(a.count-1))
+			}
+		}
+		flds = append(flds, fld)
+	}
+	return flds
+}
@@ -0,0 +1,142 @@
+package mstreamer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewWindowFilter(t *testing.T) {
+	sec := int64(time.Second)
+	sample := func(v float64, at int64, tags ...Tag) Measure {
+		return Measure{Name: "cpu", Tags: tags, Flds: []Field{{"usage", TFloat, v}}, Time: at * sec}
+	}
+	type window struct {
+		start int64
+		tags  []Tag
+		flds  map[string]interface{}
+	}
+	tests := []struct {
+		name string
+		opts WindowOptions
+		in   []Measure
+		want []window
+	}{
+		{
+			name: `when measures span tumbling windows then every window emits its aggregates`,
+			opts: WindowOptions{Size: time.Minute},
+			in:   []Measure{sample(2, 0), sample(4, 30), sample(9, 59), sample(1, 60), sample(3, 90)},
+			want: []window{
+				{start: 0, flds: map[string]interface{}{"usage_min": 2.0, "usage_max": 9.0, "usage_sum": 15.0,
+					"usage_count": uint64(3), "usage_mean": 5.0, "usage_first": 2.0, "usage_last": 9.0, "usage_stddev": 3.605551275463989}},
+				{start: 60, flds: map[string]interface{}{"usage_min": 1.0, "usage_max": 3.0, "usage_sum": 4.0,
+					"usage_count": uint64(2), "usage_mean": 2.0, "usage_first": 1.0, "usage_last": 3.0, "usage_stddev": 1.4142135623730951}},
+			},
+		},
+		{
+			name: `when windows slide then a measure counts in every window covering it`,
+			opts: WindowOptions{Size: 20 * time.Second, Slide: 10 * time.Second, Aggregates: []string{"count"}},
+			in:   []Measure{sample(1, 5), sample(1, 15), sample(1, 25)},
+			want: []window{
+				{start: -10, flds: map[string]interface{}{"usage_count": uint64(1)}},
+				{start: 0, flds: map[string]interface{}{"usage_count": uint64(2)}},
+				{start: 10, flds: map[string]interface{}{"usage_count": uint64(2)}},
+				{start: 20, flds: map[string]interface{}{"usage_count": uint64(1)}},
+			},
+		},
+		{
+			name: `when grouping by a tag then other tags are dropped and groups aggregate apart`,
+			opts: WindowOptions{Size: time.Minute, GroupBy: []string{"host"}, Aggregates: []string{"sum"}},
+			in: []Measure{
+				sample(1, 0, Tag{"host", "a"}, Tag{"core", "0"}),
+				sample(2, 1, Tag{"host", "b"}),
+				sample(3, 2, Tag{"core", "1"}, Tag{"host", "a"}),
+			},
+			want: []window{
+				{start: 0, tags: []Tag{{"host", "a"}}, flds: map[string]interface{}{"usage_sum": 4.0}},
+				{start: 0, tags: []Tag{{"host", "b"}}, flds: map[string]interface{}{"usage_sum": 2.0}},
+			},
+		},
+		{
+			name: `when measure is late then its closed window ignores it`,
+			opts: WindowOptions{Size: time.Minute, Aggregates: []string{"count"}},
+			in:   []Measure{sample(1, 0), sample(1, 61), sample(1, 30)},
+			want: []window{
+				{start: 0, flds: map[string]interface{}{"usage_count": uint64(1)}},
+				{start: 60, flds: map[string]interface{}{"usage_count": uint64(1)}},
+			},
+		},
+		{
+			name: `when measure has no accumulated field then it opens no window`,
+			opts: WindowOptions{Size: time.Minute, Fields: []string{"usage"}, Aggregates: []string{"count"}},
+			in: []Measure{
+				sample(1, 0),
+				{Name: "cpu", Flds: []Field{{"state", TString, "idle"}}, Time: 5 * sec},
+				{Name: "mem", Flds: []Field{{"free", TFloat, 1.0}}, Time: 10 * sec},
+			},
+			want: []window{
+				{start: 0, flds: map[string]interface{}{"usage_count": uint64(1)}},
+			},
+		},
+		{
+			name: `when measures arrive out of order then first and last go by time`,
+			opts: WindowOptions{Size: time.Minute, Aggregates: []string{"first", "last"}},
+			in:   []Measure{sample(2, 10), sample(1, 5), sample(3, 20)},
+			want: []window{
+				{start: 0, flds: map[string]interface{}{"usage_first": 1.0, "usage_last": 3.0}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flt, err := NewWindowFilter(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, w := NewChanMeasurePipe(len(tt.in))
+			for _, m := range tt.in {
+				w.Write(m)
+			}
+			w.Close()
+			fr, err := flt(func(string, ...interface{}) {}, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []window
+			for {
+				var m Measure
+				if err := fr.Read(&m); err != nil {
+					break
+				}
+				flds := make(map[string]interface{})
+				for _, f := range m.Flds {
+					flds[f.Name] = f.Data
+				}
+				got = append(got, window{start: m.Time / sec, tags: m.Tags, flds: flds})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got windows %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    WindowOptions
+		wantErr bool
+	}{
+		{name: `when size is missing then it fails`, opts: WindowOptions{}, wantErr: true},
+		{name: `when slide exceeds size then it fails`, opts: WindowOptions{Size: time.Second, Slide: time.Minute}, wantErr: true},
+		{name: `when aggregate is unknown then it fails`, opts: WindowOptions{Size: time.Second, Aggregates: []string{"median"}}, wantErr: true},
+		{name: `when options are set then they pass`, opts: WindowOptions{Size: time.Minute, Slide: time.Second, Aggregates: []string{"mean"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}